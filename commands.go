@@ -0,0 +1,294 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/blang/photowall/config"
+	"github.com/blang/photowall/wall"
+	"github.com/blang/photowall/web"
+	"log/slog"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"text/tabwriter"
+	"time"
+)
+
+// command is a subcommand of photowall, selected by the first argument
+type command struct {
+	name string
+	args string // Arguments after the flags, for the usage
+	help string
+	run  func(fs *flag.FlagSet, args []string) int
+}
+
+// commands are run by main, serve is the default
+var commands []command
+
+func init() {
+	commands = []command{
+		{"serve", "", "Run the photowall server (default)", runServe},
+		{"import", "<archive.zip|directory>", "Import photos through the pipeline", runImport},
+		{"export", "<file.zip>", "Write a ZIP of the photos with a manifest", runExport},
+		{"list", "", "List the photos of the store", runList},
+		{"rm", "<id>...", "Remove photos with their metadata and originals", runRemove},
+		{"reindex", "", "Rewrite the metadata of all photos, creating missing metadata", runReindex},
+		{"verify", "", "Check the photos of the store against their metadata, -repair fixes them", runVerify},
+		{"help", "", "Show this help", runHelp},
+	}
+}
+
+// commandFlags creates the flag set of cmd, the settings are added by config.Parse
+func commandFlags(cmd command) *flag.FlagSet {
+	fs := flag.NewFlagSet("photowall "+cmd.name, flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: photowall %s [flags] %s\n\n%s.\n\nFlags:\n", cmd.name, cmd.args, cmd.help)
+		fs.PrintDefaults()
+	}
+	return fs
+}
+
+func printCommands() {
+	fmt.Fprintf(os.Stderr, "Usage: photowall [command] [flags]\n\nCommands:\n")
+	w := tabwriter.NewWriter(os.Stderr, 0, 0, 2, ' ', 0)
+	for _, cmd := range commands {
+		fmt.Fprintf(w, "  %s %s\t%s\n", cmd.name, cmd.args, cmd.help)
+	}
+	w.Flush()
+	fmt.Fprintf(os.Stderr, "\nAll commands take the settings of serve, see photowall <command> -h.\n")
+}
+
+func runHelp(fs *flag.FlagSet, args []string) int {
+	printCommands()
+	return 0
+}
+
+// openOffline parses the settings and opens the store for a command expecting
+// nargs arguments, at least one if negative, see openParsed. Errors are printed,
+// the exit code is returned.
+func openOffline(fs *flag.FlagSet, args []string, nargs int, write bool) (*photoStore, *config.Config, int) {
+	cfg, logger, code := parseOffline(fs, args, nargs)
+	if cfg == nil {
+		return nil, nil, code
+	}
+	ps, code := openParsed(cfg, logger, write)
+	return ps, cfg, code
+}
+
+// parseOffline parses the settings of a command expecting nargs arguments,
+// at least one if negative. Errors are printed, the exit code is returned.
+func parseOffline(fs *flag.FlagSet, args []string, nargs int) (*config.Config, *slog.Logger, int) {
+	cfg, err := config.Parse(fs, args, os.LookupEnv)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Invalid configuration:\n%s\n", err)
+		return nil, nil, 2
+	}
+	if (nargs < 0 && fs.NArg() == 0) || (nargs >= 0 && fs.NArg() != nargs) {
+		fs.Usage()
+		return nil, nil, 2
+	}
+	logger := newLogger(cfg, slog.LevelWarn)
+	slog.SetDefault(logger)
+	return cfg, logger, 0
+}
+
+// openParsed opens the store for a command. Commands changing the store lock it,
+// they fail while the server runs. Read-only commands only load the photos by
+// loadStore. Errors are printed, the exit code is returned.
+func openParsed(cfg *config.Config, logger *slog.Logger, write bool) (*photoStore, int) {
+	var ps *photoStore
+	var err error
+	if write {
+		ps, err = openStore(cfg, logger)
+	} else {
+		ps, err = loadStore(cfg)
+	}
+	if err != nil {
+		return nil, storeError(cfg, err)
+	}
+	return ps, 0
+}
+
+// storeError prints an error opening or locking the store, the exit code is returned
+func storeError(cfg *config.Config, err error) int {
+	if errors.Is(err, wall.ErrLocked) {
+		fmt.Fprintf(os.Stderr, "%s: %s\nStop the server or use the admin API.\n", cfg.StoreDir, err)
+	} else {
+		fmt.Fprintf(os.Stderr, "Invalid storage directory %s: %s\n", cfg.StoreDir, err)
+	}
+	return 1
+}
+
+func runExport(fs *flag.FlagSet, args []string) int {
+	o := web.ExportOptions{Uploaders: true}
+	fs.StringVar(&o.Names, "names", "stored", "File names in the archive: stored, filename (as uploaded) or caption")
+	fs.BoolVar(&o.Originals, "originals", false, "Include the originals, if kept")
+	fs.StringVar(&o.Manifest, "manifest", "json", "Manifest format: json, csv or none")
+	fs.StringVar(&o.Status, "status", string(wall.StatusPublished), "Export photos with this status, empty for all")
+	ps, _, code := openOffline(fs, args, 1, false)
+	if ps == nil {
+		return code
+	}
+	if err := o.Validate(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+	f, err := os.Create(fs.Arg(0))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	n, err := web.WriteExport(f, ps.photos, ps.originals, o)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Export failed: %s\n", err)
+		os.Remove(fs.Arg(0))
+		return 1
+	}
+	fmt.Printf("%d photos exported to %s\n", n, fs.Arg(0))
+	return 0
+}
+
+func runList(fs *flag.FlagSet, args []string) int {
+	status := fs.String("status", "", "List photos with this status only, e.g. pending")
+	asJSON := fs.Bool("json", false, "Print the photos as JSON")
+	ps, _, code := openOffline(fs, args, 0, false)
+	if ps == nil {
+		return code
+	}
+	photos := ps.photos
+	if *status != "" {
+		photos = photos.WithStatus(wall.Status(*status))
+	}
+	wall.SortPhotos(photos)
+	if *asJSON {
+		type listPhoto struct {
+			ID        string    `json:"id"`
+			File      string    `json:"file"`
+			Status    string    `json:"status"`
+			Filename  string    `json:"filename,omitempty"`
+			Caption   string    `json:"caption,omitempty"`
+			Author    string    `json:"author,omitempty"`
+			Uploader  string    `json:"uploader,omitempty"`
+			CreatedAt time.Time `json:"created_at"`
+		}
+		list := []listPhoto{}
+		for _, p := range photos {
+			list = append(list, listPhoto{p.ID(), p.Name(), string(p.Status()), p.Filename(), p.Caption(), p.Author(), p.Uploader(), p.CreatedAt()})
+		}
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		enc.Encode(list)
+		return 0
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tCREATED\tSTATUS\tFILE\tCAPTION")
+	for _, p := range photos {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", p.ID(), p.CreatedAt().Format("2006-01-02 15:04:05"), p.Status(), filepath.Base(p.Name()), p.Caption())
+	}
+	w.Flush()
+	return 0
+}
+
+func runRemove(fs *flag.FlagSet, args []string) int {
+	ps, _, code := openOffline(fs, args, -1, true)
+	if ps == nil {
+		return code
+	}
+	for _, id := range fs.Args() {
+		if err := ps.wall.RemovePhotoByID(id); err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %s\n", id, err)
+			code = 1
+			continue
+		}
+		fmt.Printf("Removed %s\n", id)
+	}
+	if err := ps.close(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	return code
+}
+
+func runReindex(fs *flag.FlagSet, args []string) int {
+	ps, _, code := openOffline(fs, args, 0, true)
+	if ps == nil {
+		return code
+	}
+	// Restoring recomputed checksums and sizes, and ids of photos without metadata
+	photos := ps.wall.Photos()
+	for _, p := range photos {
+		ps.store.Save(p)
+	}
+	if err := ps.close(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	fmt.Printf("%d photos reindexed\n", len(photos))
+	return 0
+}
+
+func runVerify(fs *flag.FlagSet, args []string) int {
+	repair := fs.Bool("repair", false, "Repair the problems found: regenerate from originals, remove dangling metadata, import unreferenced files")
+	cfg, logger, code := parseOffline(fs, args, 0)
+	if cfg == nil {
+		return code
+	}
+	// Checking is read-only, repairing needs the store exclusively
+	ps, code := openParsed(cfg, logger, *repair)
+	if ps == nil {
+		return code
+	}
+	problems, err := ps.store.Verify(ps.originals)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Verify failed: %s\n", err)
+		ps.close()
+		return 1
+	}
+	if *repair && len(problems) > 0 {
+		processors, err := cfg.Processors(ps.store, ps.originals)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Could not create pipeline: %s\n", err)
+			ps.close()
+			return 1
+		}
+		ps.wall.SetProcessors(processors)
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+		defer stop()
+		problems = ps.store.Repair(ctx, ps.wall, ps.originals, problems)
+	}
+	if *repair {
+		if err = ps.close(); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	for _, p := range problems {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", p.Kind, p.File, p.Detail, p.Repair)
+	}
+	w.Flush()
+	switch {
+	case len(problems) == 0:
+		fmt.Println("Store is consistent")
+		return 0
+	case *repair:
+		fmt.Printf("%d problems found, see the repairs\n", len(problems))
+		for _, p := range problems {
+			if p.Repair == "" || strings.HasPrefix(p.Repair, "failed") || strings.HasPrefix(p.Repair, "not repairable") {
+				return 1
+			}
+		}
+		return 0
+	default:
+		fmt.Printf("%d problems found, repair with -repair\n", len(problems))
+		return 1
+	}
+}
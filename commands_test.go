@@ -0,0 +1,89 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"github.com/blang/photowall/config"
+	"github.com/blang/photowall/wall"
+	"image"
+	"image/jpeg"
+	"io"
+	"io/ioutil"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// runCommand runs the command name with args like main
+func runCommand(name string, args ...string) int {
+	for _, cmd := range commands {
+		if cmd.name == name {
+			return cmd.run(commandFlags(cmd), args)
+		}
+	}
+	return -1
+}
+
+func TestStoreLock(t *testing.T) {
+	dir, err := ioutil.TempDir("", "commands")
+	if err != nil {
+		t.Fatalf("Could not create tmp dir: %s", err)
+	}
+	defer os.RemoveAll(dir)
+	storeDir, source := filepath.Join(dir, "store"), filepath.Join(dir, "source")
+	os.Mkdir(source, 0755)
+	f, err := os.Create(filepath.Join(source, "photo.jpg"))
+	if err != nil {
+		t.Fatalf("Could not create image: %s", err)
+	}
+	jpeg.Encode(f, image.NewRGBA(image.Rect(0, 0, 200, 100)), nil)
+	f.Close()
+	if code := runCommand("import", "-storedir", storeDir, source); code != 0 {
+		t.Fatalf("Could not import: %d", code)
+	}
+
+	// The server holds the lock while running
+	cfg, err := config.Parse(flag.NewFlagSet("test", flag.ContinueOnError), []string{"-storedir", storeDir}, os.LookupEnv)
+	if err != nil {
+		t.Fatalf("Could not parse config: %s", err)
+	}
+	ps, err := openStore(cfg, slog.New(slog.NewTextHandler(io.Discard, nil)))
+	if err != nil {
+		t.Fatalf("Could not open store: %s", err)
+	}
+	if len(ps.wall.Photos()) != 1 {
+		t.Fatalf("Imported photo not restored")
+	}
+	if _, err = openStore(cfg, slog.New(slog.NewTextHandler(io.Discard, nil))); !errors.Is(err, wall.ErrLocked) {
+		t.Errorf("Second writer not locked out: %v", err)
+	}
+	if code := runCommand("import", "-storedir", storeDir, source); code != 1 {
+		t.Errorf("Import into a locked store: %d", code)
+	}
+	if code := runCommand("verify", "-storedir", storeDir, "-repair"); code != 1 {
+		t.Errorf("Repair of a locked store: %d", code)
+	}
+
+	// Reading commands work while the server runs
+	if code := runCommand("list", "-storedir", storeDir); code != 0 {
+		t.Errorf("Could not list a locked store: %d", code)
+	}
+	export := filepath.Join(dir, "export.zip")
+	if code := runCommand("export", "-storedir", storeDir, export); code != 0 {
+		t.Errorf("Could not export a locked store: %d", code)
+	}
+	if info, err := os.Stat(export); err != nil || info.Size() == 0 {
+		t.Errorf("Export not written: %v", err)
+	}
+	if code := runCommand("verify", "-storedir", storeDir); code != 0 {
+		t.Errorf("Could not verify a locked store: %d", code)
+	}
+
+	if err = ps.close(); err != nil {
+		t.Fatalf("Could not close store: %s", err)
+	}
+	if code := runCommand("verify", "-storedir", storeDir, "-repair"); code != 0 {
+		t.Errorf("Could not repair after the lock was released: %d", code)
+	}
+}
@@ -0,0 +1,45 @@
+package config
+
+import (
+	"fmt"
+	"github.com/blang/photowall/web"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// AdminConfig declares an account allowed to log in to the admin API
+type AdminConfig struct {
+	Name         string `yaml:"name"`
+	PasswordHash string `yaml:"password_hash"` // bcrypt, e.g. by htpasswd -nbBC 10 "" password
+	Role         string `yaml:"role"`          // owner, moderator or display
+}
+
+func (c *Config) validateAdmins() []error {
+	var errs []error
+	names := make(map[string]bool)
+	for i, a := range c.Admins {
+		prefix := fmt.Sprintf("admins[%d]", i)
+		check := func(ok bool, format string, args ...interface{}) {
+			if !ok {
+				errs = append(errs, fmt.Errorf(prefix+": "+format, args...))
+			}
+		}
+		check(a.Name != "", "name required")
+		check(!names[a.Name], "duplicate name %q", a.Name)
+		names[a.Name] = true
+		_, err := bcrypt.Cost([]byte(a.PasswordHash))
+		check(err == nil, "password_hash: bcrypt hash required")
+		_, err = web.ParseRole(a.Role)
+		check(err == nil, "unknown role %q, expected owner, moderator or display", a.Role)
+	}
+	return errs
+}
+
+// AdminAccounts returns the accounts for web.Server.SetAdmins
+func (c *Config) AdminAccounts() []web.Admin {
+	var admins []web.Admin
+	for _, a := range c.Admins {
+		role, _ := web.ParseRole(a.Role)
+		admins = append(admins, web.Admin{Name: a.Name, PasswordHash: a.PasswordHash, Role: role})
+	}
+	return admins
+}
@@ -0,0 +1,389 @@
+// Package config loads the photowall configuration from a YAML file,
+// PHOTOWALL_* environment variables and command line flags.
+package config
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/blang/photowall/web"
+	"golang.org/x/crypto/acme/autocert"
+	"gopkg.in/yaml.v3"
+	"io"
+	"net/url"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// EnvPrefix is the prefix of environment variables overriding settings, e.g. PHOTOWALL_LISTEN
+const EnvPrefix = "PHOTOWALL_"
+
+// Config holds all settings. Each setting has the same name as yaml key,
+// flag and (upper case, prefixed) environment variable.
+type Config struct {
+	Listen          string        `yaml:"listen"`
+	StoreDir        string        `yaml:"storedir"`
+	StaticDir       string        `yaml:"staticdir"`
+	Allow           string        `yaml:"allow"`
+	MaxFileSize     int           `yaml:"filesize_max"`
+	MaxMegapixels   int           `yaml:"megapixels_max"`
+	ImgWidth        uint          `yaml:"img_width"`
+	ImgHeight       uint          `yaml:"img_height"`
+	QuarantineDir   string        `yaml:"quarantinedir"`
+	Blocklist       string        `yaml:"blocklist"`
+	BlocklistAction string        `yaml:"blocklist_action"`
+	ProcessTimeout  time.Duration `yaml:"process_timeout"`
+	ShutdownTimeout time.Duration `yaml:"shutdown_timeout"`
+	SlideInterval   time.Duration `yaml:"slide_interval"`
+	PublicURL       string        `yaml:"public_url"`
+	QRInterval      time.Duration `yaml:"qr_interval"`
+	QRDuration      time.Duration `yaml:"qr_duration"`
+	UploadAccess    string        `yaml:"upload_access"`
+	UploadPIN       string        `yaml:"upload_pin"`
+	TokensFile      string        `yaml:"tokens_file"`
+	UploadRate      int           `yaml:"upload_rate"`
+	UploadBurst     int           `yaml:"upload_burst"`
+	UploadQuota     int           `yaml:"upload_quota"`
+	RateLimitKey    string        `yaml:"rate_limit_key"`
+	SessionTTL      time.Duration `yaml:"session_ttl"`
+	LocalAdmin      bool          `yaml:"local_admin"`
+	DeleteWindow    time.Duration `yaml:"delete_window"`
+	KeepOriginals   bool          `yaml:"keep_originals"`
+	GuestExport     bool          `yaml:"guest_export"`
+	WatchDirs       string        `yaml:"watch_dirs"`
+	WatchSettle     time.Duration `yaml:"watch_settle"`
+	TLSCert         string        `yaml:"tls_cert"`
+	TLSKey          string        `yaml:"tls_key"`
+	TLSSelfSigned   bool          `yaml:"tls_self_signed"`
+	TLSDir          string        `yaml:"tls_dir"`
+	ACMEDomains     string        `yaml:"acme_domains"`
+	ACMEEmail       string        `yaml:"acme_email"`
+	ACMEDirectory   string        `yaml:"acme_directory"`
+	ACMERootCA      string        `yaml:"acme_root_ca"`
+	HTTPRedirect    string        `yaml:"http_redirect"`
+	LogJSON         bool          `yaml:"log_json"`
+	LogDebug        bool          `yaml:"log_debug"`
+
+	// Pipeline defines the processors for uploads in order, see Processors.
+	// Only settable by the config file.
+	Pipeline []ProcessorConfig `yaml:"pipeline"`
+
+	// Admins may log in to the admin API, see AdminConfig.
+	// Only settable by the config file.
+	Admins []AdminConfig `yaml:"admins"`
+}
+
+// Default returns the default configuration
+func Default() *Config {
+	return &Config{
+		Listen:          ":8000",
+		StoreDir:        "./imgs",
+		Allow:           "png,jpg",
+		MaxFileSize:     10,
+		MaxMegapixels:   50,
+		ImgWidth:        1920,
+		ImgHeight:       1080,
+		BlocklistAction: "mask",
+		ProcessTimeout:  2 * time.Minute,
+		ShutdownTimeout: 30 * time.Second,
+		SlideInterval:   3 * time.Second,
+		QRInterval:      time.Minute,
+		QRDuration:      10 * time.Second,
+		UploadAccess:    "open",
+		TokensFile:      "./tokens.json",
+		UploadRate:      10,
+		UploadBurst:     5,
+		RateLimitKey:    "ip",
+		SessionTTL:      12 * time.Hour,
+		DeleteWindow:    15 * time.Minute,
+		WatchSettle:     2 * time.Second,
+		TLSDir:          "./tls",
+		ACMEDirectory:   autocert.DefaultACMEDirectory,
+	}
+}
+
+// RegisterFlags defines a flag for every setting on fs, using the current values as defaults
+func (c *Config) RegisterFlags(fs *flag.FlagSet) {
+	fs.StringVar(&c.Listen, "listen", c.Listen, "Listen addr")
+	fs.StringVar(&c.StoreDir, "storedir", c.StoreDir, "Storage directory, relative to the working directory")
+	fs.StringVar(&c.StaticDir, "staticdir", c.StaticDir, "Directory with frontend files overriding the embedded ones, e.g. for custom themes")
+	fs.StringVar(&c.Allow, "allow", c.Allow, "Allowed file extensions")
+	fs.IntVar(&c.MaxFileSize, "filesize_max", c.MaxFileSize, "Maximum upload filesize in MB")
+	fs.IntVar(&c.MaxMegapixels, "megapixels_max", c.MaxMegapixels, "Reject images with more megapixels")
+	fs.UintVar(&c.ImgWidth, "img_width", c.ImgWidth, "Resize bigger images to this width")
+	fs.UintVar(&c.ImgHeight, "img_height", c.ImgHeight, "Resize bigger images to this height")
+	fs.StringVar(&c.QuarantineDir, "quarantinedir", c.QuarantineDir, "Move uploads failing to process to this directory")
+	fs.StringVar(&c.Blocklist, "blocklist", c.Blocklist, "File of words not allowed in captions and names, one per line")
+	fs.StringVar(&c.BlocklistAction, "blocklist_action", c.BlocklistAction, "Action for blocked words: mask, reject or moderate")
+	fs.DurationVar(&c.ProcessTimeout, "process_timeout", c.ProcessTimeout, "Abort processing of a single photo after this duration")
+	fs.DurationVar(&c.ShutdownTimeout, "shutdown_timeout", c.ShutdownTimeout, "Time to finish running uploads on shutdown before aborting them")
+	fs.DurationVar(&c.SlideInterval, "slide_interval", c.SlideInterval, "Time each photo is shown on the wall")
+	fs.StringVar(&c.PublicURL, "public_url", c.PublicURL, "URL guests use to reach the photowall, for the QR code, e.g. https://wall.example.com")
+	fs.DurationVar(&c.QRInterval, "qr_interval", c.QRInterval, "Show the QR code of the upload page on the wall at this interval, 0 disables it")
+	fs.DurationVar(&c.QRDuration, "qr_duration", c.QRDuration, "Time the QR code is shown on the wall")
+	fs.StringVar(&c.UploadAccess, "upload_access", c.UploadAccess, "Required to upload: open, pin (upload_pin) or token (invite tokens)")
+	fs.StringVar(&c.UploadPIN, "upload_pin", c.UploadPIN, "Event pin required to upload if upload_access is pin")
+	fs.StringVar(&c.TokensFile, "tokens_file", c.TokensFile, "File storing the invite tokens")
+	fs.IntVar(&c.UploadRate, "upload_rate", c.UploadRate, "Uploads per minute and guest, 0 disables the rate limit")
+	fs.IntVar(&c.UploadBurst, "upload_burst", c.UploadBurst, "Uploads a guest may do at once before upload_rate applies")
+	fs.IntVar(&c.UploadQuota, "upload_quota", c.UploadQuota, "Total uploads per guest, 0 for unlimited")
+	fs.StringVar(&c.RateLimitKey, "rate_limit_key", c.RateLimitKey, "Tell guests apart by ip, device (cookie) or both")
+	fs.DurationVar(&c.SessionTTL, "session_ttl", c.SessionTTL, "Time admins stay logged in")
+	fs.BoolVar(&c.LocalAdmin, "local_admin", c.LocalAdmin, "Allow the admin API from the local machine without login while no admins are configured")
+	fs.DurationVar(&c.DeleteWindow, "delete_window", c.DeleteWindow, "Time guests may delete their own upload, 0 disables it")
+	fs.BoolVar(&c.KeepOriginals, "keep_originals", c.KeepOriginals, "Keep uploads before resizing in storedir/originals, for the default pipeline")
+	fs.BoolVar(&c.GuestExport, "guest_export", c.GuestExport, "Allow guests to download all published photos as ZIP")
+	fs.StringVar(&c.WatchDirs, "watch_dirs", c.WatchDirs, "Add new photos in these directories, separated by commas, e.g. of a tethered camera")
+	fs.DurationVar(&c.WatchSettle, "watch_settle", c.WatchSettle, "Time a new file in watch_dirs must be unchanged before it's added")
+	fs.StringVar(&c.TLSCert, "tls_cert", c.TLSCert, "Serve https with this certificate file, requires tls_key")
+	fs.StringVar(&c.TLSKey, "tls_key", c.TLSKey, "Private key file of tls_cert")
+	fs.BoolVar(&c.TLSSelfSigned, "tls_self_signed", c.TLSSelfSigned, "Serve https with a self-signed certificate, generated on first start")
+	fs.StringVar(&c.TLSDir, "tls_dir", c.TLSDir, "Directory for generated and ACME certificates")
+	fs.StringVar(&c.ACMEDomains, "acme_domains", c.ACMEDomains, "Serve https with certificates requested by ACME for these domains, separated by commas")
+	fs.StringVar(&c.ACMEEmail, "acme_email", c.ACMEEmail, "Contact email for the ACME account")
+	fs.StringVar(&c.ACMEDirectory, "acme_directory", c.ACMEDirectory, "Directory URL of the ACME server")
+	fs.StringVar(&c.ACMERootCA, "acme_root_ca", c.ACMERootCA, "PEM file with the CA of the ACME server, for test servers")
+	fs.StringVar(&c.HTTPRedirect, "http_redirect", c.HTTPRedirect, "Listen addr for plain http redirecting to https, e.g. :80")
+	fs.BoolVar(&c.LogJSON, "log_json", c.LogJSON, "Log in JSON format")
+	fs.BoolVar(&c.LogDebug, "log_debug", c.LogDebug, "Log debug messages like processor timings")
+}
+
+// Parse builds the configuration from defaults, the config file given by the -config flag,
+// environment variables and flags, later sources overriding earlier ones.
+// lookupEnv is usually os.LookupEnv.
+func Parse(fs *flag.FlagSet, args []string, lookupEnv func(string) (string, bool)) (*Config, error) {
+	c := Default()
+	c.RegisterFlags(fs)
+	path := fs.String("config", "", "YAML config file, settings are overridden by "+EnvPrefix+"* environment variables and flags")
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+
+	// Remember explicitly set flags, to apply them on top of file and environment
+	set := make(map[string]string)
+	fs.Visit(func(f *flag.Flag) {
+		set[f.Name] = f.Value.String()
+	})
+
+	loaded := Default()
+	if *path != "" {
+		if err := loaded.LoadFile(*path); err != nil {
+			return nil, err
+		}
+	}
+	if err := loaded.ApplyEnv(lookupEnv); err != nil {
+		return nil, err
+	}
+	*c = *loaded
+	for name, value := range set {
+		if err := fs.Set(name, value); err != nil {
+			return nil, err
+		}
+	}
+	if err := c.Validate(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// LoadFile reads the YAML config file into c, unknown keys are rejected
+func (c *Config) LoadFile(path string) error {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	dec := yaml.NewDecoder(bytes.NewReader(b))
+	dec.KnownFields(true)
+	if err = dec.Decode(c); err != nil && err != io.EOF {
+		return fmt.Errorf("config file %s: %w", path, err)
+	}
+	return nil
+}
+
+// ApplyEnv overrides settings by environment variables named EnvPrefix + upper case yaml key
+func (c *Config) ApplyEnv(lookupEnv func(string) (string, bool)) error {
+	v := reflect.ValueOf(c).Elem()
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		key := strings.Split(t.Field(i).Tag.Get("yaml"), ",")[0]
+		name := EnvPrefix + strings.ToUpper(key)
+		value, ok := lookupEnv(name)
+		if !ok {
+			continue
+		}
+		if err := setValue(v.Field(i), value); err != nil {
+			return fmt.Errorf("%s: %w", name, err)
+		}
+	}
+	return nil
+}
+
+var durationType = reflect.TypeOf(time.Duration(0))
+
+// setValue parses s into the field, only scalar fields are supported
+func setValue(field reflect.Value, s string) error {
+	switch {
+	case field.Type() == durationType:
+		d, err := time.ParseDuration(s)
+		if err != nil {
+			return err
+		}
+		field.SetInt(int64(d))
+	case field.Kind() == reflect.String:
+		field.SetString(s)
+	case field.Kind() == reflect.Bool:
+		b, err := strconv.ParseBool(s)
+		if err != nil {
+			return err
+		}
+		field.SetBool(b)
+	case field.Kind() == reflect.Int:
+		n, err := strconv.Atoi(s)
+		if err != nil {
+			return err
+		}
+		field.SetInt(int64(n))
+	case field.Kind() == reflect.Uint:
+		n, err := strconv.ParseUint(s, 10, 0)
+		if err != nil {
+			return err
+		}
+		field.SetUint(n)
+	default:
+		return errors.New("can't be set by environment")
+	}
+	return nil
+}
+
+// Validate checks all settings and the pipeline, reporting all problems at once
+func (c *Config) Validate() error {
+	var errs []error
+	check := func(ok bool, format string, args ...interface{}) {
+		if !ok {
+			errs = append(errs, fmt.Errorf(format, args...))
+		}
+	}
+	check(c.Listen != "", "listen: must not be empty")
+	check(c.StoreDir != "", "storedir: must not be empty")
+	check(strings.Trim(c.Allow, ", ") != "", "allow: at least one extension required")
+	check(c.MaxFileSize > 0, "filesize_max: must be positive, got %d", c.MaxFileSize)
+	check(c.MaxMegapixels >= 0, "megapixels_max: must not be negative, got %d", c.MaxMegapixels)
+	check(c.ImgWidth > 0, "img_width: must be positive")
+	check(c.ImgHeight > 0, "img_height: must be positive")
+	check(c.ProcessTimeout >= 0, "process_timeout: must not be negative, got %s", c.ProcessTimeout)
+	check(c.ShutdownTimeout > 0, "shutdown_timeout: must be positive, got %s", c.ShutdownTimeout)
+	check(c.SlideInterval >= time.Second, "slide_interval: must be at least 1s, got %s", c.SlideInterval)
+	if c.PublicURL != "" {
+		u, err := url.Parse(c.PublicURL)
+		check(err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != "", "public_url: must be an absolute http or https url, got %q", c.PublicURL)
+	}
+	check(c.QRInterval >= 0, "qr_interval: must not be negative, got %s", c.QRInterval)
+	check(c.QRInterval == 0 || (c.QRDuration > 0 && c.QRDuration < c.QRInterval), "qr_duration: must be positive and shorter than qr_interval, got %s", c.QRDuration)
+	access, err := web.ParseAccessMode(c.UploadAccess)
+	check(err == nil, "upload_access: unknown mode %q, expected open, pin or token", c.UploadAccess)
+	check(access != web.AccessPIN || len(c.UploadPIN) >= 4, "upload_pin: at least 4 characters required for upload_access pin")
+	check(c.TokensFile != "", "tokens_file: must not be empty")
+	check(c.UploadRate >= 0, "upload_rate: must not be negative, got %d", c.UploadRate)
+	check(c.UploadRate == 0 || c.UploadBurst > 0, "upload_burst: must be positive, got %d", c.UploadBurst)
+	check(c.UploadQuota >= 0, "upload_quota: must not be negative, got %d", c.UploadQuota)
+	_, err = web.ParseRateLimitKey(c.RateLimitKey)
+	check(err == nil, "rate_limit_key: unknown key %q, expected ip, device or both", c.RateLimitKey)
+	check(c.SessionTTL > 0, "session_ttl: must be positive, got %s", c.SessionTTL)
+	check(c.DeleteWindow >= 0, "delete_window: must not be negative, got %s", c.DeleteWindow)
+	check(c.WatchSettle > 0, "watch_settle: must be positive, got %s", c.WatchSettle)
+	sources := 0
+	for _, enabled := range []bool{c.TLSCert != "" || c.TLSKey != "", c.TLSSelfSigned, c.ACMEDomains != ""} {
+		if enabled {
+			sources++
+		}
+	}
+	check(sources <= 1, "tls_cert, tls_self_signed and acme_domains are exclusive")
+	check((c.TLSCert == "") == (c.TLSKey == ""), "tls_cert and tls_key must be set together")
+	check(c.ACMEDomains == "" || c.ACMEDirectory != "", "acme_directory: must not be empty")
+	check(c.HTTPRedirect == "" || sources > 0, "http_redirect: requires tls_cert, tls_self_signed or acme_domains")
+	check(isFilterAction(c.BlocklistAction), "blocklist_action: unknown action %q, expected mask, reject or moderate", c.BlocklistAction)
+	errs = append(errs, c.validatePipeline()...)
+	errs = append(errs, c.validateAdmins()...)
+	return errors.Join(errs...)
+}
+
+// restartKeys are the settings only applied at startup
+var restartKeys = map[string]bool{
+	"listen":          true,
+	"storedir":        true,
+	"staticdir":       true,
+	"log_json":        true,
+	"log_debug":       true,
+	"tokens_file":     true,
+	"tls_cert":        true,
+	"tls_key":         true,
+	"tls_self_signed": true,
+	"tls_dir":         true,
+	"acme_domains":    true,
+	"acme_email":      true,
+	"acme_directory":  true,
+	"acme_root_ca":    true,
+	"http_redirect":   true,
+	"watch_dirs":      true,
+	"watch_settle":    true,
+}
+
+// RestartRequired returns the keys of settings which differ from old
+// but can't be changed by a reload
+func (c *Config) RestartRequired(old *Config) []string {
+	var keys []string
+	v, ov := reflect.ValueOf(c).Elem(), reflect.ValueOf(old).Elem()
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		key := strings.Split(t.Field(i).Tag.Get("yaml"), ",")[0]
+		if restartKeys[key] && !reflect.DeepEqual(v.Field(i).Interface(), ov.Field(i).Interface()) {
+			keys = append(keys, key)
+		}
+	}
+	return keys
+}
+
+// TLS returns the certificate settings for web.Server.EnableTLS
+func (c *Config) TLS() web.TLSOptions {
+	return web.TLSOptions{
+		CertFile:      c.TLSCert,
+		KeyFile:       c.TLSKey,
+		SelfSigned:    c.TLSSelfSigned,
+		ACMEDomains:   splitList(c.ACMEDomains),
+		ACMEEmail:     c.ACMEEmail,
+		ACMEDirectory: c.ACMEDirectory,
+		ACMERootCA:    c.ACMERootCA,
+		CacheDir:      c.TLSDir,
+	}
+}
+
+// RateLimit returns the upload limits per guest
+func (c *Config) RateLimit() web.RateLimit {
+	key, _ := web.ParseRateLimitKey(c.RateLimitKey)
+	return web.RateLimit{
+		Rate:  c.UploadRate,
+		Burst: c.UploadBurst,
+		Quota: c.UploadQuota,
+		Key:   key,
+	}
+}
+
+// WatchDirectories returns the directories of watch_dirs
+func (c *Config) WatchDirectories() []string {
+	return splitList(c.WatchDirs)
+}
+
+// splitList splits a comma separated setting, ignoring empty items
+func splitList(s string) []string {
+	var items []string
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
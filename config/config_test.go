@@ -0,0 +1,330 @@
+package config
+
+import (
+	"flag"
+	"github.com/blang/photowall/wall"
+	"github.com/blang/photowall/web"
+	"golang.org/x/crypto/bcrypt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func createConfigFile(t *testing.T, content string) string {
+	f, err := ioutil.TempFile("", "photowall.yaml")
+	if err != nil {
+		t.Fatalf("Could not create config file: %s", err)
+	}
+	defer f.Close()
+	f.WriteString(content)
+	return f.Name()
+}
+
+func env(vars map[string]string) func(string) (string, bool) {
+	return func(name string) (string, bool) {
+		v, ok := vars[name]
+		return v, ok
+	}
+}
+
+func parse(args []string, vars map[string]string) (*Config, error) {
+	fs := flag.NewFlagSet("photowall", flag.ContinueOnError)
+	fs.SetOutput(ioutil.Discard)
+	return Parse(fs, args, env(vars))
+}
+
+func TestParseDefaults(t *testing.T) {
+	c, err := parse(nil, nil)
+	if err != nil {
+		t.Fatalf("Error parsing: %s", err)
+	}
+	if c.Listen != ":8000" || c.StoreDir != "./imgs" || c.ImgWidth != 1920 {
+		t.Errorf("Wrong defaults: %+v", c)
+	}
+}
+
+func TestParsePrecedence(t *testing.T) {
+	name := createConfigFile(t, "listen: :9000\nstoredir: /tmp/file\nimg_width: 800\nprocess_timeout: 30s\n")
+	defer os.Remove(name)
+
+	c, err := parse([]string{"-config", name, "-storedir", "/tmp/flag"}, map[string]string{
+		"PHOTOWALL_STOREDIR":        "/tmp/env",
+		"PHOTOWALL_IMG_WIDTH":       "1024",
+		"PHOTOWALL_LOG_JSON":        "true",
+		"PHOTOWALL_PROCESS_TIMEOUT": "10s",
+	})
+	if err != nil {
+		t.Fatalf("Error parsing: %s", err)
+	}
+	if c.Listen != ":9000" {
+		t.Errorf("File setting not applied: %s", c.Listen)
+	}
+	if c.ImgWidth != 1024 || !c.LogJSON || c.ProcessTimeout != 10*time.Second {
+		t.Errorf("Environment not applied: %+v", c)
+	}
+	if c.StoreDir != "/tmp/flag" {
+		t.Errorf("Flag does not override file and environment: %s", c.StoreDir)
+	}
+	if c.ImgHeight != 1080 {
+		t.Errorf("Default lost: %d", c.ImgHeight)
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	name := createConfigFile(t, "listen: :9000\nimg_widht: 800\n")
+	defer os.Remove(name)
+	if _, err := parse([]string{"-config", name}, nil); err == nil || !strings.Contains(err.Error(), "img_widht") {
+		t.Errorf("Unknown key not reported: %v", err)
+	}
+
+	if _, err := parse(nil, map[string]string{"PHOTOWALL_IMG_WIDTH": "wide"}); err == nil || !strings.Contains(err.Error(), "PHOTOWALL_IMG_WIDTH") {
+		t.Errorf("Invalid environment variable not reported: %v", err)
+	}
+
+	_, err := parse([]string{"-filesize_max", "0", "-blocklist_action", "drop", "-delete_window", "-1m"}, nil)
+	if err == nil {
+		t.Fatal("Invalid settings accepted")
+	}
+	for _, key := range []string{"filesize_max", "blocklist_action", "delete_window"} {
+		if !strings.Contains(err.Error(), key) {
+			t.Errorf("Missing error for %s: %s", key, err)
+		}
+	}
+}
+
+func TestValidatePipeline(t *testing.T) {
+	name := createConfigFile(t, `pipeline:
+  - type: resizer
+    action: mask
+  - type: watermark
+  - type: resizer
+    on_error:
+      action: quarantine
+`)
+	defer os.Remove(name)
+	_, err := parse([]string{"-config", name}, nil)
+	if err == nil {
+		t.Fatal("Invalid pipeline accepted")
+	}
+	for _, msg := range []string{
+		"pipeline[0]: blocklist and action are textfilter options",
+		`pipeline[1]: unknown type "watermark"`,
+		"pipeline[2]: on_error.quarantinedir",
+		"exactly one store required, got 0",
+	} {
+		if !strings.Contains(err.Error(), msg) {
+			t.Errorf("Missing error %q: %s", msg, err)
+		}
+	}
+}
+
+func TestValidateStoreOnly(t *testing.T) {
+	name := createConfigFile(t, `pipeline:
+  - type: store
+  - type: resizer
+`)
+	defer os.Remove(name)
+	_, err := parse([]string{"-config", name}, nil)
+	if err == nil || !strings.Contains(err.Error(), "pipeline[0]: resizer required before store") {
+		t.Errorf("Pipeline without resizer before store accepted: %v", err)
+	}
+}
+
+func TestProcessors(t *testing.T) {
+	c := Default()
+	c.QuarantineDir = "/tmp/quarantine"
+	ps, err := c.Processors(wall.NewStore("/tmp/store"), wall.NewOriginals("/tmp/store/originals"))
+	if err != nil {
+		t.Fatalf("Error building pipeline: %s", err)
+	}
+	if len(ps) != 2 {
+		t.Fatalf("Wrong default pipeline: %v", ps)
+	}
+	if name := wall.ProcessorName(ps[0]); name != "resizer" {
+		t.Errorf("Wrong first processor: %s", name)
+	}
+	if policy := ps[0].(wall.PolicyProcessor).ErrorPolicy(); policy.Action != wall.Quarantine || policy.QuarantineDir != "/tmp/quarantine" {
+		t.Errorf("Wrong resizer policy: %+v", policy)
+	}
+	if policy := ps[1].(wall.PolicyProcessor).ErrorPolicy(); policy.Action != wall.Retry || policy.Retries != 2 {
+		t.Errorf("Wrong store policy: %+v", policy)
+	}
+}
+
+func TestProcessorsFromFile(t *testing.T) {
+	blocklist := createConfigFile(t, "badword\n")
+	defer os.Remove(blocklist)
+	name := createConfigFile(t, `pipeline:
+  - type: textfilter
+    blocklist: `+blocklist+`
+    action: reject
+  - type: resizer
+    width: 640
+    height: 480
+    on_error:
+      action: skip
+  - type: store
+`)
+	defer os.Remove(name)
+	c, err := parse([]string{"-config", name}, nil)
+	if err != nil {
+		t.Fatalf("Error parsing: %s", err)
+	}
+	ps, err := c.Processors(wall.NewStore("/tmp/store"), wall.NewOriginals("/tmp/store/originals"))
+	if err != nil {
+		t.Fatalf("Error building pipeline: %s", err)
+	}
+	var names []string
+	for _, p := range ps {
+		names = append(names, wall.ProcessorName(p))
+	}
+	if strings.Join(names, ",") != "textfilter,resizer,store" {
+		t.Errorf("Wrong pipeline: %v", names)
+	}
+	if policy := ps[1].(wall.PolicyProcessor).ErrorPolicy(); policy.Action != wall.Skip {
+		t.Errorf("Wrong resizer policy: %+v", policy)
+	}
+}
+
+func TestRestartRequired(t *testing.T) {
+	old := Default()
+	c := Default()
+	c.SlideInterval = 10 * time.Second
+	c.Allow = "jpg"
+	if keys := c.RestartRequired(old); len(keys) != 0 {
+		t.Errorf("Reloadable settings reported: %v", keys)
+	}
+	c.Listen = ":9000"
+	c.LogJSON = true
+	if keys := c.RestartRequired(old); strings.Join(keys, ",") != "listen,log_json" {
+		t.Errorf("Wrong settings reported: %v", keys)
+	}
+}
+
+func TestValidateTLS(t *testing.T) {
+	_, err := parse([]string{"-tls_cert", "cert.pem", "-tls_self_signed"}, nil)
+	if err == nil || !strings.Contains(err.Error(), "exclusive") || !strings.Contains(err.Error(), "tls_key") {
+		t.Errorf("Invalid TLS settings accepted: %v", err)
+	}
+	if _, err = parse([]string{"-http_redirect", ":80"}, nil); err == nil {
+		t.Errorf("Redirect without TLS accepted")
+	}
+
+	c, err := parse([]string{"-acme_domains", "wall.example.com, photos.example.com", "-http_redirect", ":80"}, nil)
+	if err != nil {
+		t.Fatalf("Error parsing: %s", err)
+	}
+	o := c.TLS()
+	if !o.Enabled() || len(o.ACMEDomains) != 2 || o.ACMEDomains[1] != "photos.example.com" || o.CacheDir != "./tls" {
+		t.Errorf("Wrong TLS options: %+v", o)
+	}
+}
+
+func TestValidateAccess(t *testing.T) {
+	if _, err := parse([]string{"-upload_access", "pin"}, nil); err == nil || !strings.Contains(err.Error(), "upload_pin") {
+		t.Errorf("Pin mode without pin accepted: %v", err)
+	}
+	if _, err := parse([]string{"-upload_access", "password"}, nil); err == nil || !strings.Contains(err.Error(), "upload_access") {
+		t.Errorf("Unknown mode accepted: %v", err)
+	}
+	c, err := parse([]string{"-upload_access", "token"}, map[string]string{"PHOTOWALL_TOKENS_FILE": "/tmp/tokens.json"})
+	if err != nil {
+		t.Fatalf("Error parsing: %s", err)
+	}
+	if c.UploadAccess != "token" || c.TokensFile != "/tmp/tokens.json" {
+		t.Errorf("Wrong access settings: %+v", c)
+	}
+}
+
+func TestRateLimit(t *testing.T) {
+	_, err := parse([]string{"-upload_rate", "-1", "-upload_quota", "-1", "-rate_limit_key", "cookie"}, nil)
+	for _, key := range []string{"upload_rate", "upload_quota", "rate_limit_key"} {
+		if err == nil || !strings.Contains(err.Error(), key) {
+			t.Errorf("Missing error for %s: %v", key, err)
+		}
+	}
+	c, err := parse([]string{"-upload_quota", "20", "-rate_limit_key", "both"}, map[string]string{"PHOTOWALL_UPLOAD_RATE": "3"})
+	if err != nil {
+		t.Fatalf("Error parsing: %s", err)
+	}
+	if l := c.RateLimit(); l.Rate != 3 || l.Burst != 5 || l.Quota != 20 || l.Key != web.RateLimitBoth {
+		t.Errorf("Wrong rate limit: %+v", l)
+	}
+}
+
+func TestAdmins(t *testing.T) {
+	hash, err := bcrypt.GenerateFromPassword([]byte("secret"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatalf("Could not hash password: %s", err)
+	}
+	name := createConfigFile(t, `admins:
+  - name: alice
+    password_hash: `+string(hash)+`
+    role: owner
+  - name: bob
+    password_hash: secret
+    role: admin
+  - name: alice
+    password_hash: `+string(hash)+`
+    role: display
+`)
+	defer os.Remove(name)
+	_, err = parse([]string{"-config", name}, nil)
+	if err == nil {
+		t.Fatal("Invalid admins accepted")
+	}
+	for _, msg := range []string{
+		"admins[1]: password_hash",
+		`admins[1]: unknown role "admin"`,
+		`admins[2]: duplicate name "alice"`,
+	} {
+		if !strings.Contains(err.Error(), msg) {
+			t.Errorf("Missing error %q: %s", msg, err)
+		}
+	}
+
+	c := Default()
+	c.Admins = []AdminConfig{{Name: "alice", PasswordHash: string(hash), Role: "Moderator"}}
+	if err = c.Validate(); err != nil {
+		t.Fatalf("Valid admin rejected: %s", err)
+	}
+	if admins := c.AdminAccounts(); len(admins) != 1 || admins[0].Role != web.RoleModerator {
+		t.Errorf("Wrong admins: %+v", admins)
+	}
+}
+
+func TestKeepOriginals(t *testing.T) {
+	c, err := parse([]string{"-keep_originals"}, nil)
+	if err != nil {
+		t.Fatalf("Error parsing: %s", err)
+	}
+	ps, err := c.Processors(wall.NewStore("/tmp/store"), wall.NewOriginals("/tmp/store/originals"))
+	if err != nil {
+		t.Fatalf("Error building pipeline: %s", err)
+	}
+	var names []string
+	for _, p := range ps {
+		names = append(names, wall.ProcessorName(p))
+	}
+	if strings.Join(names, ",") != "originals,resizer,store" {
+		t.Errorf("Wrong pipeline: %v", names)
+	}
+}
+
+func TestWatchDirs(t *testing.T) {
+	if _, err := parse([]string{"-watch_settle", "0s"}, nil); err == nil || !strings.Contains(err.Error(), "watch_settle") {
+		t.Errorf("Missing error for watch_settle: %v", err)
+	}
+	c, err := parse([]string{"-watch_dirs", "/photos/cam1, ,/photos/cam2,"}, nil)
+	if err != nil {
+		t.Fatalf("Error parsing: %s", err)
+	}
+	if dirs := c.WatchDirectories(); len(dirs) != 2 || dirs[0] != "/photos/cam1" || dirs[1] != "/photos/cam2" {
+		t.Errorf("Wrong directories: %v", dirs)
+	}
+	if keys := c.RestartRequired(Default()); len(keys) != 1 || keys[0] != "watch_dirs" {
+		t.Errorf("Wrong restart keys: %v", keys)
+	}
+}
@@ -0,0 +1,167 @@
+package config
+
+import (
+	"fmt"
+	"github.com/blang/photowall/wall"
+	"path/filepath"
+	"time"
+)
+
+// ProcessorConfig declares a processor of the pipeline
+type ProcessorConfig struct {
+	Type string `yaml:"type"` // textfilter, originals, resizer or store
+
+	// resizer options, default to img_width and img_height
+	Width  uint `yaml:"width"`
+	Height uint `yaml:"height"`
+
+	// textfilter options, default to blocklist and blocklist_action
+	Blocklist string `yaml:"blocklist"`
+	Action    string `yaml:"action"`
+
+	OnError PolicyConfig `yaml:"on_error"`
+}
+
+// PolicyConfig declares the error policy of a processor, see wall.ErrorPolicy
+type PolicyConfig struct {
+	Action        string        `yaml:"action"` // fail (default), skip, retry or quarantine
+	Retries       int           `yaml:"retries"`
+	Backoff       time.Duration `yaml:"backoff"`
+	QuarantineDir string        `yaml:"quarantinedir"`
+}
+
+func isFilterAction(s string) bool {
+	_, err := wall.ParseFilterAction(s)
+	return err == nil
+}
+
+// pipeline returns the configured pipeline or the default one:
+// text filter (if a blocklist is set), originals (if keep_originals is set), resizer and store
+func (c *Config) pipeline() []ProcessorConfig {
+	if len(c.Pipeline) > 0 {
+		return c.Pipeline
+	}
+	var ps []ProcessorConfig
+	if c.Blocklist != "" {
+		ps = append(ps, ProcessorConfig{Type: "textfilter"})
+	}
+	if c.KeepOriginals {
+		ps = append(ps, ProcessorConfig{Type: "originals"})
+	}
+	resizer := ProcessorConfig{Type: "resizer"}
+	if c.QuarantineDir != "" {
+		resizer.OnError = PolicyConfig{Action: "quarantine", QuarantineDir: c.QuarantineDir}
+	}
+	return append(ps, resizer, ProcessorConfig{
+		Type:    "store",
+		OnError: PolicyConfig{Action: "retry", Retries: 2, Backoff: 100 * time.Millisecond},
+	})
+}
+
+func (c *Config) validatePipeline() []error {
+	var errs []error
+	stores, resized := 0, false
+	for i, p := range c.pipeline() {
+		prefix := fmt.Sprintf("pipeline[%d]", i)
+		check := func(ok bool, format string, args ...interface{}) {
+			if !ok {
+				errs = append(errs, fmt.Errorf(prefix+": "+format, args...))
+			}
+		}
+		switch p.Type {
+		case "textfilter":
+			check(p.Width == 0 && p.Height == 0, "width and height are resizer options")
+			check(p.Blocklist != "" || c.Blocklist != "", "blocklist required")
+			check(p.Action == "" || isFilterAction(p.Action), "unknown action %q, expected mask, reject or moderate", p.Action)
+		case "resizer":
+			check(p.Blocklist == "" && p.Action == "", "blocklist and action are textfilter options")
+			resized = true
+		case "store":
+			stores++
+			// the resizer sets format and bounds of the stored photos
+			check(resized, "resizer required before store")
+			check(p.Width == 0 && p.Height == 0 && p.Blocklist == "" && p.Action == "", "store has no options")
+		case "originals":
+			check(p.Width == 0 && p.Height == 0 && p.Blocklist == "" && p.Action == "", "originals has no options")
+		default:
+			check(false, "unknown type %q, expected textfilter, originals, resizer or store", p.Type)
+		}
+
+		action := wall.Fail
+		if p.OnError.Action != "" {
+			var err error
+			action, err = wall.ParseErrorAction(p.OnError.Action)
+			check(err == nil, "on_error.action: unknown action %q, expected fail, skip, retry or quarantine", p.OnError.Action)
+		}
+		check(action != wall.Retry || p.OnError.Retries > 0, "on_error.retries: must be positive to retry")
+		check(action != wall.Quarantine || p.OnError.QuarantineDir != "", "on_error.quarantinedir: required to quarantine")
+		check(p.OnError.Backoff >= 0, "on_error.backoff: must not be negative")
+	}
+	if stores != 1 {
+		errs = append(errs, fmt.Errorf("pipeline: exactly one store required, got %d", stores))
+	}
+	return errs
+}
+
+// StorePath returns the absolute path of the storage directory
+func (c *Config) StorePath() (string, error) {
+	return filepath.Abs(c.StoreDir)
+}
+
+// Processors builds the processors of the pipeline around store and originals, which are
+// created by the caller to register them as observer for removed and updated photos
+// and to keep them across reloads.
+func (c *Config) Processors(store *wall.Store, originals *wall.Originals) ([]wall.Processor, error) {
+	var ps []wall.Processor
+	for _, pc := range c.pipeline() {
+		var p wall.Processor
+		switch pc.Type {
+		case "textfilter":
+			blocklist, action := pc.Blocklist, pc.Action
+			if blocklist == "" {
+				blocklist = c.Blocklist
+			}
+			if action == "" {
+				action = c.BlocklistAction
+			}
+			filterAction, err := wall.ParseFilterAction(action)
+			if err != nil {
+				return nil, err
+			}
+			if p, err = wall.NewTextFilter(blocklist, filterAction); err != nil {
+				return nil, err
+			}
+		case "resizer":
+			width, height := pc.Width, pc.Height
+			if width == 0 {
+				width = c.ImgWidth
+			}
+			if height == 0 {
+				height = c.ImgHeight
+			}
+			resizer := wall.NewResizer(width, height)
+			resizer.MaxPixels = c.MaxMegapixels * 1000 * 1000
+			p = resizer
+		case "originals":
+			p = originals
+		case "store":
+			p = store
+		default:
+			return nil, fmt.Errorf("unknown processor type: %s", pc.Type)
+		}
+
+		policy := wall.ErrorPolicy{
+			Retries:       pc.OnError.Retries,
+			Backoff:       pc.OnError.Backoff,
+			QuarantineDir: pc.OnError.QuarantineDir,
+		}
+		if pc.OnError.Action != "" {
+			var err error
+			if policy.Action, err = wall.ParseErrorAction(pc.OnError.Action); err != nil {
+				return nil, err
+			}
+		}
+		ps = append(ps, wall.WithPolicy(p, policy))
+	}
+	return ps, nil
+}
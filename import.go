@@ -0,0 +1,52 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"github.com/blang/photowall/wall"
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+// runImport imports a ZIP archive or directory into the store through the
+// configured pipeline, like uploads
+func runImport(fs *flag.FlagSet, args []string) int {
+	ps, cfg, code := openOffline(fs, args, 1, true)
+	if ps == nil {
+		return code
+	}
+	processors, err := cfg.Processors(ps.store, ps.originals)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Could not create pipeline: %s\n", err)
+		ps.close()
+		return 1
+	}
+	ps.wall.SetProcessors(processors)
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	summary, err := wall.ImportFiles(ctx, ps.wall, fs.Arg(0), int64(cfg.MaxFileSize)*1024*1024, func(r wall.ImportResult) {
+		switch {
+		case r.Error != "":
+			fmt.Printf("[%d/%d] %s: failed: %s\n", r.Done, r.Total, r.File, r.Error)
+		case r.Duplicate:
+			fmt.Printf("[%d/%d] %s: duplicate\n", r.Done, r.Total, r.File)
+		default:
+			fmt.Printf("[%d/%d] %s: added %s\n", r.Done, r.Total, r.File, r.Photo)
+		}
+	})
+	if cerr := ps.close(); err == nil {
+		err = cerr
+	}
+	fmt.Printf("%d files: %d added, %d duplicates, %d failed\n", summary.Total, summary.Added, summary.Duplicates, summary.Failed)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Import failed: %s\n", err)
+		return 1
+	}
+	if summary.Failed > 0 {
+		return 1
+	}
+	return 0
+}
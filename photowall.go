@@ -1,54 +1,257 @@
 package main
 
 import (
+	"context"
+	"embed"
+	"errors"
 	"flag"
+	"fmt"
+	"github.com/blang/photowall/config"
 	"github.com/blang/photowall/wall"
 	"github.com/blang/photowall/web"
+	"io/fs"
 	"io/ioutil"
-	"log"
+	"log/slog"
+	"os"
+	"os/signal"
 	"path/filepath"
-	"runtime"
 	"strings"
 	"sync"
+	"syscall"
 	"time"
 )
 
-var listen = flag.String("listen", ":8000", "Listen addr")
-var storeDir = flag.String("storedir", "./imgs", "Storage directory")
-var argAllowedExts = flag.String("allow", "png,jpg", "Allowed file extensions")
-var argImgWidth = flag.Uint("img_width", 1920, "Resize bigger images to this width")
-var argImgHeight = flag.Uint("img_height", 1080, "Resize bigger images to this height")
-var argMaxFileSize = flag.Int("filesize_max", 10, "Maximum upload filesize in MB")
+//go:embed static
+var embeddedStatic embed.FS
 
-func baseDir() string {
-	_, file, _, _ := runtime.Caller(0)
-	return filepath.Dir(file)
+// staticFS returns the embedded frontend, overlaid by staticDir if set
+func staticFS(staticDir string) fs.FS {
+	static, _ := fs.Sub(embeddedStatic, "static")
+	if staticDir == "" {
+		return static
+	}
+	return web.Overlay(os.DirFS(staticDir), static)
+}
+
+// newLogger creates the logger logging from level, or debug messages if enabled
+func newLogger(cfg *config.Config, level slog.Level) *slog.Logger {
+	opts := &slog.HandlerOptions{Level: level}
+	if cfg.LogDebug {
+		opts.Level = slog.LevelDebug
+	}
+	if cfg.LogJSON {
+		return slog.New(slog.NewJSONHandler(os.Stderr, opts))
+	}
+	return slog.New(slog.NewTextHandler(os.Stderr, opts))
 }
 
 func main() {
-	flag.Parse()
-	pwall := wall.Create()
-	pwall.SetProcessors([]wall.Processor{
-		wall.Importer(),
+	args := os.Args[1:]
+	name := "serve"
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		name, args = args[0], args[1:]
+	}
+	for _, cmd := range commands {
+		if cmd.name == name {
+			os.Exit(cmd.run(commandFlags(cmd), args))
+		}
+	}
+	fmt.Fprintf(os.Stderr, "Unknown command %q\n\n", name)
+	printCommands()
+	os.Exit(2)
+}
+
+// runServe runs the server until SIGINT or SIGTERM
+func runServe(fs *flag.FlagSet, args []string) int {
+	cfg, err := config.Parse(fs, args, os.LookupEnv)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Invalid configuration:\n%s\n", err)
+		return 2
+	}
+	logger := newLogger(cfg, slog.LevelInfo)
+	slog.SetDefault(logger)
+
+	ps, err := openStore(cfg, logger)
+	if errors.Is(err, wall.ErrLocked) {
+		logger.Error("Storage directory is used by another photowall", "path", cfg.StoreDir, "error", err)
+		return 1
+	}
+	if err != nil {
+		logger.Error("Invalid storage directory", "path", cfg.StoreDir, "error", err)
+		return 1
+	}
+	defer ps.close()
+	pwall, store, originals := ps.wall, ps.store, ps.originals
+	server := web.NewServer(pwall, staticFS(cfg.StaticDir), store.Dir(), int64(cfg.MaxFileSize)*1024*1025, cfg.Allow)
+	server.SetLogger(logger)
+	tokens, err := web.LoadTokens(cfg.TokensFile)
+	if err != nil {
+		logger.Error("Could not load tokens", "error", err)
+		return 1
+	}
+	server.SetTokens(tokens)
+	server.SetOriginals(originals)
+	server.SetStore(store)
+	r := &reloader{
+		args:      args,
+		logger:    logger,
+		wall:      pwall,
+		store:     store,
+		originals: originals,
+		server:    server,
+	}
+	if err := r.apply(cfg); err != nil {
+		logger.Error("Could not create pipeline", "error", err)
+		return 1
+	}
+	server.SetReloader(r.Reload)
+	r.reloadOnSignal()
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	if dirs := cfg.WatchDirectories(); len(dirs) > 0 {
+		watcher, err := wall.NewWatcher(pwall, dirs, cfg.WatchSettle)
+		if err != nil {
+			logger.Error("Could not watch directories", "error", err)
+			return 1
+		}
+		watcher.SetLogger(logger)
+		logger.Info("Watching directories for new photos", "dirs", dirs)
+		go watcher.Run(ctx)
+	}
+	if tlsOptions := cfg.TLS(); tlsOptions.Enabled() {
+		if err := server.EnableTLS(tlsOptions); err != nil {
+			logger.Error("Could not set up TLS", "error", err)
+			return 1
+		}
+	}
+	errs := make(chan error, 2)
+	go func() {
+		errs <- server.ListenAndServe(cfg.Listen)
+	}()
+	if cfg.HTTPRedirect != "" {
+		go func() {
+			errs <- server.ListenAndRedirect(cfg.HTTPRedirect, cfg.Listen)
+		}()
+	}
+	select {
+	case err := <-errs:
+		logger.Error("Server failed", "error", err)
+		return 1
+	case <-ctx.Done():
+	}
+	stop() // A second signal terminates immediately
+
+	timeout := r.config().ShutdownTimeout
+	logger.Info("Shutting down", "timeout", timeout)
+	if err := shutdown(server, pwall, store, timeout); err != nil {
+		logger.Error("Shutdown incomplete", "error", err)
+		return 1
+	}
+	logger.Info("Shutdown complete")
+	return 0
+}
+
+// photoStore is the wall restored from the storage directory, with the store
+// and originals registered for removed and updated photos
+type photoStore struct {
+	wall      *wall.Wall
+	photos    wall.Photos // Set instead of wall if loaded read-only by loadStore
+	store     *wall.Store
+	originals *wall.Originals
+	lock      *wall.StoreLock // Set if opened for writing
+}
+
+// openStore locks the storage directory and restores the wall from it.
+// The processors are set by the caller.
+func openStore(cfg *config.Config, logger *slog.Logger) (*photoStore, error) {
+	storePath, err := cfg.StorePath()
+	if err == nil {
+		err = os.MkdirAll(storePath, 0755)
+	}
+	if err != nil {
+		return nil, err
+	}
+	ps := &photoStore{}
+	if ps.lock, err = wall.LockStore(storePath); err != nil {
+		return nil, err
+	}
+
+	ps.wall = wall.Create()
+	ps.wall.SetLogger(logger)
+	ps.wall.SetTimeout(cfg.ProcessTimeout)
+	ps.wall.SetProcessors([]wall.Processor{
+		wall.NewImporter(cfg.MaxMegapixels * 1000 * 1000),
 	})
 	// Restore existing images using Importer Processor
-	restoreFromDirectory(pwall, filepath.Join(baseDir(), *storeDir))
+	restoreFromDirectory(logger, ps.wall, storePath)
 
-	// Set Production processors
-	pwall.SetProcessors([]wall.Processor{
-		wall.NewResizer(*argImgWidth, *argImgHeight),
-		wall.NewStore(filepath.Join(baseDir(), *storeDir)),
-	})
+	ps.store = wall.NewStore(storePath)
+	ps.store.SetMaxPixels(cfg.MaxMegapixels * 1000 * 1000)
+	for _, p := range ps.wall.Photos() {
+		ps.store.Restore(p) // Detect uploads of restored photos as duplicates
+	}
+	ps.wall.OnRemove(ps.store.Remove)
+	ps.wall.OnUpdate(ps.store.Save)
+	ps.originals = wall.NewOriginals(filepath.Join(storePath, "originals"))
+	ps.wall.OnAdd(ps.originals.Added)
+	ps.wall.OnRemove(ps.originals.Remove)
+	return ps, nil
+}
+
+// loadStore loads the photos of the storage directory from their metadata for
+// commands only reading it. Nothing is created, locked or decoded, so it works
+// while the server runs.
+func loadStore(cfg *config.Config) (*photoStore, error) {
+	storePath, err := cfg.StorePath()
+	if err != nil {
+		return nil, err
+	}
+	photos, err := wall.LoadPhotos(storePath)
+	if err != nil {
+		return nil, err
+	}
+	store := wall.NewStore(storePath)
+	store.SetMaxPixels(cfg.MaxMegapixels * 1000 * 1000)
+	return &photoStore{
+		photos:    photos,
+		store:     store,
+		originals: wall.NewOriginals(filepath.Join(storePath, "originals")),
+	}, nil
+}
 
-	server := web.NewServer(pwall, filepath.Join(baseDir(), "/static"), filepath.Join(baseDir(), *storeDir), int64(*argMaxFileSize)*1024*1025, *argAllowedExts)
-	log.Fatal(server.Run(*listen))
+// close flushes the store and releases the lock
+func (ps *photoStore) close() error {
+	err := ps.store.Sync()
+	if ps.lock != nil {
+		ps.lock.Unlock()
+	}
+	return err
+}
+
+// shutdown stops accepting uploads, waits for running uploads and jobs
+// and flushes the store. Jobs still running after timeout are aborted.
+func shutdown(server *web.Server, pwall *wall.Wall, store *wall.Store, timeout time.Duration) error {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	var errs []error
+	if err := server.Shutdown(ctx); err != nil {
+		errs = append(errs, fmt.Errorf("http server: %w", err))
+	}
+	if err := pwall.Shutdown(ctx); err != nil {
+		errs = append(errs, fmt.Errorf("processing: %w", err))
+	}
+	if err := store.Sync(); err != nil {
+		errs = append(errs, fmt.Errorf("store: %w", err))
+	}
+	return errors.Join(errs...)
 }
 
-func restoreFromDirectory(wall wall.Photowall, path string) {
-	log.Printf("Restore store from directory: %s\n", path)
+func restoreFromDirectory(logger *slog.Logger, wall wall.Photowall, path string) {
+	logger.Info("Restore store from directory", "path", path)
 	files, err := ioutil.ReadDir(path)
 	if err != nil {
-		log.Printf("Error reading directory: %s", err)
+		logger.Error("Error reading directory", "path", path, "error", err)
 		return
 	}
 	wg := &sync.WaitGroup{}
@@ -58,11 +261,11 @@ func restoreFromDirectory(wall wall.Photowall, path string) {
 			fullpath := filepath.Join(path, f.Name())
 			wg.Add(1)
 			go func(path string) {
-				err := wall.AddPhotoFromFile(path, time.Now())
+				err := wall.AddPhotoFromFile(context.Background(), path, time.Now())
 				if err == nil {
-					log.Printf("Added file: %s", fullpath)
+					logger.Info("Added file", "path", path)
 				} else {
-					log.Printf("Error adding file %s: %s", fullpath, err)
+					logger.Error("Error adding file", "path", path, "error", err)
 				}
 				wg.Done()
 			}(fullpath)
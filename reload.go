@@ -0,0 +1,101 @@
+package main
+
+import (
+	"flag"
+	"github.com/blang/photowall/config"
+	"github.com/blang/photowall/wall"
+	"github.com/blang/photowall/web"
+	"io/ioutil"
+	"log/slog"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+)
+
+// reloader applies the configuration to the running wall and server.
+// Settings like the listen address are only applied at startup,
+// changing them on reload logs a warning.
+type reloader struct {
+	args      []string // Arguments of the serve command
+	cfg       *config.Config
+	logger    *slog.Logger
+	wall      *wall.Wall
+	store     *wall.Store
+	originals *wall.Originals
+	server    *web.Server
+	mutex     sync.Mutex
+}
+
+// apply sets all reloadable settings of cfg
+func (r *reloader) apply(cfg *config.Config) error {
+	processors, err := cfg.Processors(r.store, r.originals)
+	if err != nil {
+		return err
+	}
+	r.wall.SetProcessors(processors)
+	r.store.SetMaxPixels(cfg.MaxMegapixels * 1000 * 1000)
+	r.wall.SetTimeout(cfg.ProcessTimeout)
+	r.server.SetMaxSize(int64(cfg.MaxFileSize) * 1024 * 1025)
+	r.server.SetValidExtensions(cfg.Allow)
+	access, err := web.ParseAccessMode(cfg.UploadAccess)
+	if err != nil {
+		return err
+	}
+	r.server.SetAccess(access, cfg.UploadPIN)
+	r.server.SetRateLimit(cfg.RateLimit())
+	r.server.SetAdmins(cfg.AdminAccounts(), cfg.SessionTTL)
+	r.server.SetLocalAdmin(cfg.LocalAdmin)
+	r.server.SetDeleteWindow(cfg.DeleteWindow)
+	r.server.SetGuestExport(cfg.GuestExport)
+	r.server.SetPublicURL(cfg.PublicURL)
+	r.server.SetDisplay(web.DisplaySettings{
+		SlideInterval: cfg.SlideInterval,
+		QRInterval:    cfg.QRInterval,
+		QRDuration:    cfg.QRDuration,
+		UploadURL:     cfg.PublicURL,
+	})
+	r.cfg = cfg
+	return nil
+}
+
+// config returns the current configuration
+func (r *reloader) config() *config.Config {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return r.cfg
+}
+
+// Reload reads the configuration again from the config file, environment and
+// command line. The running configuration is kept if the new one is invalid.
+func (r *reloader) Reload() error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	fs := flag.NewFlagSet(os.Args[0], flag.ContinueOnError)
+	fs.SetOutput(ioutil.Discard)
+	cfg, err := config.Parse(fs, r.args, os.LookupEnv)
+	if err != nil {
+		r.logger.Error("Invalid configuration, keeping the current one", "error", err)
+		return err
+	}
+	for _, key := range cfg.RestartRequired(r.cfg) {
+		r.logger.Warn("Setting changed, restart required to apply it", "setting", key)
+	}
+	if err = r.apply(cfg); err != nil {
+		r.logger.Error("Could not apply configuration, keeping the current one", "error", err)
+		return err
+	}
+	r.logger.Info("Configuration reloaded")
+	return nil
+}
+
+// reloadOnSignal reloads the configuration on every SIGHUP
+func (r *reloader) reloadOnSignal() {
+	ch := make(chan os.Signal, 1)
+	signal.Notify(ch, syscall.SIGHUP)
+	go func() {
+		for range ch {
+			r.Reload()
+		}
+	}()
+}
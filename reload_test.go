@@ -0,0 +1,131 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"github.com/blang/photowall/config"
+	"github.com/blang/photowall/wall"
+	"github.com/blang/photowall/web"
+	"github.com/gin-gonic/gin"
+	"io"
+	"io/ioutil"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+// newTestReloader applies the config file name to a new wall and server like the serve command
+func newTestReloader(t *testing.T, name string) *reloader {
+	gin.SetMode(gin.TestMode)
+	args := []string{"-config", name}
+	cfg, err := config.Parse(flag.NewFlagSet("test", flag.ContinueOnError), args, func(string) (string, bool) { return "", false })
+	if err != nil {
+		t.Fatalf("Could not parse config: %s", err)
+	}
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	w := wall.Create()
+	w.SetLogger(logger)
+	dir := filepath.Dir(name)
+	server := web.NewServer(w, fstest.MapFS{}, dir, 1024*1024, "jpg")
+	server.SetLogger(logger)
+	r := &reloader{
+		args:      args,
+		logger:    logger,
+		wall:      w,
+		store:     wall.NewStore(dir),
+		originals: wall.NewOriginals(filepath.Join(dir, "originals")),
+		server:    server,
+	}
+	if err = r.apply(cfg); err != nil {
+		t.Fatalf("Could not apply config: %s", err)
+	}
+	server.SetReloader(r.Reload)
+	return r
+}
+
+func processorNames(w *wall.Wall) string {
+	var names []string
+	for _, p := range w.Processors() {
+		names = append(names, wall.ProcessorName(p))
+	}
+	return strings.Join(names, ",")
+}
+
+func TestReload(t *testing.T) {
+	dir, err := ioutil.TempDir("", "reload")
+	if err != nil {
+		t.Fatalf("Could not create tmp dir: %s", err)
+	}
+	defer os.RemoveAll(dir)
+	name := filepath.Join(dir, "photowall.yaml")
+	blocklist := filepath.Join(dir, "blocklist.txt")
+	ioutil.WriteFile(blocklist, []byte("badword\n"), 0644)
+	ioutil.WriteFile(name, []byte("storedir: "+dir+"\n"), 0644)
+	r := newTestReloader(t, name)
+	if names := processorNames(r.wall); names != "resizer,store" {
+		t.Fatalf("Wrong pipeline: %s", names)
+	}
+	local := func(method, target, csrf string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, target, nil)
+		req.RemoteAddr = "127.0.0.1:1234"
+		req.Header.Set("X-CSRF-Token", csrf)
+		rec := httptest.NewRecorder()
+		r.server.ServeHTTP(rec, req)
+		return rec
+	}
+	if rec := local(http.MethodGet, "/api/admin/session", ""); rec.Code != http.StatusUnauthorized {
+		t.Fatalf("Local admin without local_admin: %d", rec.Code)
+	}
+
+	ioutil.WriteFile(name, []byte(`storedir: `+dir+`
+blocklist: `+blocklist+`
+keep_originals: true
+local_admin: true
+upload_access: pin
+upload_pin: "1234"
+`), 0644)
+	if err = r.Reload(); err != nil {
+		t.Fatalf("Could not reload: %s", err)
+	}
+	if names := processorNames(r.wall); names != "textfilter,originals,resizer,store" {
+		t.Errorf("Wrong pipeline after reload: %s", names)
+	}
+	rec := local(http.MethodGet, "/api/v1/access", "")
+	if !strings.Contains(rec.Body.String(), `"code_required":true`) {
+		t.Errorf("Upload access not applied: %s", rec.Body.String())
+	}
+	rec = local(http.MethodGet, "/api/admin/session", "")
+	var sess struct{ CSRF string }
+	if err = json.Unmarshal(rec.Body.Bytes(), &sess); rec.Code != http.StatusOK || err != nil {
+		t.Fatalf("Local admin not applied: %d", rec.Code)
+	}
+
+	// An invalid config is reported and the running one kept
+	ioutil.WriteFile(name, []byte("storedir: "+dir+"\nupload_access: everyone\n"), 0644)
+	old := r.config()
+	if rec = local(http.MethodPost, "/api/admin/reload", sess.CSRF); rec.Code != http.StatusUnprocessableEntity {
+		t.Errorf("Invalid config reloaded: %d", rec.Code)
+	}
+	if r.config() != old || processorNames(r.wall) != "textfilter,originals,resizer,store" {
+		t.Errorf("Running config replaced by an invalid one")
+	}
+	if rec = local(http.MethodGet, "/api/v1/access", ""); !strings.Contains(rec.Body.String(), `"code_required":true`) {
+		t.Errorf("Upload access changed by an invalid config: %s", rec.Body.String())
+	}
+
+	ioutil.WriteFile(name, []byte("storedir: "+dir+"\nlocal_admin: true\n"), 0644)
+	if rec = local(http.MethodPost, "/api/admin/reload", sess.CSRF); rec.Code != http.StatusNoContent {
+		t.Errorf("Could not reload: %d", rec.Code)
+	}
+	if names := processorNames(r.wall); names != "resizer,store" {
+		t.Errorf("Wrong pipeline after reload: %s", names)
+	}
+	if rec = local(http.MethodGet, "/api/v1/access", ""); !strings.Contains(rec.Body.String(), `"code_required":false`) {
+		t.Errorf("Upload access not applied: %s", rec.Body.String())
+	}
+}
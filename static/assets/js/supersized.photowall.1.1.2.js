@@ -137,6 +137,16 @@
     	
 
 
+		//Escape guest supplied text, captions are inserted as html
+		var escapeHTML = function(text){
+			return $('<div/>').text(text).html();
+		};
+		var captionHTML = function(item){
+			var caption = item.caption ? escapeHTML(item.caption) : '';
+			if (item.author) caption += ' <span class="author">&mdash; ' + escapeHTML(item.author) + '</span>';
+			return caption;
+		};
+
 		var flickrLoaded = false;
 		var photoWallFn= function(cb) {
 			$.ajax({ //request to Flickr
@@ -153,14 +163,15 @@
     			$.each(flickrResults, function(i,item){
     			
     			    //create image urls
-    			    var photoURL = '/imgs/' + item.name;
-    			    var thumbURL = '/imgs/' + item.name;
-    			    var photoLink = '/imgs/' + item.name;
+    			    var photoURL = item.url;
+    			    var thumbURL = item.url;
+    			    var photoLink = item.url;
+    			    var title = captionHTML(item);
     			   	
     			    if (i == 0){
-    			    	options.slides.splice(0,1,{ image : photoURL, thumb : thumbURL, title : item.title , url : photoLink });
+    			    	options.slides.splice(0,1,{ image : photoURL, thumb : thumbURL, title : title , url : photoLink });
     			    }else{
-    			    	options.slides.push({ image : photoURL, thumb : thumbURL, title : item.title , url : photoLink });
+    			    	options.slides.push({ image : photoURL, thumb : thumbURL, title : title , url : photoLink });
     			    }
     			    
     			 });
@@ -185,6 +196,66 @@
 			resizenow();
 			setTimeout(photoWallFn, 3000);
 		};
+		//Periodically show the QR code of the upload page
+		var qrTimer;
+		var wallCode = (location.search.match(/[?&]code=([^&]*)/) || [])[1] || '';	//Access code of the wall, to show it in the QR code
+		var qrDuration = 10000;
+		var qrOverlay = function(display){
+			clearInterval(qrTimer);
+			qrDuration = display.qr_duration_ms || qrDuration;
+			var url = display.upload_url || (location.protocol + '//' + location.host + '/');
+			$('#qroverlay .url').text(url.replace(/^https?:\/\//, '').replace(/\/$/, ''));
+			$('#qroverlay img').attr('src', '/api/v1/qr.svg?code=' + wallCode + '&url=' + encodeURIComponent(url));	//Reload QR code if the url changed
+			if (!display.qr_interval_ms) return;
+			qrTimer = setInterval(function(){
+				$('#qroverlay').fadeIn(options.transition_speed).delay(qrDuration).fadeOut(options.transition_speed);
+			}, display.qr_interval_ms);
+		};
+
+		//Apply display settings pushed by the server, e.g. after a config reload
+		if (window.EventSource){
+			var events = new EventSource('/api/v1/events');
+			events.addEventListener('settings', function(e){
+				var display = JSON.parse(e.data);
+				qrOverlay(display);
+				if (display.slide_interval_ms && display.slide_interval_ms != options.slide_interval){
+					options.slide_interval = display.slide_interval_ms;
+					if (typeof slideshow_interval != 'undefined' && !isPaused){
+						clearInterval(slideshow_interval);
+						slideshow_interval = setInterval(nextslide, options.slide_interval);	//Restart slideshow with new interval
+					}
+				}
+			});
+
+			//Remote control by admins
+			events.addEventListener('control', function(e){
+				var command = JSON.parse(e.data).command;
+				if (typeof slideshow_interval == 'undefined') return;	//Slideshow not started yet
+				switch (command){
+					case 'next':
+					case 'previous':
+						if (inAnimation) return;
+						clearInterval(slideshow_interval);
+						command == 'next' ? nextslide() : prevslide();
+						if (!isPaused) slideshow_interval = setInterval(nextslide, options.slide_interval);
+						break;
+					case 'pause':
+						clearInterval(slideshow_interval);
+						isPaused = true;
+						break;
+					case 'play':
+						if (isPaused){
+							isPaused = false;
+							slideshow_interval = setInterval(nextslide, options.slide_interval);
+						}
+						break;
+					case 'qr':
+						$('#qroverlay').stop(true, true).fadeIn(options.transition_speed).delay(qrDuration).fadeOut(options.transition_speed);
+						break;
+				}
+			});
+		}
+
 		photoWallFn(function(){
 			/***Load initial set of images***/
     			
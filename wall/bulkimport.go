@@ -0,0 +1,184 @@
+package wall
+
+import (
+	"archive/zip"
+	"context"
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// ErrFileTooLarge is returned by ImportFiles for files exceeding the size limit
+var ErrFileTooLarge = errors.New("file exceeds size limit")
+
+// importExtensions are the image files picked up by ImportFiles
+var importExtensions = map[string]string{
+	".jpg":  "jpg",
+	".jpeg": "jpg",
+	".png":  "png",
+	".gif":  "gif",
+}
+
+// ImportResult is the outcome of importing one file
+type ImportResult struct {
+	File      string `json:"file"`            // Path inside the directory or archive
+	Photo     string `json:"photo,omitempty"` // Id of the added photo
+	Duplicate bool   `json:"duplicate,omitempty"`
+	Error     string `json:"error,omitempty"`
+	Done      int    `json:"done"` // Files processed so far including this one
+	Total     int    `json:"total"`
+}
+
+// ImportSummary counts the results of ImportFiles
+type ImportSummary struct {
+	Total      int `json:"total"`
+	Added      int `json:"added"`
+	Duplicates int `json:"duplicates"`
+	Failed     int `json:"failed"`
+}
+
+// importFile is a file of a directory or archive to import
+type importFile struct {
+	name     string
+	modified time.Time
+	open     func() (io.ReadCloser, error)
+}
+
+// ImportFiles adds all images of a directory (including subdirectories) or ZIP archive
+// to the wall, running them through its processors like uploads. Files are
+// processed one by one, progress is called after each of them. Files larger than
+// maxSize bytes fail with ErrFileTooLarge, <= 0 disables the limit.
+// Duplicates are detected by the Store. The modification times become the creation times.
+func ImportFiles(ctx context.Context, w Photowall, source string, maxSize int64, progress func(ImportResult)) (ImportSummary, error) {
+	var summary ImportSummary
+	info, err := os.Stat(source)
+	if err != nil {
+		return summary, err
+	}
+	var files []importFile
+	if info.IsDir() {
+		files, err = dirFiles(source)
+	} else {
+		var z *zip.ReadCloser
+		if z, err = zip.OpenReader(source); err != nil {
+			return summary, err
+		}
+		defer z.Close()
+		files = zipFiles(&z.Reader)
+	}
+	if err != nil {
+		return summary, err
+	}
+
+	summary.Total = len(files)
+	for i, f := range files {
+		if err = ctx.Err(); err != nil {
+			return summary, err
+		}
+		result := ImportResult{File: f.name, Done: i + 1, Total: len(files)}
+		id, err := importOne(ctx, w, f, maxSize)
+		switch {
+		case err == nil:
+			result.Photo = id
+			summary.Added++
+		case errors.Is(err, ErrDuplicate):
+			result.Duplicate = true
+			summary.Duplicates++
+		default:
+			result.Error = err.Error()
+			summary.Failed++
+		}
+		if progress != nil {
+			progress(result)
+		}
+	}
+	return summary, nil
+}
+
+// importOne copies the file to a temporary file consumed by the processors
+func importOne(ctx context.Context, w Photowall, f importFile, maxSize int64) (string, error) {
+	r, err := f.open()
+	if err != nil {
+		return "", err
+	}
+	defer r.Close()
+	tmp, err := copyTemp(r, "import", maxSize)
+	if err != nil {
+		return "", err
+	}
+	base := path.Base(filepath.ToSlash(f.name))
+	p := NewPhotoWithInfo(tmp, 0, 0, importExtensions[strings.ToLower(path.Ext(base))], f.modified, PhotoInfo{Filename: base})
+	if err = w.AddPhoto(ctx, p); err != nil {
+		// Failed photos are not consumed by the processors, quarantined ones are gone already
+		os.Remove(tmp)
+		return "", err
+	}
+	return p.ID(), nil
+}
+
+// copyTemp copies r to a new temporary file, as the processors consume their input.
+// Input larger than maxSize bytes fails with ErrFileTooLarge, <= 0 disables the limit.
+func copyTemp(r io.Reader, pattern string, maxSize int64) (string, error) {
+	tmp, err := ioutil.TempFile("", pattern)
+	if err != nil {
+		return "", err
+	}
+	if maxSize > 0 {
+		r = io.LimitReader(r, maxSize+1)
+	}
+	n, err := io.Copy(tmp, r)
+	if err == nil && maxSize > 0 && n > maxSize {
+		err = ErrFileTooLarge
+	}
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return "", err
+	}
+	return tmp.Name(), nil
+}
+
+func isImportFile(name string) bool {
+	_, ok := importExtensions[strings.ToLower(path.Ext(name))]
+	return ok && !strings.HasPrefix(path.Base(name), ".")
+}
+
+func dirFiles(dir string) ([]importFile, error) {
+	var files []importFile
+	err := filepath.Walk(dir, func(name string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.Mode().IsRegular() && isImportFile(filepath.ToSlash(name)) {
+			rel, _ := filepath.Rel(dir, name)
+			files = append(files, importFile{
+				name:     filepath.ToSlash(rel),
+				modified: info.ModTime(),
+				open:     func() (io.ReadCloser, error) { return os.Open(name) },
+			})
+		}
+		return nil
+	})
+	return files, err
+}
+
+func zipFiles(z *zip.Reader) []importFile {
+	var files []importFile
+	for _, f := range z.File {
+		f := f
+		if f.FileInfo().IsDir() || !isImportFile(f.Name) || strings.HasPrefix(f.Name, "__MACOSX/") {
+			continue
+		}
+		files = append(files, importFile{name: f.Name, modified: f.Modified, open: f.Open})
+	}
+	sort.Slice(files, func(i, j int) bool { return files[i].name < files[j].name })
+	return files
+}
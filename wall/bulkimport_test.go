@@ -0,0 +1,112 @@
+package wall
+
+import (
+	"archive/zip"
+	"context"
+	"image"
+	"image/jpeg"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func createImportTestDir(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "import")
+	if err != nil {
+		t.Fatalf("Could not create tmp dir: %s", err)
+	}
+	for name, width := range map[string]int{"a.jpg": 100, "sub/b.JPEG": 200, "sub/copy.jpg": 100} {
+		name = filepath.Join(dir, name)
+		os.MkdirAll(filepath.Dir(name), 0755)
+		f, err := os.Create(name)
+		if err != nil {
+			t.Fatalf("Could not create image: %s", err)
+		}
+		jpeg.Encode(f, image.NewRGBA(image.Rect(0, 0, width, 100)), nil)
+		f.Close()
+	}
+	ioutil.WriteFile(filepath.Join(dir, "notes.txt"), []byte("no image"), 0644)
+	return dir
+}
+
+func TestImportFiles(t *testing.T) {
+	source := createImportTestDir(t)
+	defer os.RemoveAll(source)
+	storeDir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatalf("Could not create tmp dir: %s", err)
+	}
+	defer os.RemoveAll(storeDir)
+	w := Create()
+	w.SetProcessors([]Processor{NewStore(storeDir)})
+
+	var results []ImportResult
+	summary, err := ImportFiles(context.Background(), w, source, 0, func(r ImportResult) {
+		results = append(results, r)
+	})
+	if err != nil {
+		t.Fatalf("Error importing: %s", err)
+	}
+	if summary != (ImportSummary{Total: 3, Added: 2, Duplicates: 1}) {
+		t.Errorf("Wrong summary: %+v", summary)
+	}
+	if len(results) != 3 || results[2].Done != 3 || results[2].Total != 3 {
+		t.Fatalf("Wrong progress: %+v", results)
+	}
+	if results[0].File != "a.jpg" || results[0].Photo == "" {
+		t.Errorf("Wrong result: %+v", results[0])
+	}
+	p, err := w.GetPhoto(results[0].Photo)
+	if err != nil || p.Filename() != "a.jpg" {
+		t.Errorf("Photo not added: %v", err)
+	}
+
+	// The same files as archive are all duplicates
+	archive := filepath.Join(storeDir, "import.zip")
+	f, err := os.Create(archive)
+	if err != nil {
+		t.Fatalf("Could not create archive: %s", err)
+	}
+	z := zip.NewWriter(f)
+	for _, r := range results {
+		b, _ := ioutil.ReadFile(filepath.Join(source, r.File))
+		zf, _ := z.Create("party/" + r.File)
+		zf.Write(b)
+	}
+	z.Close()
+	f.Close()
+	summary, err = ImportFiles(context.Background(), w, archive, 0, nil)
+	if err != nil {
+		t.Fatalf("Error importing archive: %s", err)
+	}
+	if summary != (ImportSummary{Total: 3, Duplicates: 3}) {
+		t.Errorf("Wrong summary: %+v", summary)
+	}
+}
+
+func TestImportFilesTooLarge(t *testing.T) {
+	source := createImportTestDir(t)
+	defer os.RemoveAll(source)
+	w := Create()
+	w.SetProcessors(nil)
+	info, err := os.Stat(filepath.Join(source, "a.jpg"))
+	if err != nil {
+		t.Fatalf("Could not stat image: %s", err)
+	}
+	var failed []string
+	summary, err := ImportFiles(context.Background(), w, source, info.Size(), func(r ImportResult) {
+		if r.Error != "" {
+			failed = append(failed, r.File)
+		}
+	})
+	if err != nil {
+		t.Fatalf("Error importing: %s", err)
+	}
+	if summary.Failed != 1 || len(failed) != 1 || failed[0] != "sub/b.JPEG" {
+		t.Errorf("Wrong files beyond the limit: %+v %v", summary, failed)
+	}
+	for _, p := range w.Photos() {
+		os.Remove(p.Name())
+	}
+}
@@ -0,0 +1,55 @@
+package wall
+
+import (
+	"context"
+	"log/slog"
+)
+
+type contextKey int
+
+const requestIDKey contextKey = iota
+
+// WithRequestID returns a context carrying the request id, it's attached to all log records of the pipeline
+func WithRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIDKey, id)
+}
+
+// RequestID returns the request id of the context or an empty string
+func RequestID(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey).(string)
+	return id
+}
+
+// loggerFor returns the logger annotated with the context's request id
+func loggerFor(ctx context.Context, l *slog.Logger) *slog.Logger {
+	if id := RequestID(ctx); id != "" {
+		return l.With("request_id", id)
+	}
+	return l
+}
+
+type contextProcessor struct {
+	Processor
+}
+
+// WithContext adapts a Processor to a ContextProcessor. Processors which are
+// not context-aware are not started if the context is already done.
+func WithContext(p Processor) ContextProcessor {
+	if cp, ok := p.(ContextProcessor); ok {
+		return cp
+	}
+	return contextProcessor{Processor: p}
+}
+
+func (p contextProcessor) ProcessContext(ctx context.Context, photo Photo) (Photo, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return p.Processor.Process(photo)
+}
+
+func (p contextProcessor) Cleanup(photo Photo) {
+	if c, ok := p.Processor.(Cleaner); ok {
+		c.Cleanup(photo)
+	}
+}
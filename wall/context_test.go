@@ -0,0 +1,65 @@
+package wall
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestRequestID(t *testing.T) {
+	ctx := context.Background()
+	if id := RequestID(ctx); id != "" {
+		t.Errorf("Empty context has request id: %s", id)
+	}
+	ctx = WithRequestID(ctx, "abc")
+	if id := RequestID(ctx); id != "abc" {
+		t.Errorf("Wrong request id: %s", id)
+	}
+}
+
+func TestProcessCancelled(t *testing.T) {
+	w := Create()
+	var called bool
+	w.SetProcessors([]Processor{
+		ProcessorFunc(func(p Photo) (Photo, error) {
+			called = true
+			return p, nil
+		}),
+	})
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := w.AddPhotoFromFile(ctx, "in", time.Now()); !errors.Is(err, context.Canceled) {
+		t.Errorf("Wrong error: %v", err)
+	}
+	if called {
+		t.Errorf("Processor was started with cancelled context")
+	}
+	if len(w.Photos()) != 0 {
+		t.Errorf("Cancelled photo was added to the wall")
+	}
+}
+
+func TestProcessTimeout(t *testing.T) {
+	w := Create()
+	cleaner := &cleanupProcessor{}
+	w.SetTimeout(10 * time.Millisecond)
+	w.SetProcessors([]Processor{
+		cleaner,
+		WithPolicy(ContextProcessorFunc(func(ctx context.Context, p Photo) (Photo, error) {
+			<-ctx.Done()
+			return nil, ctx.Err()
+		}), ErrorPolicy{Action: Skip}),
+	})
+	err := w.AddPhotoFromFile(context.Background(), "in", time.Now())
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Wrong error: %v", err)
+	}
+	var perr *ProcessError
+	if !errors.As(err, &perr) || perr.Action != Fail {
+		t.Errorf("Timed out processor was not failed: %v", err)
+	}
+	if len(cleaner.cleaned) != 1 {
+		t.Errorf("Processor result was not cleaned up")
+	}
+}
@@ -0,0 +1,132 @@
+package wall
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"github.com/blang/photowall/wall/internal/jpegscale"
+	"image"
+	"io"
+	"os"
+)
+
+// DefaultMaxPixels is the default maximum amount of pixels (50 megapixels) an image may have to be decoded
+const DefaultMaxPixels = 50 * 1000 * 1000
+
+// ErrTooManyPixels is returned if an image exceeds the configured pixel limit
+var ErrTooManyPixels = errors.New("image exceeds pixel limit")
+
+// checkPixels verifies the image dimensions against maxPixels, a limit <= 0 disables the check
+func checkPixels(cfg image.Config, maxPixels int) error {
+	if cfg.Width <= 0 || cfg.Height <= 0 {
+		return errors.New("invalid image dimensions")
+	}
+	if maxPixels > 0 && int64(cfg.Width)*int64(cfg.Height) > int64(maxPixels) {
+		return fmt.Errorf("%w: %dx%d", ErrTooManyPixels, cfg.Width, cfg.Height)
+	}
+	return nil
+}
+
+// decodeFile decodes the image file name. The image header is read first
+// and images above maxPixels are rejected before any bitmap is allocated.
+func decodeFile(name string, maxPixels int) (image.Image, string, error) {
+	img, _, format, err := decodeFileScaled(name, maxPixels, nil)
+	return img, format, err
+}
+
+// decodeFileScaled decodes like decodeFile, but shrinks the image by the factor
+// returned by shrink for the original image config, nil keeps the full size.
+// Jpegs are scaled by up to 1/8 while decoding, so their full size bitmap is never
+// allocated, the rest of the factor is applied by downsample. Other formats can't
+// be scaled while decoding, they are decoded at full size and returned unchanged.
+// The original config is returned along with the image.
+func decodeFileScaled(name string, maxPixels int, shrink func(image.Config) int) (image.Image, image.Config, string, error) {
+	file, err := os.Open(name)
+	if err != nil {
+		return nil, image.Config{}, "", err
+	}
+	defer file.Close()
+
+	cfg, format, err := image.DecodeConfig(bufio.NewReader(file))
+	if err != nil {
+		return nil, cfg, "", err
+	}
+	if err = checkPixels(cfg, maxPixels); err != nil {
+		return nil, cfg, "", err
+	}
+	if _, err = file.Seek(0, io.SeekStart); err != nil {
+		return nil, cfg, "", err
+	}
+	factor := 1
+	if shrink != nil {
+		factor = shrink(cfg)
+	}
+	if format != "jpeg" || factor < 2 {
+		img, format, err := image.Decode(bufio.NewReader(file))
+		return img, cfg, format, err
+	}
+	scale := 1
+	for _, s := range jpegscale.Scales {
+		if s <= factor {
+			scale = s
+		}
+	}
+	img, err := jpegscale.Decode(bufio.NewReader(file), scale)
+	if err != nil {
+		return nil, cfg, "", err
+	}
+	return downsample(img, factor/scale), cfg, format, nil
+}
+
+// downsample shrinks YCbCr images (decoded jpegs) by the integer factor
+// using a box filter on the raw planes, for factors beyond the 1/8 scaling
+// of the decoder. The shrunk image is much smaller than the RGBA64 buffers the
+// resampling filter would allocate. Other image types are returned unchanged.
+func downsample(img image.Image, factor int) image.Image {
+	src, ok := img.(*image.YCbCr)
+	if !ok || factor < 2 {
+		return img
+	}
+	size := src.Rect.Size()
+	if size.X/factor < 1 || size.Y/factor < 1 {
+		return img
+	}
+	dst := image.NewYCbCr(image.Rect(0, 0, size.X/factor, size.Y/factor), src.SubsampleRatio)
+
+	boxPlane(dst.Y, dst.YStride, len(dst.Y)/dst.YStride, src.Y[src.YOffset(src.Rect.Min.X, src.Rect.Min.Y):], src.YStride, factor)
+	if len(dst.Cb) > 0 {
+		cOff := src.COffset(src.Rect.Min.X, src.Rect.Min.Y)
+		cRows := len(dst.Cb) / dst.CStride
+		boxPlane(dst.Cb, dst.CStride, cRows, src.Cb[cOff:], src.CStride, factor)
+		boxPlane(dst.Cr, dst.CStride, cRows, src.Cr[cOff:], src.CStride, factor)
+	}
+	return dst
+}
+
+// boxPlane fills dst (stride dstStride, rows dstRows) with the average of factor*factor blocks of src
+func boxPlane(dst []uint8, dstStride, dstRows int, src []uint8, srcStride, factor int) {
+	srcRows := (len(src) + srcStride - 1) / srcStride
+	for y := 0; y < dstRows; y++ {
+		for x := 0; x < dstStride; x++ {
+			var sum, n int
+			for j := 0; j < factor; j++ {
+				sy := y*factor + j
+				if sy >= srcRows {
+					break
+				}
+				row := sy * srcStride
+				for i := 0; i < factor; i++ {
+					sx := x*factor + i
+					if sx >= srcStride || row+sx >= len(src) {
+						break
+					}
+					sum += int(src[row+sx])
+					n++
+				}
+			}
+			if n > 0 {
+				dst[y*dstStride+x] = uint8(sum / n)
+			}
+		}
+	}
+}
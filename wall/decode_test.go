@@ -0,0 +1,120 @@
+package wall
+
+import (
+	"errors"
+	"image"
+	"image/jpeg"
+	"io/ioutil"
+	"os"
+	"runtime"
+	"testing"
+	"time"
+)
+
+func TestResizeTooManyPixels(t *testing.T) {
+	fo, err := createResizeTestImg(1000, 2000)
+	if err != nil {
+		t.Fatal("Could not create tmp file")
+	}
+	defer os.Remove(fo)
+
+	resizer := NewResizer(100, 200)
+	resizer.MaxPixels = 1000 * 1000
+
+	_, err = resizer.Process(NewPhoto(fo, 0, 0, "", time.Now()))
+	if !errors.Is(err, ErrTooManyPixels) {
+		t.Fatalf("Expected pixel limit error, got: %v", err)
+	}
+}
+
+func TestImporterTooManyPixels(t *testing.T) {
+	fo, err := createTestImg()
+	if err != nil {
+		t.Fatal("Could not create tmp file")
+	}
+	defer os.Remove(fo)
+
+	_, err = NewImporter(1000 * 1000).Process(NewPhoto(fo, 0, 0, "", time.Now()))
+	if !errors.Is(err, ErrTooManyPixels) {
+		t.Fatalf("Expected pixel limit error, got: %v", err)
+	}
+
+	p, err := NewImporter(0).Process(NewPhoto(fo, 0, 0, "", time.Now()))
+	if err != nil {
+		t.Fatalf("Unlimited import failed: %s", err)
+	}
+	if size := p.Bounds().Size(); size.X != 1000 || size.Y != 2000 {
+		t.Errorf("Wrong size: %v", size)
+	}
+}
+
+func TestDecodeScaled(t *testing.T) {
+	fout, err := ioutil.TempFile("", "imagetest")
+	if err != nil {
+		t.Fatal("Could not create tmp file")
+	}
+	defer os.Remove(fout.Name())
+	err = jpeg.Encode(fout, image.NewRGBA(image.Rect(0, 0, 1001, 600)), nil)
+	fout.Close()
+	if err != nil {
+		t.Fatalf("Could not encode test image: %s", err)
+	}
+
+	// Scaled by the decoder, beyond 1/8 additionally by downsample
+	for factor, want := range map[int]image.Point{1: {1001, 600}, 4: {251, 150}, 6: {251, 150}, 16: {63, 37}} {
+		img, cfg, format, err := decodeFileScaled(fout.Name(), 0, func(image.Config) int { return factor })
+		if err != nil {
+			t.Fatalf("Could not decode test image: %s", err)
+		}
+		if size := img.Bounds().Size(); size != want {
+			t.Errorf("Wrong size for factor %d: %v, expected %v", factor, size, want)
+		}
+		if cfg.Width != 1001 || cfg.Height != 600 || format != "jpeg" {
+			t.Errorf("Wrong config %+v %s", cfg, format)
+		}
+		if _, ok := img.(*image.YCbCr); !ok {
+			t.Errorf("Scaled image has wrong type: %T", img)
+		}
+	}
+}
+
+func TestDecodeScaledMemory(t *testing.T) {
+	fout, err := ioutil.TempFile("", "imagetest")
+	if err != nil {
+		t.Fatal("Could not create tmp file")
+	}
+	defer os.Remove(fout.Name())
+	err = jpeg.Encode(fout, image.NewRGBA(image.Rect(0, 0, 2400, 1600)), nil)
+	fout.Close()
+	if err != nil {
+		t.Fatalf("Could not encode test image: %s", err)
+	}
+
+	full := 2400 * 1600 * 3 / 2 // YCbCr 4:2:0 bitmap
+	var before, after runtime.MemStats
+	runtime.ReadMemStats(&before)
+	_, _, _, err = decodeFileScaled(fout.Name(), 0, func(image.Config) int { return 8 })
+	runtime.ReadMemStats(&after)
+	if err != nil {
+		t.Fatalf("Could not decode test image: %s", err)
+	}
+	if allocated := after.TotalAlloc - before.TotalAlloc; allocated > uint64(full/4) {
+		t.Errorf("Scaled decoding allocated %d bytes, the full bitmap has %d", allocated, full)
+	}
+}
+
+func TestDownsample(t *testing.T) {
+	src := image.NewYCbCr(image.Rect(0, 0, 1001, 600), image.YCbCrSubsampleRatio420)
+	small := downsample(src, 4)
+	if size := small.Bounds().Size(); size.X != 250 || size.Y != 150 {
+		t.Errorf("Wrong downsampled size: %v", size)
+	}
+	if _, ok := small.(*image.YCbCr); !ok {
+		t.Errorf("Downsampled image has wrong type: %T", small)
+	}
+
+	rgba := image.NewRGBA(image.Rect(0, 0, 100, 100))
+	if downsample(rgba, 4) != image.Image(rgba) {
+		t.Errorf("Non YCbCr image should not be downsampled")
+	}
+}
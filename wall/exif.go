@@ -0,0 +1,137 @@
+package wall
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"os"
+	"strings"
+	"time"
+)
+
+// EXIF tags of the capture time
+const (
+	exifTagDateTime         = 0x0132
+	exifTagExifIFD          = 0x8769
+	exifTagDateTimeOriginal = 0x9003
+)
+
+// errNoExifTime is returned by exifTime if the file has no EXIF date
+var errNoExifTime = errors.New("no EXIF date")
+
+// exifTime returns the capture time stored in the EXIF data of a JPEG file,
+// DateTimeOriginal or else DateTime. Cameras store it without time zone, it's
+// interpreted as local time.
+func exifTime(name string) (time.Time, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return time.Time{}, err
+	}
+	defer f.Close()
+	tiff, err := exifSegment(bufio.NewReader(f))
+	if err != nil {
+		return time.Time{}, err
+	}
+	return tiffTime(tiff)
+}
+
+// exifSegment returns the TIFF structure of the APP1 segment of a JPEG
+func exifSegment(r *bufio.Reader) ([]byte, error) {
+	var soi [2]byte
+	if _, err := io.ReadFull(r, soi[:]); err != nil || soi != [2]byte{0xff, 0xd8} {
+		return nil, errors.New("not a jpeg")
+	}
+	for {
+		var marker [4]byte
+		if _, err := io.ReadFull(r, marker[:]); err != nil {
+			return nil, errNoExifTime
+		}
+		if marker[0] != 0xff || marker[1] == 0xda || marker[1] == 0xd9 {
+			// EXIF data precedes the image data
+			return nil, errNoExifTime
+		}
+		length := int(binary.BigEndian.Uint16(marker[2:])) - 2
+		if length < 0 {
+			return nil, errNoExifTime
+		}
+		if marker[1] != 0xe1 {
+			if _, err := r.Discard(length); err != nil {
+				return nil, errNoExifTime
+			}
+			continue
+		}
+		segment := make([]byte, length)
+		if _, err := io.ReadFull(r, segment); err != nil {
+			return nil, errNoExifTime
+		}
+		if bytes.HasPrefix(segment, []byte("Exif\x00\x00")) {
+			return segment[6:], nil
+		}
+	}
+}
+
+// tiffTime reads the date tags of the IFDs of a TIFF structure
+func tiffTime(tiff []byte) (time.Time, error) {
+	if len(tiff) < 8 {
+		return time.Time{}, errNoExifTime
+	}
+	var order binary.ByteOrder
+	switch string(tiff[:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return time.Time{}, errNoExifTime
+	}
+	ifd0 := exifTags(tiff, order, order.Uint32(tiff[4:]))
+	var exif map[uint16][]byte
+	if v, ok := ifd0[exifTagExifIFD]; ok && len(v) == 4 {
+		exif = exifTags(tiff, order, order.Uint32(v))
+	}
+	for _, v := range [][]byte{exif[exifTagDateTimeOriginal], ifd0[exifTagDateTime]} {
+		s := strings.TrimRight(string(v), "\x00 ")
+		if t, err := time.ParseInLocation("2006:01:02 15:04:05", s, time.Local); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, errNoExifTime
+}
+
+// exifTags returns the values of the ASCII and LONG entries of the IFD at offset,
+// entries pointing outside of tiff are left out
+func exifTags(tiff []byte, order binary.ByteOrder, offset uint32) map[uint16][]byte {
+	tags := make(map[uint16][]byte)
+	if int64(offset)+2 > int64(len(tiff)) {
+		return tags
+	}
+	n := int(order.Uint16(tiff[offset:]))
+	for i := 0; i < n; i++ {
+		entry := int64(offset) + 2 + int64(i)*12
+		if entry+12 > int64(len(tiff)) {
+			break
+		}
+		e := tiff[entry : entry+12]
+		typ, count := order.Uint16(e[2:]), int64(order.Uint32(e[4:]))
+		var size int64
+		switch typ {
+		case 2: // ASCII
+			size = count
+		case 4: // LONG
+			size = 4 * count
+		default:
+			continue
+		}
+		if size <= 4 {
+			tags[order.Uint16(e)] = e[8 : 8+size]
+			continue
+		}
+		start := int64(order.Uint32(e[8:]))
+		if start+size <= int64(len(tiff)) {
+			tags[order.Uint16(e)] = tiff[start : start+size]
+		}
+	}
+	return tags
+}
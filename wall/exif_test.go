@@ -0,0 +1,71 @@
+package wall
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"image/jpeg"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+)
+
+// createExifTestImg writes a JPEG with the EXIF DateTimeOriginal date, none if empty
+func createExifTestImg(dir, name, date string) (string, error) {
+	var img bytes.Buffer
+	if err := jpeg.Encode(&img, image.NewRGBA(image.Rect(0, 0, 100, 50)), nil); err != nil {
+		return "", err
+	}
+	b := img.Bytes()
+	if date != "" {
+		// IFD0 pointing to the Exif IFD with DateTimeOriginal
+		tiff := []byte("II*\x00\x08\x00\x00\x00")
+		le := binary.LittleEndian
+		tiff = le.AppendUint16(tiff, 1)
+		tiff = append(le.AppendUint16(le.AppendUint16(tiff, exifTagExifIFD), 4), 1, 0, 0, 0)
+		tiff = le.AppendUint32(le.AppendUint32(tiff, 26), 0)
+		tiff = le.AppendUint16(tiff, 1)
+		tiff = le.AppendUint32(le.AppendUint16(le.AppendUint16(tiff, exifTagDateTimeOriginal), 2), 20)
+		tiff = le.AppendUint32(le.AppendUint32(tiff, 44), 0)
+		tiff = append(tiff, date+"\x00"...)
+
+		segment := append([]byte("Exif\x00\x00"), tiff...)
+		app1 := binary.BigEndian.AppendUint16([]byte{0xff, 0xe1}, uint16(len(segment)+2))
+		b = append(append(append([]byte{0xff, 0xd8}, app1...), segment...), b[2:]...)
+	}
+	f, err := ioutil.TempFile(dir, name)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	_, err = f.Write(b)
+	return f.Name(), err
+}
+
+func TestExifTime(t *testing.T) {
+	name, err := createExifTestImg("", "exif*.jpg", "2024:05:06 07:08:09")
+	if err != nil {
+		t.Fatalf("Could not create test image: %s", err)
+	}
+	defer os.Remove(name)
+	created, err := exifTime(name)
+	if err != nil {
+		t.Fatalf("Error reading EXIF: %s", err)
+	}
+	if want := time.Date(2024, 5, 6, 7, 8, 9, 0, time.Local); !created.Equal(want) {
+		t.Errorf("Wrong time %s, expected %s", created, want)
+	}
+	if _, _, err = decodeFile(name, DefaultMaxPixels); err != nil {
+		t.Errorf("Test image is invalid: %s", err)
+	}
+
+	plain, err := createExifTestImg("", "plain*.jpg", "")
+	if err != nil {
+		t.Fatalf("Could not create test image: %s", err)
+	}
+	defer os.Remove(plain)
+	if _, err = exifTime(plain); err != errNoExifTime {
+		t.Errorf("Wrong error for image without EXIF: %v", err)
+	}
+}
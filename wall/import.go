@@ -1,28 +1,29 @@
 package wall
 
 import (
-	"bufio"
 	"errors"
 	"image"
 	_ "image/jpeg" // Support jpeg image format
-	"os"
 )
 
-// Importer creates a processor, checking the file for valid image
+// Importer creates a processor, checking the file for valid image.
+// Metadata stored next to the file by Store is restored.
 func Importer() Processor {
-	return ProcessorFunc(importProcess)
+	return NewImporter(DefaultMaxPixels)
 }
 
-func importProcess(p Photo) (Photo, error) {
-	file, err := os.Open(p.Name())
-	if err != nil {
-		return nil, err
-	}
-	defer file.Close()
-	imgReader := bufio.NewReader(file)
+// NewImporter creates an Importer rejecting images with more than maxPixels pixels
+func NewImporter(maxPixels int) Processor {
+	return importer{maxPixels: maxPixels}
+}
+
+type importer struct {
+	maxPixels int
+}
 
-	// decode jpeg into image.Image
-	img, format, err := image.Decode(imgReader)
+func (i importer) Process(p Photo) (Photo, error) {
+	// decode jpeg at 1/8 of its size, only checking it's valid
+	_, cfg, format, err := decodeFileScaled(p.Name(), i.maxPixels, func(image.Config) int { return 8 })
 	if err != nil {
 		return nil, err
 	}
@@ -30,7 +31,25 @@ func importProcess(p Photo) (Photo, error) {
 		return nil, errors.New("Not a valid jpeg")
 	}
 
-	dims := img.Bounds().Size()
+	dims := image.Pt(cfg.Width, cfg.Height)
+
+	createdAt := p.CreatedAt()
+	info := InfoOf(p)
+	if meta, err := readMeta(p.Name()); err == nil {
+		createdAt = meta.CreatedAt()
+		info = InfoOf(meta)
+	}
+	chsum, size, err := checksumFile(p.Name())
+	if err != nil {
+		return nil, err
+	}
+	if info.Checksum == "" {
+		// Files stored without metadata get an id derived from their content
+		info.ID = chsum[:16]
+	}
+	info.Checksum = chsum
+	info.Size = size
+	info.MIMEType = "image/jpeg"
 
-	return NewPhoto(p.Name(), dims.X, dims.Y, "jpg", p.CreatedAt()), nil
+	return NewPhotoWithInfo(p.Name(), dims.X, dims.Y, "jpg", createdAt, info), nil
 }
@@ -0,0 +1,99 @@
+package jpegscale
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"testing"
+)
+
+func testJPEG(t *testing.T, gray bool) []byte {
+	var img image.Image
+	if gray {
+		g := image.NewGray(image.Rect(0, 0, 203, 117))
+		for y := 0; y < 117; y++ {
+			for x := 0; x < 203; x++ {
+				g.SetGray(x, y, color.Gray{uint8((x + y) / 2)})
+			}
+		}
+		img = g
+	} else {
+		rgba := image.NewRGBA(image.Rect(0, 0, 203, 117))
+		for y := 0; y < 117; y++ {
+			for x := 0; x < 203; x++ {
+				rgba.Set(x, y, color.RGBA{uint8(x), uint8(2 * y), uint8((x + y) / 2), 255})
+			}
+		}
+		img = rgba
+	}
+	var b bytes.Buffer
+	if err := jpeg.Encode(&b, img, &jpeg.Options{Quality: 95}); err != nil {
+		t.Fatalf("Could not encode test image: %s", err)
+	}
+	return b.Bytes()
+}
+
+// luma returns the brightness at x, y, chroma is subsampled differently when scaled
+func luma(img image.Image, x, y int) uint32 {
+	return uint32(color.GrayModel.Convert(img.At(x, y)).(color.Gray).Y)
+}
+
+// average returns the mean brightness of the scale x scale pixels at x, y of img
+func average(img image.Image, x, y, scale int) uint32 {
+	var sum uint32
+	for yy := y * scale; yy < (y+1)*scale; yy++ {
+		for xx := x * scale; xx < (x+1)*scale; xx++ {
+			sum += luma(img, xx, yy)
+		}
+	}
+	return sum / uint32(scale*scale)
+}
+
+func diff(a, b uint32) uint32 {
+	if a > b {
+		return a - b
+	}
+	return b - a
+}
+
+func TestDecodeScaled(t *testing.T) {
+	for _, gray := range []bool{false, true} {
+		data := testJPEG(t, gray)
+		full, err := jpeg.Decode(bytes.NewReader(data))
+		if err != nil {
+			t.Fatalf("Could not decode test image: %s", err)
+		}
+		for _, scale := range Scales {
+			img, err := Decode(bytes.NewReader(data), scale)
+			if err != nil {
+				t.Fatalf("Error decoding at 1/%d: %s", scale, err)
+			}
+			want := image.Pt((203+scale-1)/scale, (117+scale-1)/scale)
+			if size := img.Bounds().Size(); size != want {
+				t.Errorf("Wrong size at 1/%d: %v, expected %v", scale, size, want)
+			}
+			// Only the complete blocks, the edges are padded by the encoder
+			for y := 0; y < 112/scale; y++ {
+				for x := 0; x < 200/scale; x++ {
+					if got, want := luma(img, x, y), average(full, x, y, scale); diff(got, want) > 4 {
+						t.Fatalf("Wrong brightness at %d,%d at 1/%d (gray %v): %d, expected %d", x, y, scale, gray, got, want)
+					}
+				}
+			}
+		}
+	}
+	if _, err := Decode(bytes.NewReader(testJPEG(t, false)), 3); err == nil {
+		t.Errorf("Unsupported scale accepted")
+	}
+}
+
+func TestDecodeConfig(t *testing.T) {
+	cfg, err := DecodeConfig(bytes.NewReader(testJPEG(t, false)))
+	if err != nil {
+		t.Fatalf("Error reading config: %s", err)
+	}
+	if cfg.Width != 203 || cfg.Height != 117 {
+		t.Errorf("Wrong size %dx%d", cfg.Width, cfg.Height)
+	}
+}
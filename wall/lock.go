@@ -0,0 +1,50 @@
+package wall
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// ErrLocked is returned by LockStore if another process holds the lock
+var ErrLocked = errors.New("store is locked by another process")
+
+// lockName is the lock file inside the store directory
+const lockName = ".photowall.lock"
+
+// StoreLock is an exclusive lock of a store directory, see LockStore
+type StoreLock struct {
+	f *os.File
+}
+
+// LockStore locks the store directory against writes of other processes, e.g. of
+// offline commands while the server runs. The lock is released by Unlock or when
+// the process exits. Locking is only supported on unix systems.
+func LockStore(dir string) (*StoreLock, error) {
+	f, err := os.OpenFile(filepath.Join(dir, lockName), os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+	if err = lockFile(f); err != nil {
+		pid, _ := ioutil.ReadAll(f)
+		f.Close()
+		if err == ErrLocked && len(pid) > 0 {
+			return nil, fmt.Errorf("%w (pid %s)", ErrLocked, strings.TrimSpace(string(pid)))
+		}
+		return nil, err
+	}
+	// The pid is informational, the lock is held by the open file
+	f.Truncate(0)
+	f.WriteAt([]byte(strconv.Itoa(os.Getpid())+"\n"), 0)
+	return &StoreLock{f: f}, nil
+}
+
+// Unlock releases the lock
+func (l *StoreLock) Unlock() error {
+	l.f.Truncate(0)
+	return l.f.Close()
+}
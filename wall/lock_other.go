@@ -0,0 +1,10 @@
+//go:build !unix
+
+package wall
+
+import "os"
+
+// lockFile doesn't lock, flock is not available
+func lockFile(f *os.File) error {
+	return nil
+}
@@ -0,0 +1,33 @@
+//go:build unix
+
+package wall
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func TestLockStore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatalf("Could not create tmp dir: %s", err)
+	}
+	defer os.RemoveAll(dir)
+	lock, err := LockStore(dir)
+	if err != nil {
+		t.Fatalf("Could not lock: %s", err)
+	}
+	if _, err = LockStore(dir); !errors.Is(err, ErrLocked) {
+		t.Errorf("Locked store was locked again: %v", err)
+	}
+	if err = lock.Unlock(); err != nil {
+		t.Errorf("Could not unlock: %s", err)
+	}
+	lock, err = LockStore(dir)
+	if err != nil {
+		t.Fatalf("Unlocked store could not be locked: %s", err)
+	}
+	lock.Unlock()
+}
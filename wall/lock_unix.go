@@ -0,0 +1,17 @@
+//go:build unix
+
+package wall
+
+import (
+	"os"
+	"syscall"
+)
+
+// lockFile takes an exclusive flock, released when the file is closed
+func lockFile(f *os.File) error {
+	err := syscall.Flock(int(f.Fd()), syscall.LOCK_EX|syscall.LOCK_NB)
+	if err == syscall.EWOULDBLOCK {
+		return ErrLocked
+	}
+	return err
+}
@@ -0,0 +1,160 @@
+package wall
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"image"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// photoMeta is the metadata file stored next to each photo of the store
+type photoMeta struct {
+	PhotoInfo
+	Format    string    `json:"format"`
+	Width     int       `json:"width"`
+	Height    int       `json:"height"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// metaName returns the name of the metadata file belonging to a photo file
+func metaName(name string) string {
+	return strings.TrimSuffix(name, filepath.Ext(name)) + ".json"
+}
+
+// writeMeta atomically writes the metadata file of the photo
+func writeMeta(p Photo) error {
+	size := p.Bounds().Size()
+	b, err := json.MarshalIndent(photoMeta{
+		PhotoInfo: InfoOf(p),
+		Format:    p.Format(),
+		Width:     size.X,
+		Height:    size.Y,
+		CreatedAt: p.CreatedAt(),
+	}, "", "  ")
+	if err != nil {
+		return err
+	}
+	name := metaName(p.Name())
+	tmp, err := ioutil.TempFile(filepath.Dir(name), ".meta")
+	if err != nil {
+		return err
+	}
+	_, err = tmp.Write(b)
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), name)
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+	}
+	return err
+}
+
+// readMeta reads the metadata file of the photo file name
+func readMeta(name string) (Photo, error) {
+	b, err := ioutil.ReadFile(metaName(name))
+	if err != nil {
+		return nil, err
+	}
+	var m photoMeta
+	if err = json.Unmarshal(b, &m); err != nil {
+		return nil, err
+	}
+	return NewPhotoWithInfo(name, m.Width, m.Height, m.Format, m.CreatedAt, m.PhotoInfo), nil
+}
+
+// LoadPhotos reads the photos of a store directory like the Importer restores them,
+// but from the metadata only, without decoding or changing anything. It's meant for
+// read-only use like listing the store. Photos without metadata get the dimensions
+// from their header and an id derived from their content, unreadable ones are skipped.
+func LoadPhotos(dir string) (Photos, error) {
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	var photos Photos
+	for _, f := range files {
+		if !f.Mode().IsRegular() || strings.ToLower(filepath.Ext(f.Name())) != ".jpg" {
+			continue
+		}
+		name := filepath.Join(dir, f.Name())
+		p, err := readMeta(name)
+		if err == nil && p.Checksum() != "" {
+			info := InfoOf(p)
+			info.MIMEType = "image/jpeg"
+			photos = append(photos, publish(NewPhotoWithInfo(name, p.Bounds().Dx(), p.Bounds().Dy(), "jpg", p.CreatedAt(), info)))
+			continue
+		}
+		if p, err = loadUnindexed(name, p); err == nil {
+			photos = append(photos, publish(p))
+		}
+	}
+	return photos, nil
+}
+
+// loadUnindexed reads a photo without metadata or checksum by its header and content
+func loadUnindexed(name string, meta Photo) (Photo, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	cfg, format, err := image.DecodeConfig(f)
+	f.Close()
+	if err != nil {
+		return nil, err
+	}
+	if format != "jpeg" {
+		return nil, errors.New("Not a valid jpeg")
+	}
+	chsum, size, err := checksumFile(name)
+	if err != nil {
+		return nil, err
+	}
+	createdAt := modTime(name)
+	var info PhotoInfo
+	if meta != nil {
+		createdAt, info = meta.CreatedAt(), InfoOf(meta)
+	}
+	info.ID = chsum[:16]
+	info.Checksum, info.Size, info.MIMEType = chsum, size, "image/jpeg"
+	return NewPhotoWithInfo(name, cfg.Width, cfg.Height, "jpg", createdAt, info), nil
+}
+
+// removeMeta removes the metadata file of the photo file name
+func removeMeta(name string) {
+	os.Remove(metaName(name))
+}
+
+// checksumFile returns the hex encoded sha1 checksum and size of a file
+func checksumFile(name string) (string, int64, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return "", 0, err
+	}
+	defer f.Close()
+	hash := sha1.New()
+	n, err := io.Copy(hash, f)
+	if err != nil {
+		return "", 0, err
+	}
+	return hex.EncodeToString(hash.Sum(nil)), n, nil
+}
+
+// publish marks photos which passed the pipeline as published, other states like pending are kept
+func publish(p Photo) Photo {
+	if p.Status() != StatusNew {
+		return p
+	}
+	info := InfoOf(p)
+	info.Status = StatusPublished
+	return WithInfo(p, info)
+}
@@ -0,0 +1,115 @@
+package wall
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestStoreMetadataImport(t *testing.T) {
+	dirName, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatalf("Could not create tmp dir: %s", err)
+	}
+	defer os.RemoveAll(dirName)
+	pName, err := createStoreTestImg()
+	if err != nil {
+		t.Fatalf("Could not test image: %s", err)
+	}
+	defer os.Remove(pName)
+
+	createdAt := time.Now().Add(-time.Hour).Round(time.Second)
+	in := NewPhotoWithInfo(pName, 1000, 2000, "jpg", createdAt, PhotoInfo{Caption: "caption"})
+	stored, err := NewStore(dirName).Process(in)
+	if err != nil {
+		t.Fatalf("Error while processing: %s", err)
+	}
+	if stored.ID() != in.ID() || stored.Checksum() == "" || stored.Size() == 0 || stored.Status() != StatusPublished {
+		t.Errorf("Wrong stored metadata: %v", InfoOf(stored))
+	}
+
+	imported, err := Importer().Process(NewPhoto(stored.Name(), 0, 0, "", time.Now()))
+	if err != nil {
+		t.Fatalf("Could not import stored photo: %s", err)
+	}
+	if InfoOf(imported) != InfoOf(stored) {
+		t.Errorf("Metadata not restored: %v, expected %v", InfoOf(imported), InfoOf(stored))
+	}
+	if !imported.CreatedAt().Equal(createdAt) {
+		t.Errorf("Wrong createdAt: %s", imported.CreatedAt())
+	}
+
+	// Without metadata the id is derived from the content
+	os.Remove(metaName(stored.Name()))
+	imported, err = Importer().Process(NewPhoto(stored.Name(), 0, 0, "", time.Now()))
+	if err != nil {
+		t.Fatalf("Could not import stored photo: %s", err)
+	}
+	if imported.ID() != stored.Checksum()[:16] {
+		t.Errorf("Wrong id derived: %s", imported.ID())
+	}
+
+	NewStore(dirName).Remove(stored)
+	if files, _ := ioutil.ReadDir(dirName); len(files) != 0 {
+		t.Errorf("Stored photo not removed: %s", filepath.Join(dirName, files[0].Name()))
+	}
+}
+
+func TestLoadPhotos(t *testing.T) {
+	dirName, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatalf("Could not create tmp dir: %s", err)
+	}
+	defer os.RemoveAll(dirName)
+	pName, err := createStoreTestImg()
+	if err != nil {
+		t.Fatalf("Could not test image: %s", err)
+	}
+	defer os.Remove(pName)
+	b, _ := ioutil.ReadFile(pName)
+
+	createdAt := time.Now().Add(-time.Hour).Round(time.Second)
+	in := NewPhotoWithInfo(pName, 1000, 2000, "jpg", createdAt, PhotoInfo{Caption: "caption", Status: StatusPending})
+	stored, err := NewStore(dirName).Process(in)
+	if err != nil {
+		t.Fatalf("Error while processing: %s", err)
+	}
+	unindexed := filepath.Join(dirName, "unindexed.jpg")
+	ioutil.WriteFile(unindexed, b, 0644)
+	ioutil.WriteFile(filepath.Join(dirName, "broken.jpg"), []byte("no jpeg"), 0644)
+	ioutil.WriteFile(filepath.Join(dirName, "other.png"), b, 0644)
+
+	photos, err := LoadPhotos(dirName)
+	if err != nil {
+		t.Fatalf("Could not load photos: %s", err)
+	}
+	if len(photos) != 2 {
+		t.Fatalf("Wrong number of photos %d", len(photos))
+	}
+	byID := make(map[string]Photo)
+	for _, p := range photos {
+		byID[p.ID()] = p
+	}
+	loaded := byID[stored.ID()]
+	if loaded == nil || InfoOf(loaded) != InfoOf(stored) || !loaded.CreatedAt().Equal(createdAt) || loaded.Bounds() != stored.Bounds() {
+		t.Errorf("Wrong loaded photo %v, expected %v", loaded, stored)
+	}
+	imported, err := Importer().Process(NewPhoto(unindexed, 0, 0, "", time.Now()))
+	if err != nil {
+		t.Fatalf("Could not import photo: %s", err)
+	}
+	loaded = byID[imported.ID()]
+	if loaded == nil || loaded.Name() != unindexed || loaded.Bounds() != imported.Bounds() || loaded.Status() != StatusPublished {
+		t.Errorf("Wrong photo without metadata %v, expected %v", loaded, imported)
+	}
+
+	missing := filepath.Join(dirName, "missing")
+	if _, err = LoadPhotos(missing); err == nil {
+		t.Errorf("Missing directory loaded")
+	}
+	if _, err = os.Stat(missing); !os.IsNotExist(err) {
+		t.Errorf("Missing directory created")
+	}
+}
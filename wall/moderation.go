@@ -0,0 +1,249 @@
+package wall
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"golang.org/x/text/runes"
+	"golang.org/x/text/transform"
+	"golang.org/x/text/unicode/norm"
+	"os"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+)
+
+// ErrTextRejected is returned by TextFilter if a caption or author name contains blocked words
+var ErrTextRejected = errors.New("text contains blocked words")
+
+// FilterAction defines what TextFilter does with photos containing blocked words
+type FilterAction int
+
+const (
+	// Mask replaces blocked words by asterisks
+	Mask FilterAction = iota
+	// Reject fails processing with ErrTextRejected
+	Reject
+	// Moderate keeps the text but holds the photo back as StatusPending
+	Moderate
+)
+
+// ParseFilterAction parses "mask", "reject" or "moderate"
+func ParseFilterAction(s string) (FilterAction, error) {
+	switch strings.ToLower(s) {
+	case "mask":
+		return Mask, nil
+	case "reject":
+		return Reject, nil
+	case "moderate":
+		return Moderate, nil
+	}
+	return Mask, fmt.Errorf("unknown filter action: %s", s)
+}
+
+// TextFilter is a processor checking captions and author names against a word list.
+// The list is a text file with one word or phrase per line, lines starting with # are ignored.
+// Phrases match their words in order, separated by any punctuation or space.
+// Words are compared after unicode normalisation, so "Ünicode", "unicode" and "un1c0de"
+// are equal. The file is reloaded automatically when it changes.
+type TextFilter struct {
+	path    string
+	action  FilterAction
+	words   map[string]struct{}
+	phrases [][]string // Entries of several words, folded
+	modTime time.Time
+	mutex   sync.RWMutex
+}
+
+// NewTextFilter creates a TextFilter reading the word list from path
+func NewTextFilter(path string, action FilterAction) (*TextFilter, error) {
+	f := &TextFilter{
+		path:   path,
+		action: action,
+	}
+	if err := f.Reload(); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+func (f *TextFilter) String() string {
+	return "textfilter"
+}
+
+// Reload reads the word list again
+func (f *TextFilter) Reload() error {
+	file, err := os.Open(f.path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	stat, err := file.Stat()
+	if err != nil {
+		return err
+	}
+	words := make(map[string]struct{})
+	var phrases [][]string
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		var phrase []string
+		for _, word := range strings.FieldsFunc(line, func(r rune) bool { return !isWordRune(r) }) {
+			phrase = append(phrase, foldWord(word))
+		}
+		switch len(phrase) {
+		case 0:
+		case 1:
+			words[phrase[0]] = struct{}{}
+		default:
+			phrases = append(phrases, phrase)
+		}
+	}
+	if err = scanner.Err(); err != nil {
+		return err
+	}
+	f.mutex.Lock()
+	f.words = words
+	f.phrases = phrases
+	f.modTime = stat.ModTime()
+	f.mutex.Unlock()
+	return nil
+}
+
+// reloadIfChanged reloads the word list if the file was modified, the old list is kept on errors
+func (f *TextFilter) reloadIfChanged() {
+	stat, err := os.Stat(f.path)
+	if err != nil {
+		return
+	}
+	f.mutex.RLock()
+	changed := !stat.ModTime().Equal(f.modTime)
+	f.mutex.RUnlock()
+	if changed {
+		f.Reload()
+	}
+}
+
+// textToken is a word or the characters between words
+type textToken struct {
+	text   []rune
+	word   bool
+	folded string
+}
+
+// mask replaces blocked words and the words of blocked phrases inside text by asterisks
+func (f *TextFilter) mask(text string) (string, bool) {
+	var tokens []textToken
+	var words []int // Indexes of the word tokens
+	for _, r := range text {
+		word := isWordRune(r)
+		if n := len(tokens); n > 0 && tokens[n-1].word == word {
+			tokens[n-1].text = append(tokens[n-1].text, r)
+			continue
+		}
+		if word {
+			words = append(words, len(tokens))
+		}
+		tokens = append(tokens, textToken{text: []rune{r}, word: word})
+	}
+	for _, i := range words {
+		tokens[i].folded = foldWord(string(tokens[i].text))
+	}
+
+	f.mutex.RLock()
+	blocked := make([]bool, len(words))
+	for i, t := range words {
+		if _, ok := f.words[tokens[t].folded]; ok {
+			blocked[i] = true
+		}
+		for _, phrase := range f.phrases {
+			if matchPhrase(tokens, words[i:], phrase) {
+				for j := range phrase {
+					blocked[i+j] = true
+				}
+			}
+		}
+	}
+	f.mutex.RUnlock()
+
+	var b strings.Builder
+	found := false
+	w := 0 // Index of the next word
+	for _, t := range tokens {
+		if t.word {
+			w++
+			if blocked[w-1] {
+				found = true
+				b.WriteString(strings.Repeat("*", len(t.text)))
+				continue
+			}
+		}
+		b.WriteString(string(t.text))
+	}
+	return b.String(), found
+}
+
+// matchPhrase reports whether the words starting with words[0] are the phrase
+func matchPhrase(tokens []textToken, words []int, phrase []string) bool {
+	if len(words) < len(phrase) {
+		return false
+	}
+	for j, word := range phrase {
+		if tokens[words[j]].folded != word {
+			return false
+		}
+	}
+	return true
+}
+
+// Process checks caption and author of the photo
+func (f *TextFilter) Process(p Photo) (Photo, error) {
+	f.reloadIfChanged()
+	info := InfoOf(p)
+	caption, captionBlocked := f.mask(info.Caption)
+	author, authorBlocked := f.mask(info.Author)
+	if !captionBlocked && !authorBlocked {
+		return p, nil
+	}
+	switch f.action {
+	case Reject:
+		return nil, ErrTextRejected
+	case Moderate:
+		info.Status = StatusPending
+	default:
+		info.Caption = caption
+		info.Author = author
+	}
+	return WithInfo(p, info), nil
+}
+
+// leetspeak replacements applied before comparing words
+var leetReplacer = strings.NewReplacer(
+	"0", "o",
+	"1", "i",
+	"3", "e",
+	"4", "a",
+	"5", "s",
+	"7", "t",
+	"@", "a",
+	"$", "s",
+)
+
+func isWordRune(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsDigit(r) || unicode.Is(unicode.Mn, r) || r == '@' || r == '$'
+}
+
+// foldWord normalises a word for comparison: compatibility decomposition,
+// removal of diacritics, lower case and leetspeak folding
+func foldWord(s string) string {
+	t := transform.Chain(norm.NFKD, runes.Remove(runes.In(unicode.Mn)), norm.NFC)
+	folded, _, err := transform.String(t, s)
+	if err != nil {
+		folded = s
+	}
+	return leetReplacer.Replace(strings.ToLower(folded))
+}
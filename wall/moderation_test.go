@@ -0,0 +1,114 @@
+package wall
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+)
+
+func createBlocklist(t *testing.T, content string) string {
+	f, err := ioutil.TempFile("", "blocklist")
+	if err != nil {
+		t.Fatalf("Could not create blocklist: %s", err)
+	}
+	defer f.Close()
+	f.WriteString(content)
+	return f.Name()
+}
+
+func TestTextFilterMask(t *testing.T) {
+	name := createBlocklist(t, "# comment\nbadword\n\nÄrger\n")
+	defer os.Remove(name)
+	f, err := NewTextFilter(name, Mask)
+	if err != nil {
+		t.Fatalf("Could not create filter: %s", err)
+	}
+
+	tests := map[string]string{
+		"Happy birthday Anna!":  "Happy birthday Anna!",
+		"so BADWORD, really":    "so *******, really",
+		"b4dw0rd and b@dword":   "******* and *******",
+		"ＢＡＤＷＯＲＤ":               "*******",
+		"kein arger, nur ärger": "kein *****, nur *****",
+	}
+	for in, expected := range tests {
+		p := NewPhotoWithInfo("in", 0, 0, "", time.Now(), PhotoInfo{Caption: in, Author: in})
+		out, err := f.Process(p)
+		if err != nil {
+			t.Fatalf("Error filtering %q: %s", in, err)
+		}
+		if out.Caption() != expected || out.Author() != expected {
+			t.Errorf("Wrong masked text for %q: %q", in, out.Caption())
+		}
+	}
+}
+
+func TestTextFilterPhrases(t *testing.T) {
+	name := createBlocklist(t, "bad word\nvery-rude phrase\n")
+	defer os.Remove(name)
+	f, err := NewTextFilter(name, Mask)
+	if err != nil {
+		t.Fatalf("Could not create filter: %s", err)
+	}
+
+	tests := map[string]string{
+		"a bad word here":          "a *** **** here",
+		"BAD-W0RD!":                "***-****!",
+		"bad, but a good word":     "bad, but a good word",
+		"word bad":                 "word bad",
+		"this very rude phrase it": "this **** **** ****** it",
+		"very rude":                "very rude",
+	}
+	for in, expected := range tests {
+		p := NewPhotoWithInfo("in", 0, 0, "", time.Now(), PhotoInfo{Caption: in})
+		out, err := f.Process(p)
+		if err != nil {
+			t.Fatalf("Error filtering %q: %s", in, err)
+		}
+		if out.Caption() != expected {
+			t.Errorf("Wrong masked text for %q: %q", in, out.Caption())
+		}
+	}
+}
+
+func TestTextFilterActions(t *testing.T) {
+	name := createBlocklist(t, "badword\n")
+	defer os.Remove(name)
+	p := NewPhotoWithInfo("in", 0, 0, "", time.Now(), PhotoInfo{Author: "badword"})
+
+	f, _ := NewTextFilter(name, Reject)
+	if _, err := f.Process(p); err != ErrTextRejected {
+		t.Errorf("Photo not rejected: %v", err)
+	}
+
+	f, _ = NewTextFilter(name, Moderate)
+	out, err := f.Process(p)
+	if err != nil {
+		t.Fatalf("Error filtering: %s", err)
+	}
+	if out.Status() != StatusPending || out.Author() != "badword" {
+		t.Errorf("Photo not held back for moderation: %v", InfoOf(out))
+	}
+	if publish(out).Status() != StatusPending {
+		t.Errorf("Pending photo was published")
+	}
+}
+
+func TestTextFilterReload(t *testing.T) {
+	name := createBlocklist(t, "badword\n")
+	defer os.Remove(name)
+	f, _ := NewTextFilter(name, Reject)
+
+	p := NewPhotoWithInfo("in", 0, 0, "", time.Now(), PhotoInfo{Caption: "newword"})
+	if _, err := f.Process(p); err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	if err := ioutil.WriteFile(name, []byte("newword\n"), 0644); err != nil {
+		t.Fatalf("Could not write blocklist: %s", err)
+	}
+	os.Chtimes(name, time.Now(), time.Now().Add(time.Second))
+	if _, err := f.Process(p); err != ErrTextRejected {
+		t.Errorf("Changed blocklist not reloaded: %v", err)
+	}
+}
@@ -0,0 +1,137 @@
+package wall
+
+import (
+	"bufio"
+	"context"
+	"image"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// Originals keeps a copy of each uploaded file before later processors like
+// the Resizer replace it. Copies are named by the photo id and the image format.
+type Originals struct {
+	dir     string
+	writing map[string]struct{} // ids of copies whose photo isn't added to the wall yet
+	mutex   sync.Mutex
+}
+
+// NewOriginals creates a processor copying uploads into directory
+func NewOriginals(directory string) *Originals {
+	return &Originals{dir: directory, writing: make(map[string]struct{})}
+}
+
+// Process copies the file of the photo and passes the photo on unchanged
+func (o *Originals) Process(p Photo) (Photo, error) {
+	return o.ProcessContext(context.Background(), p)
+}
+
+// ProcessContext copies the file like Process, a cancelled copy is removed
+func (o *Originals) ProcessContext(ctx context.Context, p Photo) (Photo, error) {
+	fin, err := os.Open(p.Name())
+	if err != nil {
+		return nil, err
+	}
+	defer fin.Close()
+	_, format, err := image.DecodeConfig(bufio.NewReader(fin))
+	if err != nil {
+		return nil, err
+	}
+	if format == "jpeg" {
+		format = "jpg"
+	}
+	if _, err = fin.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+	if err = os.MkdirAll(o.dir, 0755); err != nil {
+		return nil, err
+	}
+	name := filepath.Join(o.dir, p.ID()+"."+format)
+	o.setWriting(p.ID(), true)
+	fout, err := os.Create(name)
+	if err != nil {
+		o.setWriting(p.ID(), false)
+		return nil, err
+	}
+	_, err = io.Copy(fout, fin)
+	if cerr := fout.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = ctx.Err()
+	}
+	if err != nil {
+		os.Remove(name)
+		o.setWriting(p.ID(), false)
+		return nil, err
+	}
+	return p, nil
+}
+
+// Cleanup removes the copy if a later processor failed
+func (o *Originals) Cleanup(p Photo) {
+	o.Remove(p)
+	o.setWriting(p.ID(), false)
+}
+
+// Added marks the copy of the photo as complete, it has to be registered as
+// Observer for added photos. Until then Verify doesn't report the copy.
+func (o *Originals) Added(p Photo) {
+	o.setWriting(p.ID(), false)
+}
+
+func (o *Originals) setWriting(id string, writing bool) {
+	o.mutex.Lock()
+	if writing {
+		o.writing[id] = struct{}{}
+	} else {
+		delete(o.writing, id)
+	}
+	o.mutex.Unlock()
+}
+
+// isWriting reports whether the photo of the copy is still processed
+func (o *Originals) isWriting(id string) bool {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+	_, ok := o.writing[id]
+	return ok
+}
+
+// Original returns the path of the original file of the photo with the given id
+func (o *Originals) Original(id string) (string, bool) {
+	matches, _ := filepath.Glob(filepath.Join(o.dir, filepath.Base(id)+".*"))
+	if len(matches) == 0 {
+		return "", false
+	}
+	return matches[0], true
+}
+
+// Remove deletes the original of a photo, it can be registered as Observer for removed photos
+func (o *Originals) Remove(p Photo) {
+	if name, ok := o.Original(p.ID()); ok {
+		os.Remove(name)
+	}
+}
+
+// Files returns the paths of all kept originals, sorted
+func (o *Originals) Files() ([]string, error) {
+	files, err := ioutil.ReadDir(o.dir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	for _, f := range files {
+		if f.Mode().IsRegular() && !strings.HasPrefix(f.Name(), ".") {
+			names = append(names, filepath.Join(o.dir, f.Name()))
+		}
+	}
+	return names, nil
+}
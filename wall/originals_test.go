@@ -0,0 +1,44 @@
+package wall
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestOriginals(t *testing.T) {
+	dirName, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatalf("Could not create tmp dir: %s", err)
+	}
+	defer os.RemoveAll(dirName)
+	pName, err := createStoreTestImg()
+	if err != nil {
+		t.Fatalf("Could not test image: %s", err)
+	}
+	defer os.Remove(pName)
+
+	o := NewOriginals(filepath.Join(dirName, "originals"))
+	p := NewPhoto(pName, 0, 0, "", time.Now())
+	out, err := o.Process(p)
+	if err != nil {
+		t.Fatalf("Error while processing: %s", err)
+	}
+	if out.Name() != pName {
+		t.Errorf("Photo changed: %s", out.Name())
+	}
+	name, ok := o.Original(p.ID())
+	if !ok || filepath.Base(name) != p.ID()+".jpg" {
+		t.Fatalf("Original not found: %s", name)
+	}
+	if _, err = os.Stat(pName); err != nil {
+		t.Errorf("Input removed: %s", err)
+	}
+
+	o.Remove(p)
+	if _, ok = o.Original(p.ID()); ok {
+		t.Errorf("Original not removed")
+	}
+}
@@ -1,28 +1,106 @@
 package wall
 
 import (
+	"crypto/rand"
+	"encoding/hex"
 	"image"
+	"mime"
 	"sort"
+	"strings"
 	"time"
 )
 
+// Status represents the lifecycle state of a photo
+type Status string
+
+const (
+	// StatusNew is the status of photos inside the processing pipeline
+	StatusNew Status = "new"
+	// StatusPublished is the status of photos shown on the wall
+	StatusPublished Status = "published"
+	// StatusPending is the status of photos waiting for moderation
+	StatusPending Status = "pending"
+)
+
+// PhotoInfo holds the metadata of a photo besides its file and dimensions
+type PhotoInfo struct {
+	ID       string `json:"id"`
+	Checksum string `json:"checksum,omitempty"` // Hex encoded sha1 of the file
+	Size     int64  `json:"size,omitempty"`     // File size in bytes
+	MIMEType string `json:"mime_type,omitempty"`
+	Caption  string `json:"caption,omitempty"`
+	Author   string `json:"author,omitempty"`
+	Uploader string `json:"uploader,omitempty"` // Id of the access token used for the upload
+	Filename string `json:"filename,omitempty"` // Name of the file on the guest's device
+	Status   Status `json:"status"`
+}
+
 type wallPhoto struct {
 	name      string
 	bounds    image.Rectangle
 	format    string
 	createdAt time.Time
+	info      PhotoInfo
 }
 
-// NewPhoto creates a new photo
+// NewPhoto creates a new photo with a new unique id
 func NewPhoto(name string, width, height int, format string, createdAt time.Time) Photo {
+	return NewPhotoWithInfo(name, width, height, format, createdAt, PhotoInfo{})
+}
+
+// NewPhotoWithInfo creates a new photo with the given metadata.
+// An empty ID is replaced by a new unique id, an empty Status by StatusNew
+// and an empty MIMEType is derived from the format.
+func NewPhotoWithInfo(name string, width, height int, format string, createdAt time.Time, info PhotoInfo) Photo {
+	if info.ID == "" {
+		info.ID = newPhotoID()
+	}
+	if info.Status == "" {
+		info.Status = StatusNew
+	}
+	if info.MIMEType == "" && format != "" {
+		info.MIMEType = mime.TypeByExtension("." + strings.ToLower(format))
+	}
 	return wallPhoto{
 		name:      name,
 		bounds:    image.Rect(0, 0, width, height),
 		format:    format,
 		createdAt: createdAt,
+		info:      info,
 	}
 }
 
+// InfoOf returns the metadata of a photo
+func InfoOf(p Photo) PhotoInfo {
+	return PhotoInfo{
+		ID:       p.ID(),
+		Checksum: p.Checksum(),
+		Size:     p.Size(),
+		MIMEType: p.MIMEType(),
+		Caption:  p.Caption(),
+		Author:   p.Author(),
+		Uploader: p.Uploader(),
+		Filename: p.Filename(),
+		Status:   p.Status(),
+	}
+}
+
+// WithInfo returns a copy of the photo with replaced metadata
+func WithInfo(p Photo, info PhotoInfo) Photo {
+	size := p.Bounds().Size()
+	return NewPhotoWithInfo(p.Name(), size.X, size.Y, p.Format(), p.CreatedAt(), info)
+}
+
+func newPhotoID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+func (p wallPhoto) ID() string {
+	return p.info.ID
+}
+
 func (p wallPhoto) Name() string {
 	return p.name
 }
@@ -39,12 +117,53 @@ func (p wallPhoto) CreatedAt() time.Time {
 	return p.createdAt
 }
 
+func (p wallPhoto) Checksum() string {
+	return p.info.Checksum
+}
+
+func (p wallPhoto) Size() int64 {
+	return p.info.Size
+}
+
+func (p wallPhoto) MIMEType() string {
+	return p.info.MIMEType
+}
+
+func (p wallPhoto) Caption() string {
+	return p.info.Caption
+}
+
+func (p wallPhoto) Author() string {
+	return p.info.Author
+}
+
+func (p wallPhoto) Uploader() string {
+	return p.info.Uploader
+}
+
+func (p wallPhoto) Filename() string {
+	return p.info.Filename
+}
+
+func (p wallPhoto) Status() Status {
+	return p.info.Status
+}
+
 // Photo represents a photo on the photowall
 type Photo interface {
-	Name() string
+	ID() string              // Unique id, stable across restarts
+	Name() string            // Path of the file
 	Bounds() image.Rectangle // width, height
 	Format() string          // png, jpeg
 	CreatedAt() time.Time
+	Checksum() string
+	Size() int64
+	MIMEType() string
+	Caption() string
+	Author() string
+	Uploader() string
+	Filename() string
+	Status() Status
 }
 
 // Photos is a collection of photos
@@ -65,6 +184,17 @@ func (s Photos) Less(i, j int) bool {
 	return s[i].CreatedAt().Before(s[j].CreatedAt())
 }
 
+// WithStatus returns the photos having the given status
+func (s Photos) WithStatus(status Status) Photos {
+	var filtered Photos
+	for _, p := range s {
+		if p.Status() == status {
+			filtered = append(filtered, p)
+		}
+	}
+	return filtered
+}
+
 // SortPhotos sorts photos
 func SortPhotos(photos Photos) {
 	sort.Sort(photos)
@@ -46,3 +46,30 @@ func TestSort(t *testing.T) {
 	}
 
 }
+
+func TestPhotoInfo(t *testing.T) {
+	p := NewPhoto("test.jpg", 100, 200, "jpg", time.Now())
+	if p.ID() == "" || p.ID() == NewPhoto("test.jpg", 100, 200, "jpg", time.Now()).ID() {
+		t.Errorf("Photo id not unique: %s", p.ID())
+	}
+	if p.Status() != StatusNew {
+		t.Errorf("Wrong status: %s", p.Status())
+	}
+	if p.MIMEType() != "image/jpeg" {
+		t.Errorf("Wrong mime type: %s", p.MIMEType())
+	}
+
+	info := InfoOf(p)
+	info.Caption = "caption"
+	info.Author = "author"
+	info.Uploader = "token"
+	info.Filename = "IMG_0001.JPG"
+	info.Status = StatusPublished
+	p2 := WithInfo(p, info)
+	if p2.ID() != p.ID() || p2.Name() != p.Name() || p2.Bounds() != p.Bounds() {
+		t.Errorf("Photo changed: %v", p2)
+	}
+	if p2.Caption() != "caption" || p2.Author() != "author" || p2.Uploader() != "token" || p2.Filename() != "IMG_0001.JPG" || p2.Status() != StatusPublished {
+		t.Errorf("Wrong info: %v", InfoOf(p2))
+	}
+}
@@ -1,7 +1,11 @@
 package wall
 
 import (
-	"log"
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
 	"sync"
 	"time"
 )
@@ -19,19 +23,76 @@ func (f ProcessorFunc) Process(p Photo) (Photo, error) {
 	return f(p)
 }
 
+// ContextProcessor is a Processor which honours cancellation and deadlines of the job's context.
+// Processors only implementing Processor are adapted by WithContext.
+type ContextProcessor interface {
+	Processor
+	ProcessContext(ctx context.Context, p Photo) (Photo, error)
+}
+
+// ContextProcessorFunc can be used instead of the ContextProcessor type
+type ContextProcessorFunc func(ctx context.Context, p Photo) (Photo, error)
+
+// Process runs the ContextProcessorFunc with a background context
+func (f ContextProcessorFunc) Process(p Photo) (Photo, error) {
+	return f(context.Background(), p)
+}
+
+// ProcessContext transforms ContextProcessorFunc to a ContextProcessor
+func (f ContextProcessorFunc) ProcessContext(ctx context.Context, p Photo) (Photo, error) {
+	return f(ctx, p)
+}
+
+// ProcessorName returns a short name of the processor like "resizer" for logs and metrics.
+// Processors can choose their name by implementing fmt.Stringer.
+func ProcessorName(p Processor) string {
+	switch wp := p.(type) {
+	case policyProcessor:
+		return ProcessorName(wp.Processor)
+	case contextProcessor:
+		return ProcessorName(wp.Processor)
+	}
+	if s, ok := p.(fmt.Stringer); ok {
+		return s.String()
+	}
+	name := strings.TrimLeft(fmt.Sprintf("%T", p), "*")
+	if i := strings.LastIndex(name, "."); i >= 0 {
+		name = name[i+1:]
+	}
+	return strings.ToLower(name)
+}
+
 // Observer gets notified if something changes on the wall
 type Observer func(p Photo)
 
+// ErrorObserver gets notified if a processor fails, err is a *ProcessError
+type ErrorObserver func(p Photo, err error)
+
+// ProcessObserver gets notified after every processor run, the duration includes retries
+type ProcessObserver func(proc Processor, d time.Duration, err error)
+
 // Photowall represents a wall of photos
 type Photowall interface {
-	AddPhotoFromFile(name string, createdAt time.Time) error
-	AddPhoto(p Photo) error
+	AddPhotoFromFile(ctx context.Context, name string, createdAt time.Time) error
+	AddPhoto(ctx context.Context, p Photo) error
+	GetPhoto(id string) (Photo, error)
 	RemovePhoto(photo Photo)
+	RemovePhotoByID(id string) error
+	SetStatus(id string, status Status) error
 	OnAdd(o Observer)
 	OnRemove(o Observer)
+	OnUpdate(o Observer)
+	OnError(o ErrorObserver)
+	OnProcess(o ProcessObserver)
 	Photos() Photos
 }
 
+// ErrPhotoNotFound is returned if no photo with the given id is on the wall
+var ErrPhotoNotFound = errors.New("photo not found")
+
+// ErrWallClosed is returned if photos are added after Shutdown
+var ErrWallClosed = errors.New("wall is shut down")
+
 // Wall represents a collection of photos, create with Create
 type Wall struct {
 	processors      []Processor
@@ -39,6 +100,16 @@ type Wall struct {
 	mutexPhotos     *sync.RWMutex
 	listenersAdd    []Observer
 	listenersRemove []Observer
+	listenersUpdate []Observer
+	listenersError  []ErrorObserver
+	listenersProc   []ProcessObserver
+	logger          *slog.Logger
+	timeout         time.Duration
+	closed          bool
+	mutexSettings   *sync.RWMutex // Guards processors, timeout and closed, which may change while photos are processed
+	jobs            *sync.WaitGroup
+	aborted         context.Context // Cancelled if Shutdown gives up waiting for jobs
+	abort           context.CancelFunc
 }
 
 // Create a new photowall
@@ -49,8 +120,14 @@ type Wall struct {
 //			NewStore("./storage"),
 // 	})
 func Create() *Wall {
+	aborted, abort := context.WithCancel(context.Background())
 	return &Wall{
-		mutexPhotos: &sync.RWMutex{},
+		mutexPhotos:   &sync.RWMutex{},
+		mutexSettings: &sync.RWMutex{},
+		jobs:          &sync.WaitGroup{},
+		aborted:       aborted,
+		abort:         abort,
+		logger:        slog.Default(),
 		processors: []Processor{
 			NewResizer(1920, 1080),
 			NewStore("./storage"),
@@ -58,64 +135,217 @@ func Create() *Wall {
 	}
 }
 
-// SetProcessors sets the list of registered processors
+// SetProcessors sets the list of registered processors.
+// It's safe to replace the processors while photos are processed,
+// running jobs finish with the processors they started with.
 func (w *Wall) SetProcessors(ps []Processor) {
+	w.mutexSettings.Lock()
 	w.processors = ps
+	w.mutexSettings.Unlock()
+}
+
+// SetLogger sets the structured logger, defaults to slog.Default()
+func (w *Wall) SetLogger(l *slog.Logger) {
+	w.logger = l
+}
+
+// SetTimeout sets the deadline for processing a single photo, zero disables it
+func (w *Wall) SetTimeout(d time.Duration) {
+	w.mutexSettings.Lock()
+	w.timeout = d
+	w.mutexSettings.Unlock()
 }
 
 // Processors returns the list of registered processors
 func (w *Wall) Processors() []Processor {
+	w.mutexSettings.RLock()
+	defer w.mutexSettings.RUnlock()
 	return w.processors
 }
 
-// AddPhotoFromFile adds a new photo to the wall
-func (w *Wall) AddPhotoFromFile(name string, createdAt time.Time) error {
+// AddPhotoFromFile adds a new photo to the wall.
+// Processing is aborted if ctx is cancelled, the request id of ctx,
+// see WithRequestID, is attached to all log records.
+func (w *Wall) AddPhotoFromFile(ctx context.Context, name string, createdAt time.Time) error {
 	p := NewPhoto(name, 0, 0, "", createdAt)
-	return w.process(p)
+	return w.process(ctx, p)
 }
 
 // AddPhoto adds a new photo to the wall
-func (w *Wall) AddPhoto(p Photo) error {
-	return w.process(p)
+func (w *Wall) AddPhoto(ctx context.Context, p Photo) error {
+	return w.process(ctx, p)
 }
 
-func (w *Wall) storePhoto(p Photo) {
-	log.Printf("Store photo: %s", p.Name())
+func (w *Wall) storePhoto(ctx context.Context, p Photo) {
+	p = publish(p)
+	loggerFor(ctx, w.logger).Info("Store photo", "id", p.ID(), "name", p.Name())
 	w.mutexPhotos.Lock()
 	w.photos = append(w.photos, p)
 	w.mutexPhotos.Unlock()
 	w.notifyAdd(p)
 }
 
-func (w *Wall) process(photo Photo) error {
-	var err error
-	for _, p := range w.processors {
-		photo, err = p.Process(photo)
+// process runs the photo through all processors. Failures are handled
+// according to the processor's ErrorPolicy, if the photo is discarded
+// all intermediate results are released by the processors' Cleanup.
+// Cancelled or timed out jobs always fail.
+func (w *Wall) process(ctx context.Context, photo Photo) error {
+	logger := loggerFor(ctx, w.logger)
+	w.mutexSettings.RLock()
+	processors, timeout, closed := w.processors, w.timeout, w.closed
+	if !closed {
+		w.jobs.Add(1)
+	}
+	w.mutexSettings.RUnlock()
+	if closed {
+		return ErrWallClosed
+	}
+	defer w.jobs.Done()
+
+	// Jobs are cancelled if Shutdown gives up waiting
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	stop := context.AfterFunc(w.aborted, cancel)
+	defer stop()
+	if timeout > 0 {
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+	type result struct {
+		cleaner Cleaner
+		photo   Photo
+	}
+	var results []result
+	cleanup := func() {
+		for i := len(results) - 1; i >= 0; i-- {
+			results[i].cleaner.Cleanup(results[i].photo)
+		}
+	}
+
+	for _, proc := range processors {
+		policy := policyOf(proc)
+		start := time.Now()
+		out, err := runWithPolicy(ctx, proc, policy, photo)
+		d := time.Since(start)
+		w.notifyProcess(proc, d, err)
 		if err != nil {
-			// TODO: handle error
-			return err
+			if ctx.Err() != nil {
+				policy.Action = Fail
+			}
+			logger.Warn("Processor failed", "processor", ProcessorName(proc), "photo", photo.Name(),
+				"action", policy.Action.String(), "duration", d, "error", err)
+			perr := &ProcessError{
+				Photo:     photo,
+				Processor: proc,
+				Action:    policy.Action,
+				Err:       err,
+			}
+			w.notifyError(photo, perr)
+			if policy.Action == Skip {
+				continue
+			}
+			if policy.Action == Quarantine {
+				if qerr := quarantine(photo, policy.QuarantineDir); qerr != nil {
+					logger.Error("Could not quarantine photo", "photo", photo.Name(), "error", qerr)
+				}
+			}
+			cleanup()
+			return perr
 		}
+		logger.Debug("Processor finished", "processor", ProcessorName(proc), "photo", out.Name(), "duration", d)
+		if c, ok := proc.(Cleaner); ok {
+			results = append(results, result{c, out})
+		}
+		photo = out
+	}
+	if err := ctx.Err(); err != nil {
+		logger.Warn("Processing aborted", "photo", photo.Name(), "error", err)
+		cleanup()
+		return err
 	}
 
-	w.storePhoto(photo)
+	w.storePhoto(ctx, photo)
 	return nil
 }
 
-// RemovePhoto removes a photo from the wall
+// Shutdown stops accepting new photos and waits for running jobs to finish.
+// If ctx expires first, the running jobs are cancelled, which discards
+// their partial results, and the ctx error is returned.
+func (w *Wall) Shutdown(ctx context.Context) error {
+	w.mutexSettings.Lock()
+	w.closed = true
+	w.mutexSettings.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		w.jobs.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		w.abort()
+		<-done
+		return ctx.Err()
+	}
+}
+
+// GetPhoto returns the photo with the given id
+func (w *Wall) GetPhoto(id string) (Photo, error) {
+	w.mutexPhotos.RLock()
+	defer w.mutexPhotos.RUnlock()
+	for _, p := range w.photos {
+		if p.ID() == id {
+			return p, nil
+		}
+	}
+	return nil, ErrPhotoNotFound
+}
+
+// RemovePhoto removes a photo from the wall, photos are identified by their id
 func (w *Wall) RemovePhoto(photo Photo) {
+	w.RemovePhotoByID(photo.ID())
+}
+
+// RemovePhotoByID removes the photo with the given id from the wall
+func (w *Wall) RemovePhotoByID(id string) error {
 	w.mutexPhotos.Lock()
-	var index = -1
+	var removed Photo
 	for i, p := range w.photos {
-		if p == photo {
-			index = i
+		if p.ID() == id {
+			removed = p
+			w.photos = append(w.photos[:i], w.photos[i+1:]...)
 			break
 		}
 	}
-	if index != -1 {
-		w.photos = append(w.photos[:index], w.photos[index+1:]...)
+	w.mutexPhotos.Unlock()
+	if removed == nil {
+		return ErrPhotoNotFound
+	}
+	w.notifyRemove(removed)
+	return nil
+}
+
+// SetStatus changes the status of the photo with the given id, e.g. to publish a pending photo
+func (w *Wall) SetStatus(id string, status Status) error {
+	w.mutexPhotos.Lock()
+	var updated Photo
+	for i, p := range w.photos {
+		if p.ID() == id {
+			info := InfoOf(p)
+			info.Status = status
+			updated = WithInfo(p, info)
+			w.photos[i] = updated
+			break
+		}
 	}
 	w.mutexPhotos.Unlock()
-	w.notifyRemove(photo)
+	if updated == nil {
+		return ErrPhotoNotFound
+	}
+	w.notifyUpdate(updated)
+	return nil
 }
 
 func (w *Wall) notifyAdd(p Photo) {
@@ -130,6 +360,24 @@ func (w *Wall) notifyRemove(p Photo) {
 	}
 }
 
+func (w *Wall) notifyUpdate(p Photo) {
+	for _, o := range w.listenersUpdate {
+		o(p)
+	}
+}
+
+func (w *Wall) notifyError(p Photo, err error) {
+	for _, o := range w.listenersError {
+		o(p, err)
+	}
+}
+
+func (w *Wall) notifyProcess(proc Processor, d time.Duration, err error) {
+	for _, o := range w.listenersProc {
+		o(proc, d, err)
+	}
+}
+
 // OnAdd registers an Observer which is called when a photo was added to the wall
 func (w *Wall) OnAdd(o Observer) {
 	w.listenersAdd = append(w.listenersAdd, o)
@@ -140,8 +388,24 @@ func (w *Wall) OnRemove(o Observer) {
 	w.listenersRemove = append(w.listenersRemove, o)
 }
 
+// OnUpdate registers an Observer which is called when the metadata of a photo changed
+func (w *Wall) OnUpdate(o Observer) {
+	w.listenersUpdate = append(w.listenersUpdate, o)
+}
+
+// OnError registers an ErrorObserver which is called when a processor failed,
+// including failures skipped by the processor's ErrorPolicy
+func (w *Wall) OnError(o ErrorObserver) {
+	w.listenersError = append(w.listenersError, o)
+}
+
+// OnProcess registers a ProcessObserver which is called after every processor run
+func (w *Wall) OnProcess(o ProcessObserver) {
+	w.listenersProc = append(w.listenersProc, o)
+}
+
 // Photos returns all photos on the wall
-func (w Wall) Photos() Photos {
+func (w *Wall) Photos() Photos {
 	w.mutexPhotos.RLock()
 	b := make([]Photo, len(w.photos))
 	copy(b, w.photos)
@@ -1,6 +1,7 @@
 package wall
 
 import (
+	"context"
 	"image"
 	"image/jpeg"
 	"io/ioutil"
@@ -54,7 +55,7 @@ func TestPhotowall(t *testing.T) {
 		t.Fatalf("Could not create test image: %s", err)
 	}
 	defer os.Remove(imgName)
-	err = w.AddPhotoFromFile(imgName, time.Now())
+	err = w.AddPhotoFromFile(context.Background(), imgName, time.Now())
 	if err != nil {
 		t.Errorf("Error adding photo: %s", err)
 	}
@@ -90,3 +91,200 @@ func TestPhotowall(t *testing.T) {
 		t.Errorf("Invalid amount of photos: %s", photos)
 	}
 }
+
+func TestProcessorName(t *testing.T) {
+	tests := map[string]Processor{
+		"resizer":  NewResizer(1, 1),
+		"store":    WithPolicy(NewStore(""), ErrorPolicy{Action: Retry}),
+		"importer": Importer(),
+	}
+	for name, p := range tests {
+		if n := ProcessorName(p); n != name {
+			t.Errorf("Wrong name: %s, expected %s", n, name)
+		}
+	}
+}
+
+func TestProcessObserver(t *testing.T) {
+	w := Create()
+	w.SetProcessors([]Processor{
+		ProcessorFunc(func(p Photo) (Photo, error) {
+			return p, nil
+		}),
+		ProcessorFunc(func(p Photo) (Photo, error) {
+			return nil, os.ErrInvalid
+		}),
+	})
+	var runs []error
+	w.OnProcess(func(proc Processor, d time.Duration, err error) {
+		runs = append(runs, err)
+	})
+	w.AddPhotoFromFile(context.Background(), "in", time.Now())
+	if len(runs) != 2 || runs[0] != nil || runs[1] != os.ErrInvalid {
+		t.Errorf("Wrong processor runs observed: %v", runs)
+	}
+}
+
+func TestPhotowallByID(t *testing.T) {
+	w := Create()
+	w.SetProcessors(nil)
+	p := NewPhoto("a", 1, 1, "jpg", time.Now())
+	if err := w.AddPhoto(context.Background(), p); err != nil {
+		t.Fatalf("Error adding photo: %s", err)
+	}
+	got, err := w.GetPhoto(p.ID())
+	if err != nil || got.ID() != p.ID() {
+		t.Fatalf("Could not get photo: %v", err)
+	}
+	if got.Status() != StatusPublished {
+		t.Errorf("Photo not published: %s", got.Status())
+	}
+	if _, err := w.GetPhoto("unknown"); err != ErrPhotoNotFound {
+		t.Errorf("Wrong error for unknown photo: %v", err)
+	}
+
+	var removed int
+	w.OnRemove(func(p Photo) {
+		removed++
+	})
+	if err := w.RemovePhotoByID("unknown"); err != ErrPhotoNotFound {
+		t.Errorf("Wrong error for unknown photo: %v", err)
+	}
+	if err := w.RemovePhotoByID(p.ID()); err != nil {
+		t.Errorf("Could not remove photo: %s", err)
+	}
+	if removed != 1 || len(w.Photos()) != 0 {
+		t.Errorf("Photo not removed")
+	}
+}
+
+func TestPhotowallSetStatus(t *testing.T) {
+	w := Create()
+	w.SetProcessors(nil)
+	p := NewPhotoWithInfo("a", 1, 1, "jpg", time.Now(), PhotoInfo{Status: StatusPending})
+	w.AddPhoto(context.Background(), p)
+	if n := len(w.Photos().WithStatus(StatusPublished)); n != 0 {
+		t.Fatalf("Pending photo published")
+	}
+	var updated Photo
+	w.OnUpdate(func(p Photo) {
+		updated = p
+	})
+	if err := w.SetStatus(p.ID(), StatusPublished); err != nil {
+		t.Fatalf("Could not set status: %s", err)
+	}
+	if updated == nil || updated.Status() != StatusPublished {
+		t.Errorf("Update observer not called")
+	}
+	if n := len(w.Photos().WithStatus(StatusPublished)); n != 1 {
+		t.Errorf("Photo not published")
+	}
+	if err := w.SetStatus("unknown", StatusPublished); err != ErrPhotoNotFound {
+		t.Errorf("Wrong error for unknown photo: %v", err)
+	}
+}
+
+func TestSetProcessorsWhileProcessing(t *testing.T) {
+	w := Create()
+	started := make(chan struct{})
+	release := make(chan struct{})
+	var old, replaced int
+	w.SetProcessors([]Processor{
+		ProcessorFunc(func(p Photo) (Photo, error) {
+			close(started)
+			<-release
+			return p, nil
+		}),
+		ProcessorFunc(func(p Photo) (Photo, error) {
+			old++
+			return p, nil
+		}),
+	})
+
+	done := make(chan error)
+	go func() {
+		done <- w.AddPhoto(context.Background(), NewPhoto("in", 0, 0, "", time.Now()))
+	}()
+	<-started
+	w.SetProcessors([]Processor{ProcessorFunc(func(p Photo) (Photo, error) {
+		replaced++
+		return p, nil
+	})})
+	close(release)
+	if err := <-done; err != nil {
+		t.Fatalf("Error processing: %s", err)
+	}
+	if old != 1 || replaced != 0 {
+		t.Errorf("Running job did not keep its processors: old %d, replaced %d", old, replaced)
+	}
+
+	if err := w.AddPhoto(context.Background(), NewPhoto("in2", 0, 0, "", time.Now())); err != nil {
+		t.Fatalf("Error processing: %s", err)
+	}
+	if replaced != 1 {
+		t.Errorf("New job did not use replaced processors")
+	}
+}
+
+func TestShutdown(t *testing.T) {
+	w := Create()
+	started := make(chan struct{})
+	release := make(chan struct{})
+	w.SetProcessors([]Processor{ProcessorFunc(func(p Photo) (Photo, error) {
+		close(started)
+		<-release
+		return p, nil
+	})})
+	done := make(chan error)
+	go func() {
+		done <- w.AddPhoto(context.Background(), NewPhoto("in", 0, 0, "", time.Now()))
+	}()
+	<-started
+
+	shutdown := make(chan error)
+	go func() {
+		shutdown <- w.Shutdown(context.Background())
+	}()
+	select {
+	case <-shutdown:
+		t.Fatal("Shutdown did not wait for running job")
+	case <-time.After(50 * time.Millisecond):
+	}
+	if err := w.AddPhoto(context.Background(), NewPhoto("late", 0, 0, "", time.Now())); err != ErrWallClosed {
+		t.Errorf("Photo accepted after shutdown: %v", err)
+	}
+	close(release)
+	if err := <-done; err != nil {
+		t.Errorf("Running job failed: %s", err)
+	}
+	if err := <-shutdown; err != nil {
+		t.Errorf("Error shutting down: %s", err)
+	}
+	if len(w.Photos()) != 1 {
+		t.Errorf("Running job not finished")
+	}
+}
+
+func TestShutdownTimeout(t *testing.T) {
+	w := Create()
+	started := make(chan struct{})
+	w.SetProcessors([]Processor{ContextProcessorFunc(func(ctx context.Context, p Photo) (Photo, error) {
+		close(started)
+		<-ctx.Done()
+		return nil, ctx.Err()
+	})})
+	done := make(chan error)
+	go func() {
+		done <- w.AddPhoto(context.Background(), NewPhoto("in", 0, 0, "", time.Now()))
+	}()
+	<-started
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := w.Shutdown(ctx); err != context.DeadlineExceeded {
+		t.Errorf("Wrong shutdown error: %v", err)
+	}
+	if err := <-done; err == nil {
+		t.Errorf("Running job not cancelled")
+	}
+}
@@ -0,0 +1,180 @@
+package wall
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// ErrorAction defines how the pipeline reacts if a processor fails
+type ErrorAction int
+
+const (
+	// Fail aborts processing of the photo
+	Fail ErrorAction = iota
+	// Skip ignores the failing processor and passes its input to the next one
+	Skip
+	// Retry runs the processor again after a backoff and fails after the last attempt
+	Retry
+	// Quarantine aborts processing and moves the input file to a quarantine directory
+	Quarantine
+)
+
+func (a ErrorAction) String() string {
+	switch a {
+	case Fail:
+		return "fail"
+	case Skip:
+		return "skip"
+	case Retry:
+		return "retry"
+	case Quarantine:
+		return "quarantine"
+	}
+	return fmt.Sprintf("ErrorAction(%d)", int(a))
+}
+
+// ParseErrorAction parses "fail", "skip", "retry" or "quarantine"
+func ParseErrorAction(s string) (ErrorAction, error) {
+	for _, a := range []ErrorAction{Fail, Skip, Retry, Quarantine} {
+		if strings.ToLower(s) == a.String() {
+			return a, nil
+		}
+	}
+	return Fail, fmt.Errorf("unknown error action: %s", s)
+}
+
+// ErrorPolicy configures how errors of a processor are handled, the zero value fails
+type ErrorPolicy struct {
+	Action        ErrorAction
+	Retries       int           // Additional attempts if Action is Retry
+	Backoff       time.Duration // Delay before the first retry, doubled for every further attempt
+	QuarantineDir string        // Directory failed inputs are moved to if Action is Quarantine
+}
+
+// PolicyProcessor is a Processor with its own error policy, see WithPolicy
+type PolicyProcessor interface {
+	Processor
+	ErrorPolicy() ErrorPolicy
+}
+
+// Cleaner is implemented by processors which leave resources like temporary files behind
+type Cleaner interface {
+	// Cleanup releases the resources of a photo returned by Process,
+	// it's called if a later processor fails.
+	Cleanup(p Photo)
+}
+
+type policyProcessor struct {
+	Processor
+	policy ErrorPolicy
+}
+
+// WithPolicy attaches an error policy to a processor
+func WithPolicy(p Processor, policy ErrorPolicy) Processor {
+	return policyProcessor{
+		Processor: p,
+		policy:    policy,
+	}
+}
+
+func (p policyProcessor) ErrorPolicy() ErrorPolicy {
+	return p.policy
+}
+
+func (p policyProcessor) ProcessContext(ctx context.Context, photo Photo) (Photo, error) {
+	return WithContext(p.Processor).ProcessContext(ctx, photo)
+}
+
+func (p policyProcessor) Cleanup(photo Photo) {
+	if c, ok := p.Processor.(Cleaner); ok {
+		c.Cleanup(photo)
+	}
+}
+
+func policyOf(p Processor) ErrorPolicy {
+	if pp, ok := p.(PolicyProcessor); ok {
+		return pp.ErrorPolicy()
+	}
+	return ErrorPolicy{}
+}
+
+// ProcessError describes the failure of a processor inside the pipeline
+type ProcessError struct {
+	Photo     Photo // Input of the failing processor
+	Processor Processor
+	Action    ErrorAction // Action taken according to the processor's policy
+	Err       error
+}
+
+func (e *ProcessError) Error() string {
+	return fmt.Sprintf("processor %s failed (%s): %s", ProcessorName(e.Processor), e.Action, e.Err)
+}
+
+// Unwrap returns the error of the processor
+func (e *ProcessError) Unwrap() error {
+	return e.Err
+}
+
+// runWithPolicy runs the processor, retrying it if the policy says so.
+// Duplicates are not retried. Cancellation of ctx aborts retries.
+func runWithPolicy(ctx context.Context, proc Processor, policy ErrorPolicy, p Photo) (Photo, error) {
+	cp := WithContext(proc)
+	out, err := cp.ProcessContext(ctx, p)
+	if err == nil || policy.Action != Retry || errors.Is(err, ErrDuplicate) {
+		return out, err
+	}
+	backoff := policy.Backoff
+	for i := 0; i < policy.Retries && err != nil; i++ {
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+		out, err = cp.ProcessContext(ctx, p)
+	}
+	return out, err
+}
+
+// quarantine moves the file of the photo into dir
+func quarantine(p Photo, dir string) error {
+	if dir == "" {
+		return fmt.Errorf("no quarantine directory set")
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	return moveFile(p.Name(), filepath.Join(dir, filepath.Base(p.Name())))
+}
+
+// moveFile renames src to dst, copying the file if both are on different filesystems
+func moveFile(src, dst string) error {
+	if err := os.Rename(src, dst); err == nil {
+		return nil
+	}
+	fin, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer fin.Close()
+	fout, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	if _, err = io.Copy(fout, fin); err != nil {
+		fout.Close()
+		os.Remove(dst)
+		return err
+	}
+	if err = fout.Close(); err != nil {
+		os.Remove(dst)
+		return err
+	}
+	return os.Remove(src)
+}
@@ -0,0 +1,148 @@
+package wall
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+type cleanupProcessor struct {
+	cleaned []Photo
+}
+
+func (c *cleanupProcessor) Process(p Photo) (Photo, error) {
+	return NewPhoto(p.Name()+".out", 0, 0, "", p.CreatedAt()), nil
+}
+
+func (c *cleanupProcessor) Cleanup(p Photo) {
+	c.cleaned = append(c.cleaned, p)
+}
+
+func failingProcessor(err error) Processor {
+	return ProcessorFunc(func(p Photo) (Photo, error) {
+		return nil, err
+	})
+}
+
+func TestProcessFailCleanup(t *testing.T) {
+	w := Create()
+	cleaner := &cleanupProcessor{}
+	procErr := errors.New("failed")
+	w.SetProcessors([]Processor{
+		WithPolicy(cleaner, ErrorPolicy{}),
+		failingProcessor(procErr),
+	})
+	var observed error
+	w.OnError(func(p Photo, err error) {
+		observed = err
+	})
+
+	err := w.AddPhotoFromFile(context.Background(), "in", time.Now())
+	if !errors.Is(err, procErr) {
+		t.Fatalf("Wrong error: %v", err)
+	}
+	var perr *ProcessError
+	if !errors.As(observed, &perr) || perr.Action != Fail || perr.Photo.Name() != "in.out" {
+		t.Errorf("Wrong observed error: %v", observed)
+	}
+	if len(cleaner.cleaned) != 1 || cleaner.cleaned[0].Name() != "in.out" {
+		t.Errorf("Processor result was not cleaned up: %v", cleaner.cleaned)
+	}
+	if len(w.Photos()) != 0 {
+		t.Errorf("Failed photo was added to the wall")
+	}
+}
+
+func TestProcessSkip(t *testing.T) {
+	w := Create()
+	var failures int
+	w.SetProcessors([]Processor{
+		WithPolicy(failingProcessor(os.ErrInvalid), ErrorPolicy{Action: Skip}),
+	})
+	w.OnError(func(p Photo, err error) {
+		failures++
+	})
+	if err := w.AddPhotoFromFile(context.Background(), "in", time.Now()); err != nil {
+		t.Fatalf("Skipped processor returned error: %s", err)
+	}
+	if failures != 1 {
+		t.Errorf("Error observer called %d times", failures)
+	}
+	if photos := w.Photos(); len(photos) != 1 || photos[0].Name() != "in" {
+		t.Errorf("Wrong photos: %v", photos)
+	}
+}
+
+func TestProcessRetry(t *testing.T) {
+	w := Create()
+	var calls int
+	w.SetProcessors([]Processor{
+		WithPolicy(ProcessorFunc(func(p Photo) (Photo, error) {
+			calls++
+			if calls < 3 {
+				return nil, os.ErrInvalid
+			}
+			return p, nil
+		}), ErrorPolicy{Action: Retry, Retries: 2, Backoff: time.Millisecond}),
+	})
+	if err := w.AddPhotoFromFile(context.Background(), "in", time.Now()); err != nil {
+		t.Fatalf("Retried processor returned error: %s", err)
+	}
+	if calls != 3 {
+		t.Errorf("Processor called %d times", calls)
+	}
+
+	calls = -10
+	if err := w.AddPhotoFromFile(context.Background(), "in", time.Now()); err == nil {
+		t.Errorf("Retries exhausted but no error returned")
+	}
+	if calls != -7 {
+		t.Errorf("Processor called %d times", calls+10)
+	}
+
+	calls = 0
+	w.SetProcessors([]Processor{
+		WithPolicy(ProcessorFunc(func(p Photo) (Photo, error) {
+			calls++
+			return nil, ErrDuplicate
+		}), ErrorPolicy{Action: Retry, Retries: 2, Backoff: time.Millisecond}),
+	})
+	if err := w.AddPhotoFromFile(context.Background(), "in", time.Now()); !errors.Is(err, ErrDuplicate) {
+		t.Errorf("Wrong error: %v", err)
+	}
+	if calls != 1 {
+		t.Errorf("Duplicate retried, processor called %d times", calls)
+	}
+}
+
+func TestProcessQuarantine(t *testing.T) {
+	dir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatalf("Could not create tmp dir: %s", err)
+	}
+	defer os.RemoveAll(dir)
+	in, err := ioutil.TempFile("", "upload")
+	if err != nil {
+		t.Fatalf("Could not create tmp file: %s", err)
+	}
+	in.Close()
+	defer os.Remove(in.Name())
+
+	w := Create()
+	w.SetProcessors([]Processor{
+		WithPolicy(failingProcessor(os.ErrInvalid), ErrorPolicy{Action: Quarantine, QuarantineDir: dir}),
+	})
+	if err := w.AddPhotoFromFile(context.Background(), in.Name(), time.Now()); err == nil {
+		t.Fatalf("Quarantined photo returned no error")
+	}
+	if _, err := os.Stat(in.Name()); err == nil {
+		t.Errorf("Input file was not moved")
+	}
+	if _, err := os.Stat(filepath.Join(dir, filepath.Base(in.Name()))); err != nil {
+		t.Errorf("Input file not quarantined: %s", err)
+	}
+}
@@ -1,7 +1,7 @@
 package wall
 
 import (
-	"bufio"
+	"context"
 	"github.com/nfnt/resize"
 	"image"
 	_ "image/gif" // Support gif format
@@ -15,6 +15,7 @@ import (
 type Resizer struct {
 	MaxWidth  uint
 	MaxHeight uint
+	MaxPixels int // Images with more pixels are rejected, <= 0 disables the limit
 }
 
 // NewResizer creates a new instance of Resizer
@@ -22,42 +23,53 @@ func NewResizer(maxWidth, maxHeight uint) Resizer {
 	return Resizer{
 		MaxWidth:  maxWidth,
 		MaxHeight: maxHeight,
+		MaxPixels: DefaultMaxPixels,
 	}
 }
 
 // Process starts the resizing of the photo
 func (r Resizer) Process(p Photo) (Photo, error) {
-	file, err := os.Open(p.Name())
+	return r.ProcessContext(context.Background(), p)
+}
+
+// ProcessContext resizes the photo, cancellation is checked between decoding, resizing and encoding
+func (r Resizer) ProcessContext(ctx context.Context, p Photo) (Photo, error) {
+	// decode into image.Image, rejecting images above the pixel limit.
+	// Big images are scaled down while decoding, keeping at least twice
+	// the target size for the Lanczos filter.
+	img, cfg, _, err := decodeFileScaled(p.Name(), r.MaxPixels, func(cfg image.Config) int {
+		if cfg.Width > cfg.Height {
+			return cfg.Width / int(2*r.MaxWidth)
+		}
+		return cfg.Height / int(2*r.MaxHeight)
+	})
 	if err != nil {
 		return nil, err
 	}
-	defer file.Close()
-	imgReader := bufio.NewReader(file)
-
-	// decode jpeg into image.Image
-	img, _, err := image.Decode(imgReader)
-	if err != nil {
+	if err = ctx.Err(); err != nil {
 		return nil, err
 	}
 
 	// resize to width 1000 using Lanczos resampling
 	// and preserve aspect ratio
-	dims := img.Bounds().Size()
 	var newDimX uint
 	var newDimY uint
-	if dims.X > dims.Y {
+	if cfg.Width > cfg.Height {
 		newDimX = r.MaxWidth
 	} else {
 		newDimY = r.MaxHeight
 	}
 
 	var m image.Image
-	if uint(dims.X) > r.MaxWidth || uint(dims.Y) > r.MaxHeight {
+	if uint(cfg.Width) > r.MaxWidth || uint(cfg.Height) > r.MaxHeight {
 		m = resize.Resize(newDimX, newDimY, img, resize.Lanczos3)
 	} else {
 		// Don't resize small images
 		m = img
 	}
+	if err = ctx.Err(); err != nil {
+		return nil, err
+	}
 	out, err := ioutil.TempFile("", ".jpg")
 	if err != nil {
 		return nil, err
@@ -66,9 +78,17 @@ func (r Resizer) Process(p Photo) (Photo, error) {
 	// write new image to file
 	err = jpeg.Encode(out, m, nil)
 	if err != nil {
+		os.Remove(out.Name())
 		return nil, err
 	}
 	newdims := m.Bounds().Size()
 	os.Remove(p.Name())
-	return NewPhoto(out.Name(), newdims.X, newdims.Y, "jpg", p.CreatedAt()), nil
+	info := InfoOf(p)
+	info.Checksum, info.Size, info.MIMEType = "", 0, "image/jpeg"
+	return NewPhotoWithInfo(out.Name(), newdims.X, newdims.Y, "jpg", p.CreatedAt(), info), nil
+}
+
+// Cleanup removes the temporary file created by Process
+func (r Resizer) Cleanup(p Photo) {
+	os.Remove(p.Name())
 }
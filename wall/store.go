@@ -1,64 +1,242 @@
 package wall
 
 import (
+	"context"
 	"crypto/sha1"
 	"encoding/hex"
 	"errors"
 	"io"
 	"os"
 	"path/filepath"
+	"sync"
 )
 
+// ErrDuplicate is returned by Store if a photo with the same content is stored already
+var ErrDuplicate = errors.New("File already exists")
+
+// maxNameAttempts limits the names tried if files of the Namer exist already
+const maxNameAttempts = 1000
+
 // Store processes photos, stores them inside a given directory and checks for duplicates
 type Store struct {
-	dir    string
-	chsums map[string]struct{}
-	namer  Namer
+	dir     string
+	chsums  map[string]string   // checksum to stored filename
+	dirty   map[string]struct{} // files written since the last Sync
+	writing map[string]struct{} // files created whose metadata isn't written yet
+	mutex   sync.Mutex
+	namer   Namer
+	pixels  int // maximum pixels of photos decoded by Verify
 }
 
 // NewStore creates a new store processor
 func NewStore(directory string) *Store {
 	return &Store{
-		dir:    directory,
-		chsums: make(map[string]struct{}),
-		namer:  NewDateNamer("2006-01-02_150405"),
+		dir:     directory,
+		chsums:  make(map[string]string),
+		dirty:   make(map[string]struct{}),
+		writing: make(map[string]struct{}),
+		namer:   NewDateNamer("2006-01-02_150405"),
+		pixels:  DefaultMaxPixels,
 	}
 }
 
+// Dir returns the store directory
+func (s *Store) Dir() string {
+	return s.dir
+}
+
 // SetNamer sets the Namer for filenames
 func (s *Store) SetNamer(namer Namer) {
 	s.namer = namer
 }
 
-// Process copy the photo to the store directory and discard it if it's a dup
+// SetMaxPixels sets the maximum pixels of photos decoded by Verify, <= 0 disables the limit
+func (s *Store) SetMaxPixels(n int) {
+	s.mutex.Lock()
+	s.pixels = n
+	s.mutex.Unlock()
+}
+
+func (s *Store) maxPixels() int {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.pixels
+}
+
+// Process copy the photo to the store directory and discard it if it's a dup.
+// The metadata of the photo is written next to it.
+// The input file is removed on success only, so it can be retried or quarantined.
 func (s *Store) Process(p Photo) (Photo, error) {
-	newBaseName := s.namer.Name(p) + "." + p.Format()
+	return s.ProcessContext(context.Background(), p)
+}
+
+// ProcessContext stores the photo like Process, a cancelled copy is removed from the store
+func (s *Store) ProcessContext(ctx context.Context, p Photo) (Photo, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
 	fin, err := os.Open(p.Name())
 	if err != nil {
 		return nil, err
 	}
-	defer func() {
-		fin.Close()
-		os.Remove(fin.Name())
-	}()
+	defer fin.Close()
 
-	newName := filepath.Join(s.dir, newBaseName)
-	fout, err := os.Create(newName)
+	newName, fout, err := s.create(p)
 	if err != nil {
 		return nil, err
 	}
-	defer fout.Close()
+	defer s.finished(newName)
 	hash := sha1.New()
 	imgReader := io.TeeReader(fin, hash)
-	_, err = io.Copy(fout, imgReader)
+	written, err := io.Copy(fout, imgReader)
+	if cerr := fout.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = ctx.Err()
+	}
 	if err != nil {
+		os.Remove(newName)
 		return nil, err
 	}
 
 	chsum := hex.EncodeToString(hash.Sum(nil))
-	if _, ok := s.chsums[chsum]; ok {
-		//TODO: Remove
-		return nil, errors.New("File already exists")
+	s.mutex.Lock()
+	_, dup := s.chsums[chsum]
+	if !dup {
+		s.chsums[chsum] = newName
+	}
+	s.mutex.Unlock()
+	if dup {
+		os.Remove(newName)
+		return nil, ErrDuplicate
+	}
+	info := InfoOf(p)
+	info.Checksum = chsum
+	info.Size = written
+	stored := publish(NewPhotoWithInfo(newName, p.Bounds().Size().X, p.Bounds().Size().Y, p.Format(), p.CreatedAt(), info))
+	if err = writeMeta(stored); err != nil {
+		s.Cleanup(stored)
+		return nil, err
+	}
+	os.Remove(fin.Name())
+	s.written(newName, metaName(newName))
+	return stored, nil
+}
+
+// create creates a new file named by the Namer. Names of files stored by
+// previous runs or imported photos with the same date are not reused.
+// The file counts as being written until finished is called.
+func (s *Store) create(p Photo) (string, *os.File, error) {
+	for i := 0; ; i++ {
+		name := filepath.Join(s.dir, s.namer.Name(p)+"."+p.Format())
+		f, err := os.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+		if os.IsExist(err) && i < maxNameAttempts {
+			continue
+		}
+		if err == nil {
+			s.mutex.Lock()
+			s.writing[name] = struct{}{}
+			s.mutex.Unlock()
+		}
+		return name, f, err
+	}
+}
+
+// finished marks a file of create as written with its metadata or removed
+func (s *Store) finished(name string) {
+	s.mutex.Lock()
+	delete(s.writing, name)
+	s.mutex.Unlock()
+}
+
+// isWriting reports whether the file is created but its metadata isn't written yet
+func (s *Store) isWriting(name string) bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	_, ok := s.writing[name]
+	return ok
+}
+
+// Restore registers a photo stored by a previous run for duplicate detection,
+// e.g. after restoring it with the Importer
+func (s *Store) Restore(p Photo) {
+	if p.Checksum() == "" || filepath.Dir(p.Name()) != filepath.Clean(s.dir) {
+		return
+	}
+	s.mutex.Lock()
+	s.chsums[p.Checksum()] = p.Name()
+	s.mutex.Unlock()
+}
+
+// Cleanup removes a stored photo if a later processor failed
+func (s *Store) Cleanup(p Photo) {
+	s.Remove(p)
+}
+
+// Remove deletes a stored photo and its metadata, it can be registered as Observer for removed photos
+func (s *Store) Remove(p Photo) {
+	s.mutex.Lock()
+	for chsum, name := range s.chsums {
+		if name == p.Name() {
+			delete(s.chsums, chsum)
+		}
+	}
+	s.mutex.Unlock()
+	if filepath.Dir(p.Name()) != filepath.Clean(s.dir) {
+		return
+	}
+	os.Remove(p.Name())
+	removeMeta(p.Name())
+	s.mutex.Lock()
+	delete(s.dirty, p.Name())
+	delete(s.dirty, metaName(p.Name()))
+	s.mutex.Unlock()
+}
+
+// Save rewrites the metadata of a stored photo, it can be registered as Observer for updated photos
+func (s *Store) Save(p Photo) {
+	if filepath.Dir(p.Name()) != filepath.Clean(s.dir) {
+		return
+	}
+	if writeMeta(p) == nil {
+		s.written(metaName(p.Name()))
+	}
+}
+
+func (s *Store) written(names ...string) {
+	s.mutex.Lock()
+	for _, name := range names {
+		s.dirty[name] = struct{}{}
+	}
+	s.mutex.Unlock()
+}
+
+// Sync flushes all photos and metadata written since the last Sync
+// and the store directory to disk, e.g. before shutting down
+func (s *Store) Sync() error {
+	s.mutex.Lock()
+	dirty := s.dirty
+	s.dirty = make(map[string]struct{})
+	s.mutex.Unlock()
+
+	var errs []error
+	for name := range dirty {
+		if err := syncFile(name); err != nil && !os.IsNotExist(err) {
+			errs = append(errs, err)
+		}
+	}
+	if err := syncFile(s.dir); err != nil {
+		errs = append(errs, err)
+	}
+	return errors.Join(errs...)
+}
+
+func syncFile(name string) error {
+	f, err := os.Open(name)
+	if err != nil {
+		return err
 	}
-	return NewPhoto(newName, p.Bounds().Size().X, p.Bounds().Size().Y, p.Format(), p.CreatedAt()), nil
+	defer f.Close()
+	return f.Sync()
 }
@@ -70,3 +70,98 @@ func TestStore(t *testing.T) {
 		t.Errorf("Input photo file was not removed")
 	}
 }
+
+func TestStoreDuplicate(t *testing.T) {
+	dirName, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatalf("Could not create tmp dir: %s", err)
+	}
+	defer os.RemoveAll(dirName)
+	s := NewStore(dirName)
+
+	for i := 0; i < 2; i++ {
+		pName, err := createStoreTestImg()
+		if err != nil {
+			t.Fatalf("Could not test image: %s", err)
+		}
+		defer os.Remove(pName)
+		_, err = s.Process(NewPhoto(pName, 0, 0, "jpg", time.Now()))
+		if i == 0 && err != nil {
+			t.Fatalf("Error while processing: %s", err)
+		}
+		if i == 1 && err != ErrDuplicate {
+			t.Errorf("Duplicate was not detected: %v", err)
+		}
+	}
+
+	files, err := ioutil.ReadDir(dirName)
+	if err != nil {
+		t.Fatalf("Could not read store: %s", err)
+	}
+	// photo and its metadata
+	if len(files) != 2 {
+		t.Errorf("Duplicate was not removed from store: %d files", len(files))
+	}
+}
+
+func TestStoreRestore(t *testing.T) {
+	dirName, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatalf("Could not create tmp dir: %s", err)
+	}
+	defer os.RemoveAll(dirName)
+	pName, err := createStoreTestImg()
+	if err != nil {
+		t.Fatalf("Could not test image: %s", err)
+	}
+	created := time.Now()
+	stored, err := NewStore(dirName).Process(NewPhoto(pName, 0, 0, "jpg", created))
+	if err != nil {
+		t.Fatalf("Error while processing: %s", err)
+	}
+
+	// Next run, the same date results in the same name
+	restored, err := Importer().Process(NewPhoto(stored.Name(), 0, 0, "", time.Now()))
+	if err != nil {
+		t.Fatalf("Error while restoring: %s", err)
+	}
+	s := NewStore(dirName)
+	s.Restore(restored)
+	pName, err = createStoreTestImg()
+	if err != nil {
+		t.Fatalf("Could not test image: %s", err)
+	}
+	defer os.Remove(pName)
+	if _, err = s.Process(NewPhoto(pName, 0, 0, "jpg", created)); err != ErrDuplicate {
+		t.Errorf("Duplicate of restored photo was not detected: %v", err)
+	}
+	if _, err = os.Stat(stored.Name()); err != nil {
+		t.Errorf("Restored photo was removed: %s", err)
+	}
+}
+
+func TestStoreSync(t *testing.T) {
+	dirName, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatalf("Could not create tmp dir: %s", err)
+	}
+	defer os.RemoveAll(dirName)
+	s := NewStore(dirName)
+	pName, err := createStoreTestImg()
+	if err != nil {
+		t.Fatalf("Could not test image: %s", err)
+	}
+	defer os.Remove(pName)
+	if _, err = s.Process(NewPhoto(pName, 0, 0, "jpg", time.Now())); err != nil {
+		t.Fatalf("Error while processing: %s", err)
+	}
+	if len(s.dirty) != 2 {
+		t.Errorf("Written files not tracked: %v", s.dirty)
+	}
+	if err = s.Sync(); err != nil {
+		t.Errorf("Error syncing: %s", err)
+	}
+	if len(s.dirty) != 0 {
+		t.Errorf("Synced files still tracked: %v", s.dirty)
+	}
+}
@@ -0,0 +1,279 @@
+package wall
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// ProblemKind classifies the problems found by Verify
+type ProblemKind string
+
+// Problems found by Verify
+const (
+	ProblemMissing      ProblemKind = "missing"      // Metadata without photo file
+	ProblemCorrupt      ProblemKind = "corrupt"      // Photo file not matching its metadata or not readable
+	ProblemUnreferenced ProblemKind = "unreferenced" // Photo file without metadata or original without photo
+)
+
+// Problem is an inconsistency of the store found by Verify
+type Problem struct {
+	Kind   ProblemKind `json:"kind"`
+	File   string      `json:"file"`
+	ID     string      `json:"id,omitempty"`
+	Detail string      `json:"detail,omitempty"`
+	Repair string      `json:"repair,omitempty"` // Action taken by Repair
+}
+
+// Verify checks the photos of the store directory against their metadata,
+// recomputing the checksums. Photos without checksum are decoded instead,
+// up to the pixels set by SetMaxPixels.
+// Originals without photo are reported if originals is set. Photos still
+// processed are skipped, for their originals Originals.Added has to be
+// registered as Observer for added photos.
+func (s *Store) Verify(originals *Originals) ([]Problem, error) {
+	metas, err := filepath.Glob(filepath.Join(s.dir, "*.json"))
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(metas)
+	maxPixels := s.maxPixels()
+	var problems []Problem
+	ids := make(map[string]bool)
+	for _, meta := range metas {
+		b, err := ioutil.ReadFile(meta)
+		if err != nil {
+			return problems, err
+		}
+		var m photoMeta
+		if err = json.Unmarshal(b, &m); err != nil {
+			problems = append(problems, Problem{Kind: ProblemCorrupt, File: meta, Detail: "invalid metadata: " + err.Error()})
+			continue
+		}
+		ids[m.ID] = true
+		name := strings.TrimSuffix(meta, ".json") + "." + m.Format
+		if _, err = os.Stat(name); err != nil {
+			problems = append(problems, Problem{Kind: ProblemMissing, File: name, ID: m.ID, Detail: err.Error()})
+			continue
+		}
+		if m.Checksum == "" {
+			if _, _, err = decodeFile(name, maxPixels); err != nil {
+				problems = append(problems, Problem{Kind: ProblemCorrupt, File: name, ID: m.ID, Detail: err.Error()})
+			}
+			continue
+		}
+		chsum, size, err := checksumFile(name)
+		if err != nil {
+			problems = append(problems, Problem{Kind: ProblemCorrupt, File: name, ID: m.ID, Detail: err.Error()})
+		} else if chsum != m.Checksum || (m.Size > 0 && size != m.Size) {
+			problems = append(problems, Problem{Kind: ProblemCorrupt, File: name, ID: m.ID, Detail: "checksum mismatch"})
+		}
+	}
+
+	files, err := ioutil.ReadDir(s.dir)
+	if err != nil {
+		return problems, err
+	}
+	for _, f := range files {
+		name := filepath.Join(s.dir, f.Name())
+		if !f.Mode().IsRegular() || !isImportFile(f.Name()) || s.isWriting(name) {
+			continue
+		}
+		// Files being stored when the directory was read are finished now,
+		// with metadata or removed again
+		if _, err = os.Stat(metaName(name)); os.IsNotExist(err) {
+			if _, err = os.Stat(name); err == nil {
+				problems = append(problems, Problem{Kind: ProblemUnreferenced, File: name, Detail: "no metadata"})
+			}
+		}
+	}
+	if originals == nil {
+		return problems, nil
+	}
+	kept, err := originals.Files()
+	if err != nil {
+		return problems, err
+	}
+	var unreferenced []string
+	for _, name := range kept {
+		id := originalID(name)
+		if !ids[id] && !originals.isWriting(id) {
+			unreferenced = append(unreferenced, name)
+		}
+	}
+	if len(unreferenced) > 0 {
+		// Photos finished after reading the metadata have it now
+		if ids, err = s.storedIDs(); err != nil {
+			return problems, err
+		}
+	}
+	for _, name := range unreferenced {
+		id := originalID(name)
+		if _, err = os.Stat(name); !ids[id] && err == nil {
+			problems = append(problems, Problem{Kind: ProblemUnreferenced, File: name, ID: id, Detail: "original without photo"})
+		}
+	}
+	return problems, nil
+}
+
+// storedIDs returns the ids of all photos with valid metadata
+func (s *Store) storedIDs() (map[string]bool, error) {
+	metas, err := filepath.Glob(filepath.Join(s.dir, "*.json"))
+	if err != nil {
+		return nil, err
+	}
+	ids := make(map[string]bool)
+	for _, meta := range metas {
+		var m photoMeta
+		if b, err := ioutil.ReadFile(meta); err == nil && json.Unmarshal(b, &m) == nil {
+			ids[m.ID] = true
+		}
+	}
+	return ids, nil
+}
+
+func originalID(name string) string {
+	return strings.TrimSuffix(filepath.Base(name), filepath.Ext(name))
+}
+
+// Repair fixes the problems found by Verify using the processors of w:
+// missing and corrupt photos are regenerated from their originals, dangling metadata
+// is removed, unreferenced photos and originals are imported. Corrupt photos without
+// original are hidden by setting them pending. The problems are returned with the repairs.
+func (s *Store) Repair(ctx context.Context, w Photowall, originals *Originals, problems []Problem) []Problem {
+	repaired := make([]Problem, 0, len(problems))
+	for _, pr := range problems {
+		pr.Repair = s.repair(ctx, w, originals, pr)
+		repaired = append(repaired, pr)
+	}
+	return repaired
+}
+
+func (s *Store) repair(ctx context.Context, w Photowall, originals *Originals, pr Problem) string {
+	if pr.Kind == ProblemUnreferenced {
+		if filepath.Dir(pr.File) == filepath.Clean(s.dir) {
+			return s.reimport(ctx, w, pr.File)
+		}
+		created, err := exifTime(pr.File)
+		if err != nil {
+			created = modTime(pr.File)
+		}
+		return repairResult("imported", reprocess(ctx, w, pr.File, created, PhotoInfo{ID: pr.ID}, true, func() {}))
+	}
+
+	if pr.ID == "" {
+		return "not repairable, invalid metadata"
+	}
+	meta, err := readMeta(pr.File)
+	if err != nil {
+		return repairResult("", err)
+	}
+	original, ok := "", false
+	if originals != nil {
+		original, ok = originals.Original(pr.ID)
+	}
+	onWall, _ := w.GetPhoto(pr.ID)
+	remove := func() {
+		if onWall != nil {
+			w.RemovePhoto(onWall)
+		} else {
+			s.Remove(meta)
+		}
+	}
+	switch {
+	case ok:
+		return repairResult("regenerated", reprocess(ctx, w, original, meta.CreatedAt(), InfoOf(meta), true, remove))
+	case pr.Kind == ProblemMissing:
+		remove()
+		return "removed"
+	case onWall != nil:
+		return repairResult("hidden (pending), no original", w.SetStatus(pr.ID, StatusPending))
+	default:
+		return "not repairable, no original"
+	}
+}
+
+// reimport processes a photo of the store without metadata again, replacing it
+func (s *Store) reimport(ctx context.Context, w Photowall, name string) string {
+	var onWall Photo
+	for _, p := range w.Photos() {
+		if p.Name() == name {
+			onWall = p
+		}
+	}
+	info := PhotoInfo{}
+	if onWall != nil {
+		info.ID = onWall.ID()
+	}
+	err := reprocess(ctx, w, name, modTime(name), info, false, func() {
+		if onWall != nil {
+			w.RemovePhoto(onWall)
+		} else {
+			s.Remove(NewPhoto(name, 0, 0, "", time.Time{}))
+		}
+	})
+	return repairResult("imported", err)
+}
+
+// reprocess runs a copy of the file src through the processors after remove was called.
+// src is restored from a backup if processing fails or if keep is set.
+func reprocess(ctx context.Context, w Photowall, src string, created time.Time, info PhotoInfo, keep bool, remove func()) error {
+	if info.ID != "" {
+		// The Importer keeps the id of photos with checksum
+		chsum, size, err := checksumFile(src)
+		if err != nil {
+			return err
+		}
+		info.Checksum, info.Size = chsum, size
+	}
+	f, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	backup, err := copyTemp(f, "repair", 0)
+	if err != nil {
+		f.Close()
+		return err
+	}
+	defer os.Remove(backup)
+	_, err = f.Seek(0, 0)
+	var tmp string
+	if err == nil {
+		tmp, err = copyTemp(f, "repair", 0)
+	}
+	f.Close()
+	if err != nil {
+		return err
+	}
+
+	remove()
+	format := importExtensions[strings.ToLower(filepath.Ext(src))]
+	if err = w.AddPhoto(ctx, NewPhotoWithInfo(tmp, 0, 0, format, created, info)); err != nil {
+		os.Remove(tmp)
+	}
+	if _, serr := os.Stat(src); os.IsNotExist(serr) && (err != nil || keep) {
+		if merr := moveFile(backup, src); err == nil {
+			err = merr
+		}
+	}
+	return err
+}
+
+func repairResult(action string, err error) string {
+	if err != nil {
+		return "failed: " + err.Error()
+	}
+	return action
+}
+
+func modTime(name string) time.Time {
+	if info, err := os.Stat(name); err == nil {
+		return info.ModTime()
+	}
+	return time.Now()
+}
@@ -0,0 +1,209 @@
+package wall
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestStoreVerify(t *testing.T) {
+	dirName, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatalf("Could not create tmp dir: %s", err)
+	}
+	defer os.RemoveAll(dirName)
+	s := NewStore(dirName)
+	var stored []Photo
+	for _, date := range []string{"2024:01:01 10:00:00", "2024:01:01 11:00:00", "2024:01:01 12:00:00"} {
+		name, err := createExifTestImg("", "verify*.jpg", date)
+		if err != nil {
+			t.Fatalf("Could not create test image: %s", err)
+		}
+		p, err := s.Process(NewPhoto(name, 0, 0, "jpg", time.Now()))
+		if err != nil {
+			t.Fatalf("Error while processing: %s", err)
+		}
+		stored = append(stored, p)
+	}
+	if problems, err := s.Verify(nil); err != nil || len(problems) != 0 {
+		t.Fatalf("Problems in intact store: %v %v", problems, err)
+	}
+
+	os.Truncate(stored[0].Name(), 100)
+	os.Remove(stored[1].Name())
+	problems, err := s.Verify(nil)
+	if err != nil {
+		t.Fatalf("Error verifying: %s", err)
+	}
+	if len(problems) != 2 {
+		t.Fatalf("Expected 2 problems, got %v", problems)
+	}
+	for i, kind := range []ProblemKind{ProblemCorrupt, ProblemMissing} {
+		if problems[i].Kind != kind || problems[i].ID != stored[i].ID() || problems[i].File != stored[i].Name() {
+			t.Errorf("Wrong problem %+v, expected %s of %s", problems[i], kind, stored[i].Name())
+		}
+	}
+}
+
+func TestStoreVerifyMaxPixels(t *testing.T) {
+	dirName, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatalf("Could not create tmp dir: %s", err)
+	}
+	defer os.RemoveAll(dirName)
+	s := NewStore(dirName)
+	name, err := createExifTestImg("", "verify*.jpg", "2024:01:01 10:00:00")
+	if err != nil {
+		t.Fatalf("Could not create test image: %s", err)
+	}
+	p, err := s.Process(NewPhoto(name, 0, 0, "jpg", time.Now()))
+	if err != nil {
+		t.Fatalf("Error while processing: %s", err)
+	}
+	// Photos stored before checksums were kept are decoded
+	var meta map[string]interface{}
+	b, _ := ioutil.ReadFile(metaName(p.Name()))
+	json.Unmarshal(b, &meta)
+	delete(meta, "checksum")
+	b, _ = json.Marshal(meta)
+	ioutil.WriteFile(metaName(p.Name()), b, 0644)
+	if problems, err := s.Verify(nil); err != nil || len(problems) != 0 {
+		t.Fatalf("Problems in intact store: %v %v", problems, err)
+	}
+
+	s.SetMaxPixels(1)
+	problems, err := s.Verify(nil)
+	if err != nil || len(problems) != 1 || problems[0].Kind != ProblemCorrupt || problems[0].File != p.Name() {
+		t.Errorf("Photo beyond the pixel limit decoded: %v %v", problems, err)
+	}
+}
+
+func TestStoreRepair(t *testing.T) {
+	dirName, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatalf("Could not create tmp dir: %s", err)
+	}
+	defer os.RemoveAll(dirName)
+	s := NewStore(dirName)
+	originals := NewOriginals(filepath.Join(dirName, "originals"))
+	w := Create()
+	w.SetProcessors([]Processor{Importer(), originals, s})
+	w.OnRemove(s.Remove)
+	w.OnRemove(originals.Remove)
+	ctx := context.Background()
+	var stored []Photo
+	for _, date := range []string{"2024:01:01 10:00:00", "2024:01:01 11:00:00", "2024:01:01 12:00:00"} {
+		name, err := createExifTestImg("", "repair*.jpg", date)
+		if err != nil {
+			t.Fatalf("Could not create test image: %s", err)
+		}
+		if err = w.AddPhotoFromFile(ctx, name, time.Now()); err != nil {
+			t.Fatalf("Error adding photo: %s", err)
+		}
+		stored = append(stored, w.Photos()[len(w.Photos())-1])
+	}
+	orphan, err := createExifTestImg(originals.dir, "orphan*.jpg", "2024:01:01 13:00:00")
+	if err != nil {
+		t.Fatalf("Could not create test image: %s", err)
+	}
+
+	os.Truncate(stored[0].Name(), 100)
+	os.Remove(stored[1].Name())
+	originals.Remove(stored[1])
+	problems, err := s.Verify(originals)
+	if err != nil {
+		t.Fatalf("Error verifying: %s", err)
+	}
+	problems = s.Repair(ctx, w, originals, problems)
+	if len(problems) != 3 {
+		t.Fatalf("Expected 3 problems, got %+v", problems)
+	}
+	for i, repair := range []string{"regenerated", "removed", "imported"} {
+		if problems[i].Repair != repair {
+			t.Errorf("Wrong repair of %+v, expected %s", problems[i], repair)
+		}
+	}
+
+	if problems, err = s.Verify(originals); err != nil || len(problems) != 0 {
+		t.Errorf("Problems after repair: %+v %v", problems, err)
+	}
+	if len(w.Photos()) != 3 {
+		t.Errorf("Expected 3 photos after repair, got %d", len(w.Photos()))
+	}
+	if _, err = w.GetPhoto(stored[0].ID()); err != nil {
+		t.Errorf("Regenerated photo lost its id: %s", err)
+	}
+	if _, err = w.GetPhoto(stored[1].ID()); err == nil {
+		t.Errorf("Missing photo without original was not removed")
+	}
+	id := filepath.Base(orphan[:len(orphan)-len(".jpg")])
+	if _, err = w.GetPhoto(id); err != nil {
+		t.Errorf("Original was not imported with its id: %s", err)
+	}
+	if _, err = os.Stat(orphan); err != nil {
+		t.Errorf("Imported original was removed: %s", err)
+	}
+}
+
+func TestStoreVerifyWhileProcessing(t *testing.T) {
+	dirName, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatalf("Could not create tmp dir: %s", err)
+	}
+	defer os.RemoveAll(dirName)
+	s := NewStore(dirName)
+	originals := NewOriginals(filepath.Join(dirName, "originals"))
+	gate := make(chan struct{})
+	w := Create()
+	w.SetProcessors([]Processor{originals, ProcessorFunc(func(p Photo) (Photo, error) {
+		<-gate
+		return p, nil
+	}), s})
+	w.OnAdd(originals.Added)
+
+	name, err := createExifTestImg("", "verify*.jpg", "2024:01:01 10:00:00")
+	if err != nil {
+		t.Fatalf("Could not create test image: %s", err)
+	}
+	defer os.Remove(name)
+	done := make(chan error)
+	p := NewPhoto(name, 0, 0, "jpg", time.Now())
+	go func() { done <- w.AddPhoto(context.Background(), p) }()
+	for i := 0; ; i++ {
+		if _, ok := originals.Original(p.ID()); ok {
+			break
+		}
+		if i == 100 {
+			t.Fatalf("Original not written")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if problems, err := s.Verify(originals); err != nil || len(problems) != 0 {
+		t.Errorf("Original of a photo being processed reported: %v %v", problems, err)
+	}
+	close(gate)
+	if err = <-done; err != nil {
+		t.Fatalf("Could not add photo: %s", err)
+	}
+	if problems, err := s.Verify(originals); err != nil || len(problems) != 0 {
+		t.Errorf("Problems after processing: %v %v", problems, err)
+	}
+
+	// Files created by the store count as written until their metadata is
+	stored, fout, err := s.create(NewPhoto(name, 0, 0, "jpg", time.Now()))
+	if err != nil {
+		t.Fatalf("Could not create file: %s", err)
+	}
+	fout.Close()
+	if problems, err := s.Verify(nil); err != nil || len(problems) != 0 {
+		t.Errorf("File being stored reported: %v %v", problems, err)
+	}
+	s.finished(stored)
+	if problems, _ := s.Verify(nil); len(problems) != 1 || problems[0].Kind != ProblemUnreferenced || problems[0].File != stored {
+		t.Errorf("Unreferenced file not reported: %v", problems)
+	}
+}
@@ -0,0 +1,170 @@
+package wall
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/fsnotify/fsnotify"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// watchQueueSize is the number of settled files waiting to be added,
+// further files stay pending until the queue has room
+const watchQueueSize = 100
+
+// Watcher adds new image files of directories to a wall, e.g. shot by a tethered camera.
+// A file is added once its size did not change for the settle time, so files still
+// being written are skipped. The files are copied, the directories are left unchanged.
+// Subdirectories and files existing before Run are ignored.
+type Watcher struct {
+	wall   Photowall
+	settle time.Duration
+	logger *slog.Logger
+	fsw    *fsnotify.Watcher
+}
+
+// pendingFile is a new file which did not settle yet
+type pendingFile struct {
+	changed time.Time
+	size    int64
+}
+
+// NewWatcher creates a Watcher for dirs, files are added settle after their last change
+func NewWatcher(w Photowall, dirs []string, settle time.Duration) (*Watcher, error) {
+	fsw, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, err
+	}
+	for _, dir := range dirs {
+		if err = fsw.Add(dir); err != nil {
+			fsw.Close()
+			return nil, fmt.Errorf("watch %s: %w", dir, err)
+		}
+	}
+	return &Watcher{
+		wall:   w,
+		settle: settle,
+		logger: slog.Default(),
+		fsw:    fsw,
+	}, nil
+}
+
+// SetLogger sets the structured logger, defaults to slog.Default()
+func (w *Watcher) SetLogger(l *slog.Logger) {
+	w.logger = l
+}
+
+// Run adds new files one by one until ctx is cancelled. The photo being processed
+// is finished, it's aborted by Shutdown of the wall.
+func (w *Watcher) Run(ctx context.Context) error {
+	defer w.fsw.Close()
+	queue := make(chan string, watchQueueSize)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for name := range queue {
+			if ctx.Err() == nil {
+				w.add(context.WithoutCancel(ctx), name)
+			}
+		}
+	}()
+	defer func() {
+		close(queue)
+		<-done
+	}()
+
+	tick := w.settle / 4
+	if tick < 10*time.Millisecond {
+		tick = 10 * time.Millisecond
+	}
+	ticker := time.NewTicker(tick)
+	defer ticker.Stop()
+	pending := make(map[string]pendingFile)
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case ev, ok := <-w.fsw.Events:
+			if !ok {
+				return nil
+			}
+			if ev.Has(fsnotify.Remove) || ev.Has(fsnotify.Rename) {
+				delete(pending, ev.Name)
+				continue
+			}
+			if !ev.Has(fsnotify.Create) && !ev.Has(fsnotify.Write) || !isImportFile(filepath.ToSlash(ev.Name)) {
+				continue
+			}
+			info, err := os.Stat(ev.Name)
+			if err != nil || !info.Mode().IsRegular() {
+				continue
+			}
+			pending[ev.Name] = pendingFile{changed: time.Now(), size: info.Size()}
+		case err, ok := <-w.fsw.Errors:
+			if !ok {
+				return nil
+			}
+			w.logger.Error("Watching directories failed", "error", err)
+		case now := <-ticker.C:
+			for name, f := range pending {
+				if now.Sub(f.changed) < w.settle {
+					continue
+				}
+				info, err := os.Stat(name)
+				if err != nil {
+					delete(pending, name)
+					continue
+				}
+				if info.Size() != f.size {
+					// Written without events, e.g. on network filesystems
+					pending[name] = pendingFile{changed: now, size: info.Size()}
+					continue
+				}
+				select {
+				case queue <- name:
+					delete(pending, name)
+				default:
+				}
+			}
+		}
+	}
+}
+
+// add copies the file and adds it with its EXIF time, or else its modification time
+func (w *Watcher) add(ctx context.Context, name string) {
+	f, err := os.Open(name)
+	if err != nil {
+		w.logger.Error("Could not read watched file", "file", name, "error", err)
+		return
+	}
+	info, err := f.Stat()
+	var tmp string
+	if err == nil {
+		tmp, err = copyTemp(f, "watch", 0)
+	}
+	f.Close()
+	if err != nil {
+		w.logger.Error("Could not read watched file", "file", name, "error", err)
+		return
+	}
+	created, err := exifTime(tmp)
+	if err != nil {
+		created = info.ModTime()
+	}
+	err = w.wall.AddPhotoFromFile(ctx, tmp, created)
+	if err != nil {
+		// Failed photos are not consumed by the processors
+		os.Remove(tmp)
+	}
+	switch {
+	case err == nil:
+		w.logger.Info("Added watched file", "file", name, "created_at", created)
+	case errors.Is(err, ErrDuplicate):
+		w.logger.Info("Skipped watched file, duplicate", "file", name)
+	default:
+		w.logger.Error("Could not add watched file", "file", name, "error", err)
+	}
+}
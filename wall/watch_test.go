@@ -0,0 +1,80 @@
+package wall
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestWatcher(t *testing.T) {
+	dir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatalf("Could not create tmp dir: %s", err)
+	}
+	defer os.RemoveAll(dir)
+	src, err := createExifTestImg("", "exif*.jpg", "2024:05:06 07:08:09")
+	if err != nil {
+		t.Fatalf("Could not create test image: %s", err)
+	}
+	defer os.Remove(src)
+	img, err := ioutil.ReadFile(src)
+	if err != nil {
+		t.Fatalf("Could not read test image: %s", err)
+	}
+
+	w := Create()
+	w.SetProcessors([]Processor{Importer()})
+	var failures int
+	w.OnError(func(p Photo, err error) {
+		failures++
+	})
+	watcher, err := NewWatcher(w, []string{dir}, 100*time.Millisecond)
+	if err != nil {
+		t.Fatalf("Could not watch: %s", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	stopped := make(chan error)
+	go func() {
+		stopped <- watcher.Run(ctx)
+	}()
+
+	// Written in two parts, an incomplete jpeg fails to import
+	name := filepath.Join(dir, "shot.jpg")
+	f, err := os.Create(name)
+	if err != nil {
+		t.Fatalf("Could not create file: %s", err)
+	}
+	f.Write(img[:len(img)/2])
+	time.Sleep(50 * time.Millisecond)
+	f.Write(img[len(img)/2:])
+	f.Close()
+	ioutil.WriteFile(filepath.Join(dir, "notes.txt"), []byte("no photo"), 0644)
+
+	deadline := time.Now().Add(5 * time.Second)
+	for len(w.Photos()) == 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	time.Sleep(200 * time.Millisecond)
+	cancel()
+	if err = <-stopped; err != nil {
+		t.Errorf("Run returned error: %s", err)
+	}
+
+	if failures != 0 {
+		t.Errorf("Incomplete file was added, %d failures", failures)
+	}
+	photos := w.Photos()
+	if len(photos) != 1 {
+		t.Fatalf("Expected one photo, got %d", len(photos))
+	}
+	defer os.Remove(photos[0].Name())
+	if want := time.Date(2024, 5, 6, 7, 8, 9, 0, time.Local); !photos[0].CreatedAt().Equal(want) {
+		t.Errorf("Wrong creation time %s, expected EXIF time %s", photos[0].CreatedAt(), want)
+	}
+	if _, err = os.Stat(name); err != nil {
+		t.Errorf("Watched file was removed: %s", err)
+	}
+}
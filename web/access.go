@@ -0,0 +1,302 @@
+package web
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// qrTokenLabel is the label of the token embedded in the QR code, it's created on demand
+const qrTokenLabel = "qr"
+
+// pinUploader is recorded as uploader of photos uploaded with the event pin
+const pinUploader = "pin"
+
+// AccessMode defines what guests need to upload photos
+type AccessMode string
+
+const (
+	// AccessOpen allows everybody to upload
+	AccessOpen AccessMode = "open"
+	// AccessPIN requires the event pin
+	AccessPIN AccessMode = "pin"
+	// AccessToken requires a valid invite token
+	AccessToken AccessMode = "token"
+)
+
+// ParseAccessMode parses "open", "pin" or "token"
+func ParseAccessMode(s string) (AccessMode, error) {
+	switch m := AccessMode(strings.ToLower(s)); m {
+	case AccessOpen, AccessPIN, AccessToken:
+		return m, nil
+	}
+	return AccessOpen, fmt.Errorf("unknown access mode: %s", s)
+}
+
+// ErrTokenNotFound is returned if no token with the given id exists
+var ErrTokenNotFound = errors.New("token not found")
+
+// Token is an invite granting guests permission to upload.
+// Guests get the secret by the invite link, the id is recorded at their photos.
+type Token struct {
+	ID        string    `json:"id"`
+	Secret    string    `json:"secret"`
+	Label     string    `json:"label"`
+	CreatedAt time.Time `json:"created_at"`
+	Revoked   bool      `json:"revoked"`
+}
+
+// Tokens is the list of invite tokens, persisted as JSON file.
+// The zero value keeps the tokens in memory only.
+type Tokens struct {
+	path   string
+	tokens []Token
+	mutex  sync.RWMutex
+}
+
+// LoadTokens reads the tokens from path, a missing file is created on the first change
+func LoadTokens(path string) (*Tokens, error) {
+	t := &Tokens{path: path}
+	b, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return t, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err = json.Unmarshal(b, &t.tokens); err != nil {
+		return nil, fmt.Errorf("tokens file %s: %w", path, err)
+	}
+	return t, nil
+}
+
+// save writes the tokens atomically, the caller holds the write lock
+func (t *Tokens) save() error {
+	if t.path == "" {
+		return nil
+	}
+	b, err := json.MarshalIndent(t.tokens, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp, err := ioutil.TempFile(filepath.Dir(t.path), ".tokens")
+	if err != nil {
+		return err
+	}
+	_, err = tmp.Write(b)
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Chmod(tmp.Name(), 0600)
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), t.path)
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+	}
+	return err
+}
+
+func randomString(n int) string {
+	b := make([]byte, n)
+	rand.Read(b)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// Create adds a new token
+func (t *Tokens) Create(label string) (Token, error) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	return t.create(label)
+}
+
+func (t *Tokens) create(label string) (Token, error) {
+	token := Token{
+		ID:        newRequestID(),
+		Secret:    randomString(12),
+		Label:     label,
+		CreatedAt: time.Now(),
+	}
+	t.tokens = append(t.tokens, token)
+	if err := t.save(); err != nil {
+		t.tokens = t.tokens[:len(t.tokens)-1]
+		return Token{}, err
+	}
+	return token, nil
+}
+
+// Revoke disables the token with the given id, photos uploaded with it are kept
+func (t *Tokens) Revoke(id string) error {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	for i := range t.tokens {
+		if t.tokens[i].ID == id {
+			if t.tokens[i].Revoked {
+				return nil
+			}
+			t.tokens[i].Revoked = true
+			if err := t.save(); err != nil {
+				t.tokens[i].Revoked = false
+				return err
+			}
+			return nil
+		}
+	}
+	return ErrTokenNotFound
+}
+
+// List returns all tokens including revoked ones
+func (t *Tokens) List() []Token {
+	t.mutex.RLock()
+	defer t.mutex.RUnlock()
+	return append([]Token(nil), t.tokens...)
+}
+
+// Check returns the valid token with the given secret
+func (t *Tokens) Check(secret string) (Token, bool) {
+	t.mutex.RLock()
+	defer t.mutex.RUnlock()
+	for _, token := range t.tokens {
+		if !token.Revoked && subtle.ConstantTimeCompare([]byte(token.Secret), []byte(secret)) == 1 {
+			return token, true
+		}
+	}
+	return Token{}, false
+}
+
+// QR returns the token shown in the QR code on the walls, a new one is created if it was revoked
+func (t *Tokens) QR() (Token, error) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	for _, token := range t.tokens {
+		if token.Label == qrTokenLabel && !token.Revoked {
+			return token, nil
+		}
+	}
+	return t.create(qrTokenLabel)
+}
+
+// SetAccess sets what guests need to upload photos, pin is required by AccessPIN
+func (s *Server) SetAccess(mode AccessMode, pin string) {
+	s.mutexSettings.Lock()
+	s.access = mode
+	s.pin = pin
+	s.mutexSettings.Unlock()
+}
+
+// SetTokens sets the invite tokens, required by AccessToken
+func (s *Server) SetTokens(tokens *Tokens) {
+	s.tokens = tokens
+}
+
+// checkCode checks the access code of a guest and returns the uploader to record at the photo
+func (s *Server) checkCode(code string) (string, bool) {
+	s.mutexSettings.RLock()
+	mode, pin := s.access, s.pin
+	s.mutexSettings.RUnlock()
+	switch mode {
+	case AccessPIN:
+		return pinUploader, code != "" && subtle.ConstantTimeCompare([]byte(code), []byte(pin)) == 1
+	case AccessToken:
+		token, ok := s.tokens.Check(code)
+		return token.ID, ok
+	}
+	return "", true
+}
+
+// inviteCode returns the code embedded in the QR code, empty if uploads are open
+func (s *Server) inviteCode() (string, error) {
+	s.mutexSettings.RLock()
+	mode, pin := s.access, s.pin
+	s.mutexSettings.RUnlock()
+	switch mode {
+	case AccessPIN:
+		return pin, nil
+	case AccessToken:
+		token, err := s.tokens.QR()
+		return token.Secret, err
+	}
+	return "", nil
+}
+
+// handleAccess tells the upload page whether guests need a code
+func (s *Server) handleAccess(c *gin.Context) {
+	s.mutexSettings.RLock()
+	mode := s.access
+	s.mutexSettings.RUnlock()
+	c.JSON(http.StatusOK, gin.H{"code_required": mode != AccessOpen})
+}
+
+type exportToken struct {
+	Token
+	Link    string   `json:"link"`
+	Uploads []string `json:"uploads"` // Ids of photos uploaded with the token
+}
+
+// handleAdminTokens lists all tokens with their invite links and uploaded photos
+func (s *Server) handleAdminTokens(c *gin.Context) {
+	uploads := make(map[string][]string)
+	for _, p := range s.wall.Photos() {
+		if p.Uploader() != "" {
+			uploads[p.Uploader()] = append(uploads[p.Uploader()], p.ID())
+		}
+	}
+	export := []exportToken{}
+	for _, token := range s.tokens.List() {
+		export = append(export, exportToken{
+			Token:   token,
+			Link:    s.baseURL(c) + "?code=" + token.Secret,
+			Uploads: append([]string{}, uploads[token.ID]...),
+		})
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"tokens":      export,
+		"pin_uploads": append([]string{}, uploads[pinUploader]...),
+	})
+}
+
+// handleAdminCreateToken creates a token, the label is taken from the form or query
+func (s *Server) handleAdminCreateToken(c *gin.Context) {
+	label := sanitizeText(c.Request.FormValue("label"), maxAuthorLength)
+	token, err := s.tokens.Create(label)
+	if err != nil {
+		s.requestLogger(c).Error("Could not create token", "error", err)
+		http.Error(c.Writer, "could not create token", http.StatusInternalServerError)
+		return
+	}
+	s.requestLogger(c).Info("Token created", "token", token.ID, "label", token.Label)
+	c.JSON(http.StatusCreated, exportToken{
+		Token:   token,
+		Link:    s.baseURL(c) + "?code=" + token.Secret,
+		Uploads: []string{},
+	})
+}
+
+// handleAdminRevokeToken revokes a token, uploads with it are rejected from now on
+func (s *Server) handleAdminRevokeToken(c *gin.Context) {
+	err := s.tokens.Revoke(c.Param("id"))
+	if err == ErrTokenNotFound {
+		http.Error(c.Writer, err.Error(), http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		s.requestLogger(c).Error("Could not revoke token", "error", err)
+		http.Error(c.Writer, "could not revoke token", http.StatusInternalServerError)
+		return
+	}
+	s.requestLogger(c).Info("Token revoked", "token", c.Param("id"))
+	c.Status(http.StatusNoContent)
+}
@@ -0,0 +1,148 @@
+package web
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestUploadPIN(t *testing.T) {
+	w := newTestWall()
+	s := newTestServer(t, w)
+	if _, ok := uploaded(t, w, serve(s, newUploadRequest(nil))); !ok {
+		t.Errorf("Upload without code rejected in open mode")
+	}
+
+	s.SetAccess(AccessPIN, "1234")
+	for _, code := range []string{"", "123", "12345"} {
+		if _, ok := uploaded(t, w, serve(s, newUploadRequest(map[string]string{"code": code}))); ok {
+			t.Errorf("Upload with code %q accepted", code)
+		}
+	}
+	p, ok := uploaded(t, w, serve(s, newUploadRequest(map[string]string{"code": "1234"})))
+	if !ok {
+		t.Fatalf("Upload with pin rejected")
+	}
+	if p.Uploader() != pinUploader {
+		t.Errorf("Wrong uploader %s", p.Uploader())
+	}
+
+	rec := serve(s, httptest.NewRequest(http.MethodGet, "/api/v1/access", nil))
+	if !strings.Contains(rec.Body.String(), `"code_required":true`) {
+		t.Errorf("Wrong access response %s", rec.Body.String())
+	}
+}
+
+func TestUploadTokens(t *testing.T) {
+	w := newTestWall()
+	s := newTestServer(t, w)
+	s.SetAccess(AccessToken, "")
+	s.SetAdmins([]Admin{{Name: "owner", Role: RoleOwner}, {Name: "mod", Role: RoleModerator}}, time.Hour)
+	cookie, csrf := newTestSession(s, "owner")
+	admin := func(method, target string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, target, nil)
+		req.AddCookie(cookie)
+		req.Header.Set(csrfHeader, csrf)
+		return serve(s, req)
+	}
+
+	rec := admin(http.MethodPost, "/api/admin/tokens?label=table+1")
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("Could not create token: %d %s", rec.Code, rec.Body.String())
+	}
+	var token exportToken
+	json.Unmarshal(rec.Body.Bytes(), &token)
+	if token.Label != "table 1" || token.Secret == "" || token.Link != "http://example.com/?code="+token.Secret {
+		t.Errorf("Wrong token %+v", token)
+	}
+
+	if _, ok := uploaded(t, w, serve(s, newUploadRequest(map[string]string{"code": "wrong"}))); ok {
+		t.Errorf("Upload with wrong token accepted")
+	}
+	p, ok := uploaded(t, w, serve(s, newUploadRequest(map[string]string{"code": token.Secret})))
+	if !ok {
+		t.Fatalf("Upload with token rejected")
+	}
+	if p.Uploader() != token.ID {
+		t.Errorf("Wrong uploader %s", p.Uploader())
+	}
+
+	rec = admin(http.MethodGet, "/api/admin/tokens")
+	var list struct{ Tokens []exportToken }
+	json.Unmarshal(rec.Body.Bytes(), &list)
+	if len(list.Tokens) != 1 || len(list.Tokens[0].Uploads) != 1 || list.Tokens[0].Uploads[0] != p.ID() {
+		t.Errorf("Wrong token list %s", rec.Body.String())
+	}
+
+	modCookie, modCSRF := newTestSession(s, "mod")
+	req := httptest.NewRequest(http.MethodDelete, "/api/admin/tokens/"+token.ID, nil)
+	req.AddCookie(modCookie)
+	req.Header.Set(csrfHeader, modCSRF)
+	if rec = serve(s, req); rec.Code != http.StatusForbidden {
+		t.Errorf("Moderator revoked token: %d", rec.Code)
+	}
+	if rec = admin(http.MethodDelete, "/api/admin/tokens/"+token.ID); rec.Code != http.StatusNoContent {
+		t.Fatalf("Could not revoke token: %d", rec.Code)
+	}
+	if rec = admin(http.MethodDelete, "/api/admin/tokens/unknown"); rec.Code != http.StatusNotFound {
+		t.Errorf("Expected 404 for unknown token, got %d", rec.Code)
+	}
+	if _, ok := uploaded(t, w, serve(s, newUploadRequest(map[string]string{"code": token.Secret}))); ok {
+		t.Errorf("Upload with revoked token accepted")
+	}
+	if len(w.Photos()) != 1 {
+		t.Errorf("Photos of the revoked token removed")
+	}
+}
+
+func TestTokensFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tokens")
+	if err != nil {
+		t.Fatalf("Could not create tmp dir: %s", err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "tokens.json")
+	tokens, err := LoadTokens(path)
+	if err != nil {
+		t.Fatalf("Could not load missing tokens file: %s", err)
+	}
+	kept, _ := tokens.Create("kept")
+	revoked, _ := tokens.Create("revoked")
+	if err = tokens.Revoke(revoked.ID); err != nil {
+		t.Fatalf("Could not revoke token: %s", err)
+	}
+	if err = tokens.Revoke("unknown"); err != ErrTokenNotFound {
+		t.Errorf("Wrong error for unknown token: %v", err)
+	}
+	qr, _ := tokens.QR()
+	if again, _ := tokens.QR(); again.ID != qr.ID {
+		t.Errorf("QR token not reused")
+	}
+
+	if fi, err := os.Stat(path); err != nil || fi.Mode().Perm() != 0600 {
+		t.Errorf("Wrong tokens file: %v", err)
+	}
+	tokens, err = LoadTokens(path)
+	if err != nil {
+		t.Fatalf("Could not load tokens: %s", err)
+	}
+	if len(tokens.List()) != 3 {
+		t.Errorf("Wrong number of tokens %d", len(tokens.List()))
+	}
+	if token, ok := tokens.Check(kept.Secret); !ok || token.ID != kept.ID {
+		t.Errorf("Kept token invalid")
+	}
+	if _, ok := tokens.Check(revoked.Secret); ok {
+		t.Errorf("Revoked token valid after loading")
+	}
+	tokens.Revoke(qr.ID)
+	if renewed, _ := tokens.QR(); renewed.ID == qr.ID || renewed.Label != qrTokenLabel {
+		t.Errorf("Revoked QR token not replaced")
+	}
+}
@@ -0,0 +1,134 @@
+package web
+
+import (
+	"github.com/blang/photowall/wall"
+	"github.com/gin-gonic/gin"
+	"net"
+	"net/http"
+	"sort"
+)
+
+// displayCommands are the remote control commands understood by the walls
+var displayCommands = map[string]bool{
+	"next":     true,
+	"previous": true,
+	"pause":    true,
+	"play":     true,
+	"qr":       true,
+}
+
+// forwardedHeaders are set by reverse proxies, requests carrying them come from elsewhere
+var forwardedHeaders = []string{"Forwarded", "X-Forwarded-For", "X-Real-IP"}
+
+// isLocal reports whether the request comes from the local machine.
+// The direct peer address is checked, requests forwarded by a proxy
+// running on the local machine are not local.
+func isLocal(c *gin.Context) bool {
+	for _, h := range forwardedHeaders {
+		if c.GetHeader(h) != "" {
+			return false
+		}
+	}
+	ip := net.ParseIP(c.RemoteIP())
+	return ip != nil && ip.IsLoopback()
+}
+
+// handleReload reloads the configuration
+func (s *Server) handleReload(c *gin.Context) {
+	if s.reload == nil {
+		http.Error(c.Writer, "reload not supported", http.StatusNotImplemented)
+		return
+	}
+	if err := s.reload(); err != nil {
+		s.requestLogger(c).Warn("Reload failed", "error", err)
+		http.Error(c.Writer, err.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+	s.requestLogger(c).Info("Configuration reloaded", "admin", adminName(c))
+	c.Status(http.StatusNoContent)
+}
+
+type adminPhoto struct {
+	exportPhoto
+	Uploader string `json:"uploader,omitempty"`
+	ImageURL string `json:"image_url"` // Also serves photos not published yet
+}
+
+// handleAdminPhotos lists all photos including pending ones, newest first, filtered by the status parameter
+func (s *Server) handleAdminPhotos(c *gin.Context) {
+	ps := s.wall.Photos()
+	if status := c.Query("status"); status != "" {
+		ps = ps.WithStatus(wall.Status(status))
+	}
+	sort.SliceStable(ps, func(i, j int) bool { return ps[i].CreatedAt().After(ps[j].CreatedAt()) })
+	export := []adminPhoto{}
+	for _, p := range ps {
+		export = append(export, adminPhoto{
+			exportPhoto: newExportPhoto(p),
+			Uploader:    p.Uploader(),
+			ImageURL:    "/api/admin/photos/" + p.ID() + "/image",
+		})
+	}
+	c.JSON(http.StatusOK, export)
+}
+
+// handleAdminImage serves a photo regardless of its status
+func (s *Server) handleAdminImage(c *gin.Context) {
+	p, err := s.wall.GetPhoto(c.Param("id"))
+	if err != nil {
+		c.Status(http.StatusNotFound)
+		return
+	}
+	serveImmutable(c, p.Name(), p.Checksum(), p.MIMEType())
+}
+
+// handleAdminApprove publishes a pending photo
+func (s *Server) handleAdminApprove(c *gin.Context) {
+	s.setStatus(c, wall.StatusPublished)
+}
+
+// handleAdminHide takes a photo from the walls back to moderation
+func (s *Server) handleAdminHide(c *gin.Context) {
+	s.setStatus(c, wall.StatusPending)
+}
+
+func (s *Server) setStatus(c *gin.Context, status wall.Status) {
+	err := s.wall.SetStatus(c.Param("id"), status)
+	if err == wall.ErrPhotoNotFound {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		s.requestLogger(c).Error("Could not set status", "photo", c.Param("id"), "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "could not set status"})
+		return
+	}
+	s.requestLogger(c).Info("Photo moderated", "photo", c.Param("id"), "status", status, "admin", adminName(c))
+	c.Status(http.StatusNoContent)
+}
+
+// handleAdminDeletePhoto removes a photo from the wall and the store
+func (s *Server) handleAdminDeletePhoto(c *gin.Context) {
+	if err := s.wall.RemovePhotoByID(c.Param("id")); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+	s.requestLogger(c).Info("Photo deleted", "photo", c.Param("id"), "admin", adminName(c))
+	c.Status(http.StatusNoContent)
+}
+
+// handleDisplayCommand sends a remote control command like next or pause to all walls
+func (s *Server) handleDisplayCommand(c *gin.Context) {
+	command := c.Param("command")
+	if !displayCommands[command] {
+		c.JSON(http.StatusNotFound, gin.H{"error": "unknown command " + command})
+		return
+	}
+	if err := s.events.send("control", gin.H{"command": command}); err != nil {
+		s.requestLogger(c).Error("Could not send command", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "could not send command"})
+		return
+	}
+	s.requestLogger(c).Info("Display command", "command", command, "admin", adminName(c))
+	c.Status(http.StatusNoContent)
+}
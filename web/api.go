@@ -0,0 +1,342 @@
+package web
+
+import (
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/blang/photowall/wall"
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	defaultPageLimit = 100
+	maxPageLimit     = 500
+	// changeRetention defines how long updates and removals are kept for delta syncs
+	changeRetention = 24 * time.Hour
+)
+
+type exportPhoto struct {
+	ID        string    `json:"id"`
+	Name      string    `json:"name"`
+	URL       string    `json:"url"`
+	Width     int       `json:"width"`
+	Height    int       `json:"height"`
+	Size      int64     `json:"size"`
+	MIMEType  string    `json:"mime_type"`
+	Status    string    `json:"status"`
+	Caption   string    `json:"caption,omitempty"`
+	Author    string    `json:"author,omitempty"`
+	CreatedAt time.Time `json:"created_at"` // RFC 3339
+}
+
+func newExportPhoto(p wall.Photo) exportPhoto {
+	return exportPhoto{
+		ID:        p.ID(),
+		Name:      filepath.Base(p.Name()),
+		URL:       "/imgs/" + filepath.Base(p.Name()),
+		Width:     p.Bounds().Size().X,
+		Height:    p.Bounds().Size().Y,
+		Size:      p.Size(),
+		MIMEType:  p.MIMEType(),
+		Status:    string(p.Status()),
+		Caption:   p.Caption(),
+		Author:    p.Author(),
+		CreatedAt: p.CreatedAt(),
+	}
+}
+
+func exportPhotos(ps wall.Photos) []exportPhoto {
+	export := []exportPhoto{}
+	for _, p := range ps {
+		export = append(export, newExportPhoto(p))
+	}
+	return export
+}
+
+// changeLog tracks the version of the wall and when photos were added, updated
+// or removed, so clients can sync only the delta
+type changeLog struct {
+	epoch      int64 // Distinguishes versions of different server runs
+	version    uint64
+	lastChange time.Time
+	updated    map[string]time.Time
+	removed    map[string]time.Time
+	mutex      sync.RWMutex
+}
+
+func newChangeLog(w wall.Photowall) *changeLog {
+	l := &changeLog{
+		epoch:      time.Now().UnixNano(),
+		lastChange: time.Now(),
+		updated:    make(map[string]time.Time),
+		removed:    make(map[string]time.Time),
+	}
+	// Photos are added after processing, so their creation time may be older than the last sync
+	w.OnAdd(func(p wall.Photo) {
+		l.record(p.ID(), false)
+	})
+	// Photos leaving the walls, e.g. hidden for moderation, are removed for delta syncs
+	w.OnUpdate(func(p wall.Photo) {
+		l.record(p.ID(), p.Status() != wall.StatusPublished)
+	})
+	w.OnRemove(func(p wall.Photo) {
+		l.record(p.ID(), true)
+	})
+	return l
+}
+
+// record notes a change of the photo, an update or a removal replaces the previous change
+func (l *changeLog) record(id string, removed bool) {
+	now := time.Now()
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	l.version++
+	l.lastChange = now
+	if removed {
+		l.removed[id] = now
+		delete(l.updated, id)
+	} else {
+		l.updated[id] = now
+		delete(l.removed, id)
+	}
+	for _, m := range []map[string]time.Time{l.updated, l.removed} {
+		for id, t := range m {
+			if now.Sub(t) > changeRetention {
+				delete(m, id)
+			}
+		}
+	}
+}
+
+// state returns the version and the time of the last change
+func (l *changeLog) state() (string, time.Time) {
+	l.mutex.RLock()
+	defer l.mutex.RUnlock()
+	return strconv.FormatInt(l.epoch, 36) + "." + strconv.FormatUint(l.version, 10), l.lastChange
+}
+
+// changedSince reports if the photo was added or updated after t
+func (l *changeLog) changedSince(id string, t time.Time) bool {
+	l.mutex.RLock()
+	defer l.mutex.RUnlock()
+	u, ok := l.updated[id]
+	return ok && u.After(t)
+}
+
+// removedSince returns the ids of photos removed after t
+func (l *changeLog) removedSince(t time.Time) []string {
+	l.mutex.RLock()
+	defer l.mutex.RUnlock()
+	ids := []string{}
+	for id, r := range l.removed {
+		if r.After(t) {
+			ids = append(ids, id)
+		}
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+// photoQuery holds the parameters of the photo listing
+type photoQuery struct {
+	since  time.Time // Only photos created or updated after since, zero for all
+	cursor *pageCursor
+	limit  int // Zero for all
+	desc   bool
+}
+
+// pageCursor identifies the last photo of a page, it's opaque to clients
+type pageCursor struct {
+	createdAt time.Time
+	id        string
+}
+
+func (c pageCursor) String() string {
+	return base64.RawURLEncoding.EncodeToString([]byte(strconv.FormatInt(c.createdAt.UnixNano(), 10) + ":" + c.id))
+}
+
+func parseCursor(s string) (*pageCursor, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, errors.New("invalid cursor")
+	}
+	parts := strings.SplitN(string(b), ":", 2)
+	if len(parts) != 2 {
+		return nil, errors.New("invalid cursor")
+	}
+	nsec, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil {
+		return nil, errors.New("invalid cursor")
+	}
+	return &pageCursor{createdAt: time.Unix(0, nsec), id: parts[1]}, nil
+}
+
+// parsePhotoQuery parses the listing parameters, limit applies if the limit parameter is missing
+func parsePhotoQuery(c *gin.Context, limit int) (photoQuery, error) {
+	q := photoQuery{limit: limit}
+	if since := c.Query("since"); since != "" {
+		t, err := time.Parse(time.RFC3339Nano, since)
+		if err != nil {
+			return q, fmt.Errorf("invalid since, expected RFC 3339 timestamp: %s", since)
+		}
+		q.since = t
+	}
+	if cursor := c.Query("cursor"); cursor != "" {
+		cur, err := parseCursor(cursor)
+		if err != nil {
+			return q, err
+		}
+		q.cursor = cur
+	}
+	if limit := c.Query("limit"); limit != "" {
+		n, err := strconv.Atoi(limit)
+		if err != nil || n < 1 {
+			return q, fmt.Errorf("invalid limit: %s", limit)
+		}
+		if n > maxPageLimit {
+			n = maxPageLimit
+		}
+		q.limit = n
+	}
+	switch order := c.DefaultQuery("order", "asc"); order {
+	case "asc":
+	case "desc":
+		q.desc = true
+	default:
+		return q, fmt.Errorf("invalid order, expected asc or desc: %s", order)
+	}
+	return q, nil
+}
+
+// before orders photos by creation time and id
+func before(t1 time.Time, id1 string, t2 time.Time, id2 string) bool {
+	if !t1.Equal(t2) {
+		return t1.Before(t2)
+	}
+	return id1 < id2
+}
+
+// page selects the published photos matching the query, next is nil on the last page
+func (s *Server) page(q photoQuery) (wall.Photos, *pageCursor) {
+	var ps wall.Photos
+	for _, p := range s.wall.Photos().WithStatus(wall.StatusPublished) {
+		if !q.since.IsZero() && !p.CreatedAt().After(q.since) && !s.changes.changedSince(p.ID(), q.since) {
+			continue
+		}
+		if q.cursor != nil {
+			after := before(q.cursor.createdAt, q.cursor.id, p.CreatedAt(), p.ID())
+			if after == q.desc {
+				continue
+			}
+		}
+		ps = append(ps, p)
+	}
+	sort.Slice(ps, func(i, j int) bool {
+		less := before(ps[i].CreatedAt(), ps[i].ID(), ps[j].CreatedAt(), ps[j].ID())
+		if q.desc {
+			return !less
+		}
+		return less
+	})
+	if q.limit == 0 || len(ps) <= q.limit {
+		return ps, nil
+	}
+	ps = ps[:q.limit]
+	last := ps[len(ps)-1]
+	return ps, &pageCursor{createdAt: last.CreatedAt(), id: last.ID()}
+}
+
+// notModified sets the ETag of the response, derived from the wall version and
+// the query, and answers 304 if the client already has this state
+func (s *Server) notModified(c *gin.Context, version string) bool {
+	hash := sha1.Sum([]byte(c.Request.URL.Path + "?" + c.Request.URL.RawQuery))
+	etag := fmt.Sprintf(`"%s-%s"`, version, hex.EncodeToString(hash[:8]))
+	c.Header("ETag", etag)
+	c.Header("Cache-Control", "no-cache")
+	for _, match := range strings.Split(c.GetHeader("If-None-Match"), ",") {
+		match = strings.TrimPrefix(strings.TrimSpace(match), "W/")
+		if match == etag || match == "*" {
+			c.Status(http.StatusNotModified)
+			return true
+		}
+	}
+	return false
+}
+
+// handleAPIWall returns the published photos as plain list, it accepts the same parameters as
+// handleAPIPhotos. All photos are returned unless limit is given, the walls poll it without parameters.
+func (s *Server) handleAPIWall(c *gin.Context) {
+	s.metrics.viewers.seen(c.RemoteIP())
+	q, err := parsePhotoQuery(c, 0)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	version, _ := s.changes.state()
+	if s.notModified(c, version) {
+		return
+	}
+	ps, _ := s.page(q)
+	c.JSON(http.StatusOK, exportPhotos(ps))
+}
+
+type photoPage struct {
+	Photos     []exportPhoto `json:"photos"`
+	Removed    []string      `json:"removed,omitempty"` // Photos removed after since
+	NextCursor string        `json:"next_cursor,omitempty"`
+	NextSince  time.Time     `json:"next_since"` // Time of the last change, use as since for the next sync
+}
+
+// handleAPIPhotos lists published photos.
+//
+// Parameters:
+//
+//	since:  RFC 3339 timestamp, only photos added or updated afterwards and ids of removed photos
+//	cursor: next_cursor of the previous page
+//	limit:  page size, default 100, max 500
+//	order:  asc (default) or desc by creation time
+func (s *Server) handleAPIPhotos(c *gin.Context) {
+	s.metrics.viewers.seen(c.RemoteIP())
+	q, err := parsePhotoQuery(c, defaultPageLimit)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	version, lastChange := s.changes.state()
+	if s.notModified(c, version) {
+		return
+	}
+	ps, next := s.page(q)
+	page := photoPage{
+		Photos:    exportPhotos(ps),
+		NextSince: lastChange,
+	}
+	if next != nil {
+		page.NextCursor = next.String()
+	}
+	if !q.since.IsZero() {
+		page.Removed = s.changes.removedSince(q.since)
+	}
+	c.JSON(http.StatusOK, page)
+}
+
+func (s *Server) handleAPIPhoto(c *gin.Context) {
+	p, err := s.wall.GetPhoto(c.Param("id"))
+	if err == nil && p.Status() != wall.StatusPublished {
+		err = wall.ErrPhotoNotFound
+	}
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, newExportPhoto(p))
+}
@@ -0,0 +1,127 @@
+package web
+
+import (
+	"encoding/json"
+	"github.com/blang/photowall/wall"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+)
+
+func TestAPIWallUnpaged(t *testing.T) {
+	w := newTestWall()
+	s := newTestServer(t, w)
+	photos := addTestPhotos(t, w, 150, time.Now().Add(-time.Hour))
+
+	rec := serve(s, httptest.NewRequest(http.MethodGet, "/api/wall.json", nil))
+	var list []exportPhoto
+	if err := json.Unmarshal(rec.Body.Bytes(), &list); err != nil || rec.Code != http.StatusOK {
+		t.Fatalf("Invalid response %d: %v", rec.Code, err)
+	}
+	if len(list) != 150 {
+		t.Fatalf("Expected all 150 photos, got %d", len(list))
+	}
+	if list[149].ID != photos[149].ID() {
+		t.Errorf("Newest photo missing, last is %s", list[149].ID)
+	}
+
+	rec = serve(s, httptest.NewRequest(http.MethodGet, "/api/wall.json?limit=10&order=desc", nil))
+	json.Unmarshal(rec.Body.Bytes(), &list)
+	if len(list) != 10 || list[0].ID != photos[149].ID() {
+		t.Errorf("Wrong limited list: %d photos", len(list))
+	}
+}
+
+func getPage(t *testing.T, s *Server, query url.Values) photoPage {
+	rec := serve(s, httptest.NewRequest(http.MethodGet, "/api/v1/photos?"+query.Encode(), nil))
+	var page photoPage
+	if err := json.Unmarshal(rec.Body.Bytes(), &page); err != nil || rec.Code != http.StatusOK {
+		t.Fatalf("Invalid response %d: %v", rec.Code, err)
+	}
+	return page
+}
+
+func TestAPIPhotosPaging(t *testing.T) {
+	w := newTestWall()
+	s := newTestServer(t, w)
+	photos := addTestPhotos(t, w, 150, time.Now().Add(-time.Hour))
+
+	page := getPage(t, s, nil)
+	if len(page.Photos) != defaultPageLimit || page.NextCursor == "" {
+		t.Fatalf("Wrong first page: %d photos, cursor %q", len(page.Photos), page.NextCursor)
+	}
+	page = getPage(t, s, url.Values{"cursor": {page.NextCursor}})
+	if len(page.Photos) != 50 || page.NextCursor != "" {
+		t.Fatalf("Wrong last page: %d photos, cursor %q", len(page.Photos), page.NextCursor)
+	}
+	if page.Photos[0].ID != photos[100].ID() {
+		t.Errorf("Second page starts with %s, expected %s", page.Photos[0].ID, photos[100].ID())
+	}
+
+	if rec := serve(s, httptest.NewRequest(http.MethodGet, "/api/v1/photos?limit=abc", nil)); rec.Code != http.StatusBadRequest {
+		t.Errorf("Invalid limit accepted: %d", rec.Code)
+	}
+}
+
+func TestAPIPhotosETag(t *testing.T) {
+	w := newTestWall()
+	s := newTestServer(t, w)
+	addTestPhotos(t, w, 2, time.Now().Add(-time.Hour))
+
+	rec := serve(s, httptest.NewRequest(http.MethodGet, "/api/v1/photos", nil))
+	etag := rec.Header().Get("ETag")
+	if etag == "" {
+		t.Fatalf("No ETag")
+	}
+	req := httptest.NewRequest(http.MethodGet, "/api/v1/photos", nil)
+	req.Header.Set("If-None-Match", etag)
+	if rec = serve(s, req); rec.Code != http.StatusNotModified {
+		t.Errorf("Expected 304 for unchanged wall, got %d", rec.Code)
+	}
+	other := httptest.NewRequest(http.MethodGet, "/api/v1/photos?limit=1", nil)
+	other.Header.Set("If-None-Match", etag)
+	if rec = serve(s, other); rec.Code != http.StatusOK {
+		t.Errorf("ETag of another query matched: %d", rec.Code)
+	}
+
+	addTestPhotos(t, w, 1, time.Now())
+	if rec = serve(s, req); rec.Code != http.StatusOK || rec.Header().Get("ETag") == etag {
+		t.Errorf("Changed wall not modified: %d", rec.Code)
+	}
+}
+
+func TestAPIPhotosDelta(t *testing.T) {
+	w := newTestWall()
+	s := newTestServer(t, w)
+	photos := addTestPhotos(t, w, 3, time.Now().Add(-time.Hour))
+	since := getPage(t, s, nil).NextSince.Format(time.RFC3339Nano)
+	time.Sleep(2 * time.Millisecond)
+
+	// Hidden for moderation and deleted photos are removed
+	w.SetStatus(photos[0].ID(), wall.StatusPending)
+	w.RemovePhoto(photos[1])
+	page := getPage(t, s, url.Values{"since": {since}})
+	if len(page.Photos) != 0 {
+		t.Errorf("Unchanged or hidden photos listed: %+v", page.Photos)
+	}
+	if len(page.Removed) != 2 || page.Removed[0] > page.Removed[1] {
+		t.Fatalf("Expected 2 removed photos, got %v", page.Removed)
+	}
+	for _, id := range []string{photos[0].ID(), photos[1].ID()} {
+		if page.Removed[0] != id && page.Removed[1] != id {
+			t.Errorf("%s not removed: %v", id, page.Removed)
+		}
+	}
+
+	// Published again, it's an update
+	w.SetStatus(photos[0].ID(), wall.StatusPublished)
+	page = getPage(t, s, url.Values{"since": {since}})
+	if len(page.Photos) != 1 || page.Photos[0].ID != photos[0].ID() {
+		t.Errorf("Published photo not listed: %+v", page.Photos)
+	}
+	if len(page.Removed) != 1 || page.Removed[0] != photos[1].ID() {
+		t.Errorf("Wrong removed photos: %v", page.Removed)
+	}
+}
@@ -0,0 +1,261 @@
+package web
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"golang.org/x/crypto/bcrypt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// sessionCookie holds the session id of a logged in admin
+	sessionCookie = "photowall_session"
+	// csrfHeader must carry the CSRF token of the session on changing requests,
+	// forms may send it as field csrf instead
+	csrfHeader = "X-CSRF-Token"
+	// adminKey stores the session of the request in the gin context
+	adminKey = "admin"
+)
+
+// dummyHash is compared for unknown names, so login takes the same time for them
+var dummyHash, _ = bcrypt.GenerateFromPassword([]byte("photowall"), bcrypt.DefaultCost)
+
+// Role defines what an admin may do, each role includes the permissions of the lower ones
+type Role string
+
+const (
+	// RoleDisplay may remote control the walls
+	RoleDisplay Role = "display"
+	// RoleModerator may additionally approve, hide and delete photos
+	RoleModerator Role = "moderator"
+	// RoleOwner may additionally manage invite tokens and reload the configuration
+	RoleOwner Role = "owner"
+)
+
+var roleLevels = map[Role]int{RoleDisplay: 1, RoleModerator: 2, RoleOwner: 3}
+
+// ParseRole parses "owner", "moderator" or "display"
+func ParseRole(s string) (Role, error) {
+	r := Role(strings.ToLower(s))
+	if _, ok := roleLevels[r]; !ok {
+		return "", fmt.Errorf("unknown role: %s", s)
+	}
+	return r, nil
+}
+
+// Includes reports whether r has the permissions of other
+func (r Role) Includes(other Role) bool {
+	return roleLevels[r] >= roleLevels[other]
+}
+
+// Admin is an account allowed to log in
+type Admin struct {
+	Name         string
+	PasswordHash string // bcrypt hash
+	Role         Role
+}
+
+// session is a logged in admin, the role is looked up on each request
+// so reloaded accounts apply immediately
+type session struct {
+	id      string
+	name    string
+	role    Role
+	csrf    string
+	expires time.Time
+}
+
+// sessions holds the sessions in memory, they end on restart
+type sessions struct {
+	sessions map[string]*session
+	mutex    sync.Mutex
+}
+
+func newSessions() *sessions {
+	return &sessions{sessions: make(map[string]*session)}
+}
+
+// create starts a session and drops expired ones
+func (s *sessions) create(name string, ttl time.Duration) *session {
+	now := time.Now()
+	sess := &session{
+		id:      randomString(24),
+		name:    name,
+		csrf:    randomString(24),
+		expires: now.Add(ttl),
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	for id, other := range s.sessions {
+		if now.After(other.expires) {
+			delete(s.sessions, id)
+		}
+	}
+	s.sessions[sess.id] = sess
+	return sess
+}
+
+func (s *sessions) get(id string) (*session, bool) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	sess, ok := s.sessions[id]
+	if !ok || time.Now().After(sess.expires) {
+		delete(s.sessions, id)
+		return nil, false
+	}
+	copied := *sess
+	return &copied, true
+}
+
+func (s *sessions) remove(id string) {
+	s.mutex.Lock()
+	delete(s.sessions, id)
+	s.mutex.Unlock()
+}
+
+// SetAdmins sets the accounts allowed to log in and how long sessions last.
+// Without admins, the admin API is only available from the local machine if
+// enabled by SetLocalAdmin.
+func (s *Server) SetAdmins(admins []Admin, sessionTTL time.Duration) {
+	m := make(map[string]Admin)
+	for _, a := range admins {
+		m[a.Name] = a
+	}
+	s.mutexSettings.Lock()
+	s.admins = m
+	s.sessionTTL = sessionTTL
+	s.mutexSettings.Unlock()
+}
+
+// SetLocalAdmin allows requests from the local machine to use the admin API
+// without login while no admins are set. Requests forwarded by a proxy are
+// never treated as local.
+func (s *Server) SetLocalAdmin(enabled bool) {
+	s.mutexSettings.Lock()
+	s.localAdmin = enabled
+	s.mutexSettings.Unlock()
+}
+
+// admin returns the session of the request. Without configured admins,
+// requests from the local machine get an owner session if enabled.
+func (s *Server) admin(c *gin.Context) (*session, bool) {
+	s.mutexSettings.RLock()
+	admins, local := s.admins, s.localAdmin
+	s.mutexSettings.RUnlock()
+	if len(admins) == 0 {
+		if local && isLocal(c) {
+			return &session{name: "local", role: RoleOwner, csrf: s.localCSRF}, true
+		}
+		return nil, false
+	}
+	id, err := c.Cookie(sessionCookie)
+	if err != nil {
+		return nil, false
+	}
+	sess, ok := s.sessions.get(id)
+	if !ok {
+		return nil, false
+	}
+	a, ok := admins[sess.name]
+	if !ok {
+		s.sessions.remove(id)
+		return nil, false
+	}
+	sess.role = a.Role
+	return sess, true
+}
+
+// requireRole rejects requests without a session of at least role, and
+// changing requests without the CSRF token of the session
+func (s *Server) requireRole(role Role) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		sess, ok := s.admin(c)
+		if !ok {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "login required"})
+			return
+		}
+		if !sess.role.Includes(role) {
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "role " + string(role) + " required"})
+			return
+		}
+		if !safeMethod(c.Request.Method) && !validCSRF(c, sess.csrf) {
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "invalid csrf token"})
+			return
+		}
+		c.Set(adminKey, sess)
+		c.Next()
+	}
+}
+
+func safeMethod(method string) bool {
+	return method == http.MethodGet || method == http.MethodHead || method == http.MethodOptions
+}
+
+func validCSRF(c *gin.Context, csrf string) bool {
+	token := c.GetHeader(csrfHeader)
+	if token == "" {
+		token = c.Request.FormValue("csrf")
+	}
+	return csrf != "" && subtle.ConstantTimeCompare([]byte(token), []byte(csrf)) == 1
+}
+
+// adminName returns the name of the admin of the request for logging
+func adminName(c *gin.Context) string {
+	if sess, ok := c.Get(adminKey); ok {
+		return sess.(*session).name
+	}
+	return ""
+}
+
+type exportSession struct {
+	Name string `json:"name"`
+	Role Role   `json:"role"`
+	CSRF string `json:"csrf,omitempty"`
+}
+
+// handleLogin checks name and password from the form and starts a session
+func (s *Server) handleLogin(c *gin.Context) {
+	name, password := c.Request.FormValue("name"), c.Request.FormValue("password")
+	s.mutexSettings.RLock()
+	a, ok := s.admins[name]
+	ttl := s.sessionTTL
+	s.mutexSettings.RUnlock()
+	hash := dummyHash
+	if ok {
+		hash = []byte(a.PasswordHash)
+	}
+	if err := bcrypt.CompareHashAndPassword(hash, []byte(password)); err != nil || !ok {
+		s.requestLogger(c).Warn("Login failed", "name", name)
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid name or password"})
+		return
+	}
+	sess := s.sessions.create(a.Name, ttl)
+	c.SetSameSite(http.SameSiteStrictMode)
+	c.SetCookie(sessionCookie, sess.id, int(ttl.Seconds()), "/", "", c.Request.TLS != nil, true)
+	s.requestLogger(c).Info("Login", "name", a.Name, "role", a.Role)
+	c.JSON(http.StatusOK, exportSession{Name: a.Name, Role: a.Role, CSRF: sess.csrf})
+}
+
+// handleLogout ends the session
+func (s *Server) handleLogout(c *gin.Context) {
+	if id, err := c.Cookie(sessionCookie); err == nil {
+		s.sessions.remove(id)
+	}
+	c.SetSameSite(http.SameSiteStrictMode)
+	c.SetCookie(sessionCookie, "", -1, "/", "", c.Request.TLS != nil, true)
+	c.Status(http.StatusNoContent)
+}
+
+// handleSession returns the admin of the session with its CSRF token
+func (s *Server) handleSession(c *gin.Context) {
+	sess, ok := s.admin(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "login required"})
+		return
+	}
+	c.JSON(http.StatusOK, exportSession{Name: sess.name, Role: sess.role, CSRF: sess.csrf})
+}
@@ -0,0 +1,180 @@
+package web
+
+import (
+	"encoding/json"
+	"golang.org/x/crypto/bcrypt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLogin(t *testing.T) {
+	s := newTestServer(t, newTestWall())
+	hash, _ := bcrypt.GenerateFromPassword([]byte("secret"), bcrypt.MinCost)
+	s.SetAdmins([]Admin{{Name: "anna", PasswordHash: string(hash), Role: RoleModerator}}, time.Hour)
+	login := func(name, password string) *httptest.ResponseRecorder {
+		form := url.Values{"name": {name}, "password": {password}}
+		req := httptest.NewRequest(http.MethodPost, "/api/admin/login", strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		return serve(s, req)
+	}
+
+	if rec := login("anna", "wrong"); rec.Code != http.StatusUnauthorized {
+		t.Errorf("Wrong password accepted: %d", rec.Code)
+	}
+	if rec := login("bob", "secret"); rec.Code != http.StatusUnauthorized {
+		t.Errorf("Unknown name accepted: %d", rec.Code)
+	}
+	rec := login("anna", "secret")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Could not log in: %d", rec.Code)
+	}
+	var sess exportSession
+	json.Unmarshal(rec.Body.Bytes(), &sess)
+	if sess.Name != "anna" || sess.Role != RoleModerator || sess.CSRF == "" {
+		t.Errorf("Wrong session %+v", sess)
+	}
+	cookies := rec.Result().Cookies()
+	if len(cookies) != 1 || cookies[0].Name != sessionCookie || !cookies[0].HttpOnly || cookies[0].SameSite != http.SameSiteStrictMode {
+		t.Fatalf("Wrong session cookie %v", cookies)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/api/admin/session", nil)
+	req.AddCookie(cookies[0])
+	rec = serve(s, req)
+	var current exportSession
+	json.Unmarshal(rec.Body.Bytes(), &current)
+	if rec.Code != http.StatusOK || current != sess {
+		t.Errorf("Wrong current session %d %+v", rec.Code, current)
+	}
+
+	req = httptest.NewRequest(http.MethodPost, "/api/admin/logout", nil)
+	req.AddCookie(cookies[0])
+	serve(s, req)
+	req = httptest.NewRequest(http.MethodGet, "/api/admin/session", nil)
+	req.AddCookie(cookies[0])
+	if rec = serve(s, req); rec.Code != http.StatusUnauthorized {
+		t.Errorf("Session valid after logout: %d", rec.Code)
+	}
+}
+
+func TestRoles(t *testing.T) {
+	s := newTestServer(t, newTestWall())
+	s.SetAdmins([]Admin{{Name: "display", Role: RoleDisplay}, {Name: "owner", Role: RoleOwner}}, time.Hour)
+	request := func(method, target, name string) int {
+		req := httptest.NewRequest(method, target, nil)
+		if name != "" {
+			cookie, csrf := newTestSession(s, name)
+			req.AddCookie(cookie)
+			req.Header.Set(csrfHeader, csrf)
+		}
+		return serve(s, req).Code
+	}
+
+	for _, tc := range []struct {
+		method, target, name string
+		code                 int
+	}{
+		{http.MethodPost, "/api/admin/display/next", "", http.StatusUnauthorized},
+		{http.MethodPost, "/api/admin/display/next", "display", http.StatusNoContent},
+		{http.MethodGet, "/api/admin/photos", "display", http.StatusForbidden},
+		{http.MethodGet, "/api/admin/tokens", "display", http.StatusForbidden},
+		{http.MethodGet, "/api/admin/photos", "owner", http.StatusOK},
+		{http.MethodGet, "/api/admin/tokens", "owner", http.StatusOK},
+	} {
+		if code := request(tc.method, tc.target, tc.name); code != tc.code {
+			t.Errorf("%s %s as %q: expected %d, got %d", tc.method, tc.target, tc.name, tc.code, code)
+		}
+	}
+
+	// Sessions of removed admins end, changed roles apply immediately
+	cookie, csrf := newTestSession(s, "display")
+	s.SetAdmins([]Admin{{Name: "display", Role: RoleOwner}}, time.Hour)
+	req := httptest.NewRequest(http.MethodGet, "/api/admin/tokens", nil)
+	req.AddCookie(cookie)
+	req.Header.Set(csrfHeader, csrf)
+	if rec := serve(s, req); rec.Code != http.StatusOK {
+		t.Errorf("Changed role not applied: %d", rec.Code)
+	}
+	if code := request(http.MethodGet, "/api/admin/tokens", "owner"); code != http.StatusUnauthorized {
+		t.Errorf("Removed admin still logged in: %d", code)
+	}
+}
+
+func TestCSRF(t *testing.T) {
+	s := newTestServer(t, newTestWall())
+	s.SetAdmins([]Admin{{Name: "owner", Role: RoleOwner}}, time.Hour)
+	cookie, csrf := newTestSession(s, "owner")
+	_, other := newTestSession(s, "owner")
+	for _, token := range []string{"", "wrong", other} {
+		req := httptest.NewRequest(http.MethodPost, "/api/admin/tokens", nil)
+		req.AddCookie(cookie)
+		req.Header.Set(csrfHeader, token)
+		if rec := serve(s, req); rec.Code != http.StatusForbidden {
+			t.Errorf("CSRF token %q accepted: %d", token, rec.Code)
+		}
+	}
+	if len(s.tokens.List()) != 0 {
+		t.Errorf("Token created without CSRF token")
+	}
+
+	form := url.Values{"csrf": {csrf}}
+	req := httptest.NewRequest(http.MethodPost, "/api/admin/tokens", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.AddCookie(cookie)
+	if rec := serve(s, req); rec.Code != http.StatusCreated {
+		t.Errorf("CSRF form field rejected: %d", rec.Code)
+	}
+	req = httptest.NewRequest(http.MethodGet, "/api/admin/tokens", nil)
+	req.AddCookie(cookie)
+	if rec := serve(s, req); rec.Code != http.StatusOK {
+		t.Errorf("Safe request without CSRF token rejected: %d", rec.Code)
+	}
+}
+
+func TestLocalAdmin(t *testing.T) {
+	s := newTestServer(t, newTestWall())
+	local := func(method, target, csrf string, header ...string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, target, nil)
+		req.RemoteAddr = "127.0.0.1:1234"
+		if csrf != "" {
+			req.Header.Set(csrfHeader, csrf)
+		}
+		for i := 0; i+1 < len(header); i += 2 {
+			req.Header.Set(header[i], header[i+1])
+		}
+		return serve(s, req)
+	}
+
+	if rec := local(http.MethodGet, "/api/admin/tokens", ""); rec.Code != http.StatusUnauthorized {
+		t.Errorf("Local admin not opt-in: %d", rec.Code)
+	}
+	s.SetLocalAdmin(true)
+	rec := local(http.MethodGet, "/api/admin/session", "")
+	var sess exportSession
+	json.Unmarshal(rec.Body.Bytes(), &sess)
+	if rec.Code != http.StatusOK || sess.Role != RoleOwner || sess.CSRF == "" {
+		t.Fatalf("Wrong local session %d %+v", rec.Code, sess)
+	}
+	if rec = local(http.MethodPost, "/api/admin/tokens", ""); rec.Code != http.StatusForbidden {
+		t.Errorf("Local change without CSRF token accepted: %d", rec.Code)
+	}
+	if rec = local(http.MethodPost, "/api/admin/tokens", sess.CSRF); rec.Code != http.StatusCreated {
+		t.Errorf("Local change with CSRF token rejected: %d", rec.Code)
+	}
+	for _, h := range forwardedHeaders {
+		if rec = local(http.MethodGet, "/api/admin/tokens", "", h, "198.51.100.1"); rec.Code != http.StatusUnauthorized {
+			t.Errorf("Request forwarded with %s treated as local: %d", h, rec.Code)
+		}
+	}
+	if rec = serve(s, httptest.NewRequest(http.MethodGet, "/api/admin/tokens", nil)); rec.Code != http.StatusUnauthorized {
+		t.Errorf("Remote request treated as local: %d", rec.Code)
+	}
+	s.SetAdmins([]Admin{{Name: "owner", Role: RoleOwner}}, time.Hour)
+	if rec = local(http.MethodGet, "/api/admin/tokens", ""); rec.Code != http.StatusUnauthorized {
+		t.Errorf("Local admin with configured admins: %d", rec.Code)
+	}
+}
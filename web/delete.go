@@ -0,0 +1,93 @@
+package web
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// SetDeleteWindow sets how long guests may delete their own uploads, zero disables it
+func (s *Server) SetDeleteWindow(d time.Duration) {
+	s.mutexSettings.Lock()
+	s.deleteWindow = d
+	s.mutexSettings.Unlock()
+}
+
+// deleteSignature signs the photo id and issue time with the secret of the server
+func (s *Server) deleteSignature(id string, issued int64) string {
+	mac := hmac.New(sha256.New, s.deleteSecret)
+	mac.Write([]byte(id + "." + strconv.FormatInt(issued, 10)))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil)[:18])
+}
+
+// getDeleteWindow returns how long guests may delete their uploads
+func (s *Server) getDeleteWindow() time.Duration {
+	s.mutexSettings.RLock()
+	defer s.mutexSettings.RUnlock()
+	return s.deleteWindow
+}
+
+// deleteToken returns a token allowing to delete the photo until the delete window ends,
+// empty if deleting is disabled. The token carries the issue time, so a changed
+// window applies to tokens issued already.
+func (s *Server) deleteToken(id string, now time.Time) string {
+	if s.getDeleteWindow() <= 0 {
+		return ""
+	}
+	issued := now.Unix()
+	return strconv.FormatInt(issued, 10) + "." + s.deleteSignature(id, issued)
+}
+
+// checkDeleteToken reports whether token allows to delete the photo now,
+// within the current delete window since the token was issued
+func (s *Server) checkDeleteToken(id, token string, now time.Time) bool {
+	window := s.getDeleteWindow()
+	if window <= 0 {
+		return false
+	}
+	issuedStr, signature, ok := strings.Cut(token, ".")
+	if !ok {
+		return false
+	}
+	issued, err := strconv.ParseInt(issuedStr, 10, 64)
+	if err != nil || now.Unix() > issued+int64(window/time.Second) {
+		return false
+	}
+	return hmac.Equal([]byte(signature), []byte(s.deleteSignature(id, issued)))
+}
+
+// successURL returns the success page, including the link to delete the photo if enabled
+func (s *Server) successURL(id string) string {
+	token := s.deleteToken(id, time.Now())
+	if token == "" {
+		return "/success"
+	}
+	return "/success?" + url.Values{"photo": {id}, "token": {token}}.Encode()
+}
+
+// handleGuestDelete removes a photo of the guest holding its delete token
+func (s *Server) handleGuestDelete(c *gin.Context) {
+	id := c.Param("id")
+	token := c.Query("token")
+	if token == "" {
+		token = c.Request.FormValue("token")
+	}
+	if !s.checkDeleteToken(id, token, time.Now()) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "invalid or expired delete token"})
+		return
+	}
+	p, err := s.wall.GetPhoto(id)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+	s.wall.RemovePhoto(p)
+	s.requestLogger(c).Info("Photo deleted by guest", "photo", id)
+	c.Status(http.StatusNoContent)
+}
@@ -0,0 +1,109 @@
+package web
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDeleteToken(t *testing.T) {
+	s := newTestServer(t, newTestWall())
+	now := time.Now()
+	if token := s.deleteToken("abc", now); token != "" {
+		t.Errorf("Token without delete window: %s", token)
+	}
+	s.SetDeleteWindow(15 * time.Minute)
+	token := s.deleteToken("abc", now)
+	if !s.checkDeleteToken("abc", token, now) || !s.checkDeleteToken("abc", token, now.Add(15*time.Minute)) {
+		t.Errorf("Token invalid within the delete window")
+	}
+	if s.checkDeleteToken("abc", token, now.Add(15*time.Minute+time.Second)) {
+		t.Errorf("Token valid after the delete window")
+	}
+	if s.checkDeleteToken("abd", token, now) {
+		t.Errorf("Token valid for another photo")
+	}
+	_, signature, _ := strings.Cut(token, ".")
+	extended := strconv.FormatInt(now.Add(time.Hour).Unix(), 10) + "." + signature
+	if s.checkDeleteToken("abc", extended, now.Add(30*time.Minute)) {
+		t.Errorf("Token with changed issue time valid")
+	}
+
+	// Tokens issued already follow changes of the window
+	s.SetDeleteWindow(5 * time.Minute)
+	if s.checkDeleteToken("abc", token, now.Add(10*time.Minute)) {
+		t.Errorf("Token valid after the shortened delete window")
+	}
+	if !s.checkDeleteToken("abc", token, now.Add(5*time.Minute)) {
+		t.Errorf("Token invalid within the shortened delete window")
+	}
+	s.SetDeleteWindow(0)
+	if s.checkDeleteToken("abc", token, now) {
+		t.Errorf("Token valid with deleting disabled")
+	}
+	s.SetDeleteWindow(15 * time.Minute)
+	for _, invalid := range []string{"", "abc", ".", "x." + signature} {
+		if s.checkDeleteToken("abc", invalid, now) {
+			t.Errorf("Token %q valid", invalid)
+		}
+	}
+	other := newTestServer(t, newTestWall())
+	if other.checkDeleteToken("abc", token, now) {
+		t.Errorf("Token valid for another server")
+	}
+}
+
+func TestGuestDelete(t *testing.T) {
+	w := newTestWall()
+	s := newTestServer(t, w)
+	s.SetDeleteWindow(15 * time.Minute)
+	upload := func() (string, string) {
+		rec := serve(s, newUploadRequest(nil))
+		if _, ok := uploaded(t, w, rec); !ok {
+			t.Fatalf("Upload rejected")
+		}
+		u, err := url.Parse(rec.Header().Get("Location"))
+		if err != nil || u.Path != "/success" || u.Query().Get("token") == "" {
+			t.Fatalf("Wrong success url %s", rec.Header().Get("Location"))
+		}
+		return u.Query().Get("photo"), u.Query().Get("token")
+	}
+	id, token := upload()
+	otherID, otherToken := upload()
+	remove := func(id, token string) int {
+		return serve(s, httptest.NewRequest(http.MethodDelete, "/api/v1/photos/"+id+"?token="+url.QueryEscape(token), nil)).Code
+	}
+
+	if code := remove(id, otherToken); code != http.StatusForbidden {
+		t.Errorf("Token of another photo accepted: %d", code)
+	}
+	if code := remove(id, ""); code != http.StatusForbidden {
+		t.Errorf("Missing token accepted: %d", code)
+	}
+	if code := remove(id, token); code != http.StatusNoContent {
+		t.Fatalf("Could not delete photo: %d", code)
+	}
+	if _, err := w.GetPhoto(id); err == nil {
+		t.Errorf("Photo not deleted")
+	}
+	if _, err := w.GetPhoto(otherID); err != nil {
+		t.Errorf("Other photo deleted")
+	}
+	if code := remove(id, token); code != http.StatusNotFound {
+		t.Errorf("Expected 404 for deleted photo, got %d", code)
+	}
+
+	s.SetDeleteWindow(0)
+	if code := remove(otherID, otherToken); code != http.StatusForbidden {
+		t.Errorf("Delete with deleting disabled: %d", code)
+	}
+	rec := serve(s, newUploadRequest(nil))
+	uploaded(t, w, rec)
+	if location := rec.Header().Get("Location"); location != "/success" {
+		t.Errorf("Delete link without delete window: %s", location)
+	}
+}
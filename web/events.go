@@ -0,0 +1,162 @@
+package web
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// eventKeepAlive is the interval of comments sent to idle event streams,
+// so proxies don't close the connection
+const eventKeepAlive = 30 * time.Second
+
+// DisplaySettings are pushed to all connected walls when they change
+type DisplaySettings struct {
+	SlideInterval time.Duration // Time each photo is shown
+	QRInterval    time.Duration // Time between overlays of the upload QR code, zero disables it
+	QRDuration    time.Duration // Time the QR code is shown
+	UploadURL     string        // URL shown below the QR code, defaults to the wall's host
+}
+
+// MarshalJSON encodes the settings for the wall's javascript
+func (d DisplaySettings) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		SlideInterval int64  `json:"slide_interval_ms"`
+		QRInterval    int64  `json:"qr_interval_ms"`
+		QRDuration    int64  `json:"qr_duration_ms"`
+		UploadURL     string `json:"upload_url,omitempty"`
+	}{
+		SlideInterval: d.SlideInterval.Milliseconds(),
+		QRInterval:    d.QRInterval.Milliseconds(),
+		QRDuration:    d.QRDuration.Milliseconds(),
+		UploadURL:     d.UploadURL,
+	})
+}
+
+type event struct {
+	name string
+	data []byte
+}
+
+// broadcaster distributes server sent events to all subscribed streams.
+// The latest event of each name is replayed to new subscribers,
+// so walls connecting later still get the current settings.
+type broadcaster struct {
+	clients map[chan event]struct{}
+	last    map[string]event
+	closed  bool
+	mutex   sync.Mutex
+}
+
+func newBroadcaster() *broadcaster {
+	return &broadcaster{
+		clients: make(map[chan event]struct{}),
+		last:    make(map[string]event),
+	}
+}
+
+func (b *broadcaster) subscribe() chan event {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	ch := make(chan event, len(b.last)+8)
+	if b.closed {
+		close(ch)
+		return ch
+	}
+	for _, e := range b.last {
+		ch <- e
+	}
+	b.clients[ch] = struct{}{}
+	return ch
+}
+
+func (b *broadcaster) unsubscribe(ch chan event) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	if _, ok := b.clients[ch]; ok {
+		delete(b.clients, ch)
+		close(ch)
+	}
+}
+
+// close ends all streams, new subscribers get a closed channel
+func (b *broadcaster) close() {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	b.closed = true
+	for ch := range b.clients {
+		delete(b.clients, ch)
+		close(ch)
+	}
+}
+
+// publish sends the json encoded value to all subscribers.
+// Events are dropped for clients not keeping up, they catch up on reconnect.
+func (b *broadcaster) publish(name string, v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	e := event{name: name, data: data}
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	b.last[name] = e
+	for ch := range b.clients {
+		select {
+		case ch <- e:
+		default:
+		}
+	}
+	return nil
+}
+
+// send sends the json encoded value to all subscribers without replaying it
+// to later ones, for one-off events like remote control commands
+func (b *broadcaster) send(name string, v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	e := event{name: name, data: data}
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	for ch := range b.clients {
+		select {
+		case ch <- e:
+		default:
+		}
+	}
+	return nil
+}
+
+// handleEvents streams server sent events like display settings to the walls
+func (s *Server) handleEvents(c *gin.Context) {
+	ch := s.events.subscribe()
+	defer s.events.unsubscribe(ch)
+
+	c.Header("Content-Type", "text/event-stream")
+	c.Header("Cache-Control", "no-cache")
+	c.Header("X-Accel-Buffering", "no")
+	c.Status(http.StatusOK)
+	c.Writer.Flush()
+
+	keepAlive := time.NewTicker(eventKeepAlive)
+	defer keepAlive.Stop()
+	for {
+		select {
+		case <-c.Request.Context().Done():
+			return
+		case e, ok := <-ch:
+			if !ok {
+				return
+			}
+			fmt.Fprintf(c.Writer, "event: %s\ndata: %s\n\n", e.name, e.data)
+		case <-keepAlive.C:
+			fmt.Fprint(c.Writer, ": keep-alive\n\n")
+		}
+		c.Writer.Flush()
+	}
+}
@@ -0,0 +1,273 @@
+package web
+
+import (
+	"archive/zip"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"github.com/blang/photowall/wall"
+	"github.com/gin-gonic/gin"
+	"io"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// exportEntry describes a photo of the archive in the manifest
+type exportEntry struct {
+	File      string    `json:"file"`
+	Original  string    `json:"original,omitempty"`
+	ID        string    `json:"id"`
+	Caption   string    `json:"caption,omitempty"`
+	Author    string    `json:"author,omitempty"`
+	Filename  string    `json:"filename,omitempty"` // Name of the uploaded file
+	Uploader  string    `json:"uploader,omitempty"` // Admin exports only
+	Status    string    `json:"status"`
+	Width     int       `json:"width"`
+	Height    int       `json:"height"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// ExportOptions are the parameters of WriteExport
+type ExportOptions struct {
+	Names     string // stored (default), filename or caption
+	Originals bool   // Include the originals, if kept
+	Manifest  string // json (default), csv or none
+	Status    string // Status of the exported photos, empty for all
+	Uploaders bool   // Include the uploaders in the manifest
+}
+
+// Validate checks the names and manifest options
+func (o ExportOptions) Validate() error {
+	switch o.Names {
+	case "", "stored", "filename", "caption":
+	default:
+		return fmt.Errorf("names must be stored, filename or caption")
+	}
+	switch o.Manifest {
+	case "", "json", "csv", "none":
+	default:
+		return fmt.Errorf("manifest must be json, csv or none")
+	}
+	return nil
+}
+
+// SetOriginals sets where originals are kept, so exports can include them
+func (s *Server) SetOriginals(o *wall.Originals) {
+	s.originals = o
+}
+
+// SetGuestExport allows guests to download the published photos by /api/v1/export.zip
+func (s *Server) SetGuestExport(enabled bool) {
+	s.mutexSettings.Lock()
+	s.guestExport = enabled
+	s.mutexSettings.Unlock()
+}
+
+func parseExportOptions(c *gin.Context) (ExportOptions, error) {
+	o := ExportOptions{
+		Names:    c.Query("names"),
+		Manifest: c.Query("manifest"),
+		Status:   string(wall.StatusPublished),
+	}
+	if err := o.Validate(); err != nil {
+		return o, err
+	}
+	if v := c.Query("originals"); v != "" {
+		var err error
+		if o.Originals, err = strconv.ParseBool(v); err != nil {
+			return o, fmt.Errorf("originals must be a boolean")
+		}
+	}
+	return o, nil
+}
+
+// handleAdminExport streams a ZIP of the photos, see handleExport.
+// Admins may choose the photos by status, empty for all, and get the uploaders in the manifest.
+func (s *Server) handleAdminExport(c *gin.Context) {
+	o, err := parseExportOptions(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if status, ok := c.GetQuery("status"); ok {
+		o.Status = status
+	}
+	o.Uploaders = true
+	s.export(c, o)
+}
+
+// handleExport streams a ZIP of all published photos to guests, if enabled.
+// Guests need a valid access code like for uploads.
+//
+// Parameters:
+//
+//	names:     file names in the archive, stored (default), filename (as uploaded) or caption
+//	originals: include the uploads before resizing in originals/, if kept
+//	manifest:  json (default), csv or none
+func (s *Server) handleExport(c *gin.Context) {
+	s.mutexSettings.RLock()
+	enabled := s.guestExport
+	s.mutexSettings.RUnlock()
+	if !enabled {
+		c.JSON(http.StatusNotFound, gin.H{"error": "export disabled"})
+		return
+	}
+	if _, ok := s.checkCode(c.Query("code")); !ok {
+		c.JSON(http.StatusForbidden, gin.H{"error": "invalid code"})
+		return
+	}
+	o, err := parseExportOptions(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	s.export(c, o)
+}
+
+// export writes the archive directly to the response
+func (s *Server) export(c *gin.Context, o ExportOptions) {
+	c.Header("Content-Type", "application/zip")
+	c.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="photowall-%s.zip"`, time.Now().Format("2006-01-02")))
+	c.Status(http.StatusOK)
+	n, err := WriteExport(c.Writer, s.wall.Photos(), s.originals, o)
+	if err != nil {
+		// The client sees a truncated archive
+		s.requestLogger(c).Error("Export failed", "error", err)
+		return
+	}
+	s.requestLogger(c).Info("Photos exported", "photos", n, "originals", o.Originals, "admin", adminName(c))
+}
+
+// WriteExport writes a ZIP archive of the photos with the given status, oldest first,
+// and a manifest describing them. Photos are stored without compression as they are
+// compressed already. It returns the number of exported photos.
+func WriteExport(w io.Writer, ps wall.Photos, originals *wall.Originals, o ExportOptions) (int, error) {
+	if err := o.Validate(); err != nil {
+		return 0, err
+	}
+	if o.Status != "" {
+		ps = ps.WithStatus(wall.Status(o.Status))
+	}
+	sort.SliceStable(ps, func(i, j int) bool { return ps[i].CreatedAt().Before(ps[j].CreatedAt()) })
+
+	z := zip.NewWriter(w)
+	names := make(map[string]bool)
+	var entries []exportEntry
+	for _, p := range ps {
+		entry := exportEntry{
+			ID:        p.ID(),
+			Caption:   p.Caption(),
+			Author:    p.Author(),
+			Filename:  p.Filename(),
+			Status:    string(p.Status()),
+			Width:     p.Bounds().Size().X,
+			Height:    p.Bounds().Size().Y,
+			CreatedAt: p.CreatedAt(),
+		}
+		if o.Uploaders {
+			entry.Uploader = p.Uploader()
+		}
+		base := exportName(p, o.Names)
+		entry.File = uniqueName(names, base+filepath.Ext(p.Name()))
+		if err := addFile(z, entry.File, p.Name(), p.CreatedAt()); err != nil {
+			return len(entries), fmt.Errorf("photo %s: %w", p.ID(), err)
+		}
+		if original, ok := exportOriginal(p, originals, o); ok {
+			entry.Original = uniqueName(names, path.Join("originals", base+filepath.Ext(original)))
+			if err := addFile(z, entry.Original, original, p.CreatedAt()); err != nil {
+				return len(entries), fmt.Errorf("original of photo %s: %w", p.ID(), err)
+			}
+		}
+		entries = append(entries, entry)
+	}
+	if err := writeManifest(z, o.Manifest, entries); err != nil {
+		return len(entries), err
+	}
+	return len(entries), z.Close()
+}
+
+func exportOriginal(p wall.Photo, originals *wall.Originals, o ExportOptions) (string, bool) {
+	if !o.Originals || originals == nil {
+		return "", false
+	}
+	return originals.Original(p.ID())
+}
+
+// exportName returns the file name of the photo in the archive without extension
+func exportName(p wall.Photo, names string) string {
+	stored := strings.TrimSuffix(filepath.Base(p.Name()), filepath.Ext(p.Name()))
+	var name string
+	switch names {
+	case "filename":
+		name = strings.TrimSuffix(p.Filename(), filepath.Ext(p.Filename()))
+	case "caption":
+		name = p.Caption()
+		if p.Author() != "" {
+			name += " - " + p.Author()
+		}
+	}
+	if name = safeFilename(name); name == "" {
+		return stored
+	}
+	return name
+}
+
+// uniqueName numbers names already in the archive, e.g. "party (2).jpg"
+func uniqueName(names map[string]bool, name string) string {
+	ext := path.Ext(name)
+	unique := name
+	for i := 2; names[unique]; i++ {
+		unique = fmt.Sprintf("%s (%d)%s", strings.TrimSuffix(name, ext), i, ext)
+	}
+	names[unique] = true
+	return unique
+}
+
+func addFile(z *zip.Writer, name, file string, modified time.Time) error {
+	f, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	w, err := z.CreateHeader(&zip.FileHeader{Name: name, Method: zip.Store, Modified: modified})
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(w, f)
+	return err
+}
+
+func writeManifest(z *zip.Writer, format string, entries []exportEntry) error {
+	switch format {
+	case "none":
+		return nil
+	case "":
+		format = "json"
+	}
+	w, err := z.CreateHeader(&zip.FileHeader{Name: "manifest." + format, Method: zip.Deflate, Modified: time.Now()})
+	if err != nil {
+		return err
+	}
+	if format == "json" {
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		if entries == nil {
+			entries = []exportEntry{}
+		}
+		return enc.Encode(entries)
+	}
+	cw := csv.NewWriter(w)
+	cw.Write([]string{"file", "original", "id", "caption", "author", "filename", "uploader", "status", "width", "height", "created_at"})
+	for _, e := range entries {
+		cw.Write([]string{e.File, e.Original, e.ID, e.Caption, e.Author, e.Filename, e.Uploader, e.Status,
+			strconv.Itoa(e.Width), strconv.Itoa(e.Height), e.CreatedAt.Format(time.RFC3339)})
+	}
+	cw.Flush()
+	return cw.Error()
+}
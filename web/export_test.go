@@ -0,0 +1,171 @@
+package web
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"github.com/blang/photowall/wall"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// newExportWall creates a wall with two published photos of the same caption and a pending one.
+// The first photo has an original in dir/originals.
+func newExportWall(t *testing.T, dir string) (*wall.Wall, *wall.Originals) {
+	w := newTestWall()
+	start := time.Date(2024, 6, 1, 20, 0, 0, 0, time.UTC)
+	for i, info := range []wall.PhotoInfo{
+		{ID: "p1", Caption: "Party", Author: "Anna", Filename: "IMG_1.jpg", Uploader: "token1"},
+		{ID: "p2", Caption: "Party", Author: "Anna", Filename: "IMG_2.jpg"},
+		{ID: "p3", Caption: "Hidden", Filename: "IMG_3.jpg"},
+	} {
+		name := filepath.Join(dir, info.ID+".jpg")
+		ioutil.WriteFile(name, []byte("photo "+info.ID), 0644)
+		if err := w.AddPhoto(context.Background(), wall.NewPhotoWithInfo(name, 100, 50, "jpg", start.Add(time.Duration(i)*time.Minute), info)); err != nil {
+			t.Fatalf("Could not add photo: %s", err)
+		}
+	}
+	w.SetStatus("p3", wall.StatusPending)
+	originals := wall.NewOriginals(filepath.Join(dir, "originals"))
+	os.Mkdir(filepath.Join(dir, "originals"), 0755)
+	ioutil.WriteFile(filepath.Join(dir, "originals", "p1.png"), []byte("original p1"), 0644)
+	return w, originals
+}
+
+// readZip returns the contents of the archive by file name and the names in order
+func readZip(t *testing.T, b []byte) (map[string]string, []string) {
+	z, err := zip.NewReader(bytes.NewReader(b), int64(len(b)))
+	if err != nil {
+		t.Fatalf("Could not read zip: %s", err)
+	}
+	files := make(map[string]string)
+	var names []string
+	for _, f := range z.File {
+		r, err := f.Open()
+		if err != nil {
+			t.Fatalf("Could not open %s: %s", f.Name, err)
+		}
+		content, _ := ioutil.ReadAll(r)
+		r.Close()
+		files[f.Name] = string(content)
+		names = append(names, f.Name)
+	}
+	return files, names
+}
+
+func TestWriteExport(t *testing.T) {
+	dir, err := ioutil.TempDir("", "export")
+	if err != nil {
+		t.Fatalf("Could not create tmp dir: %s", err)
+	}
+	defer os.RemoveAll(dir)
+	w, originals := newExportWall(t, dir)
+
+	var b bytes.Buffer
+	n, err := WriteExport(&b, w.Photos(), originals, ExportOptions{Names: "caption", Originals: true, Status: string(wall.StatusPublished)})
+	if err != nil || n != 2 {
+		t.Fatalf("Could not export: %d %v", n, err)
+	}
+	files, names := readZip(t, b.Bytes())
+	expected := []string{"Party - Anna.jpg", "originals/Party - Anna.png", "Party - Anna (2).jpg", "manifest.json"}
+	if len(names) != len(expected) {
+		t.Fatalf("Wrong entries %v", names)
+	}
+	for i, name := range expected {
+		if names[i] != name {
+			t.Errorf("Wrong entry %d: %s, expected %s", i, names[i], name)
+		}
+	}
+	if files["Party - Anna.jpg"] != "photo p1" || files["originals/Party - Anna.png"] != "original p1" || files["Party - Anna (2).jpg"] != "photo p2" {
+		t.Errorf("Wrong file contents %v", files)
+	}
+
+	var manifest []exportEntry
+	if err = json.Unmarshal([]byte(files["manifest.json"]), &manifest); err != nil {
+		t.Fatalf("Could not read manifest: %s", err)
+	}
+	if len(manifest) != 2 {
+		t.Fatalf("Wrong manifest %s", files["manifest.json"])
+	}
+	first := manifest[0]
+	if first.File != "Party - Anna.jpg" || first.Original != "originals/Party - Anna.png" || first.ID != "p1" || first.Filename != "IMG_1.jpg" ||
+		first.Status != "published" || first.Width != 100 || first.Height != 50 || first.Uploader != "" {
+		t.Errorf("Wrong manifest entry %+v", first)
+	}
+
+	b.Reset()
+	if _, err = WriteExport(&b, w.Photos(), nil, ExportOptions{Names: "filename", Manifest: "csv", Uploaders: true}); err != nil {
+		t.Fatalf("Could not export: %s", err)
+	}
+	files, names = readZip(t, b.Bytes())
+	if len(names) != 4 || names[0] != "IMG_1.jpg" || names[2] != "IMG_3.jpg" || names[3] != "manifest.csv" {
+		t.Errorf("Wrong entries %v", names)
+	}
+	records, err := csv.NewReader(bytes.NewReader([]byte(files["manifest.csv"]))).ReadAll()
+	if err != nil || len(records) != 4 {
+		t.Fatalf("Wrong csv manifest %q: %v", files["manifest.csv"], err)
+	}
+	if records[0][0] != "file" || records[1][0] != "IMG_1.jpg" || records[1][6] != "token1" || records[3][7] != "pending" {
+		t.Errorf("Wrong csv manifest %v", records)
+	}
+
+	b.Reset()
+	WriteExport(&b, w.Photos(), nil, ExportOptions{Manifest: "none", Status: string(wall.StatusPublished)})
+	if _, names = readZip(t, b.Bytes()); len(names) != 2 || names[0] != "p1.jpg" {
+		t.Errorf("Wrong entries without manifest %v", names)
+	}
+	if _, err = WriteExport(&b, w.Photos(), nil, ExportOptions{Names: "other"}); err == nil {
+		t.Errorf("Invalid names accepted")
+	}
+}
+
+func TestHandleExport(t *testing.T) {
+	dir, err := ioutil.TempDir("", "export")
+	if err != nil {
+		t.Fatalf("Could not create tmp dir: %s", err)
+	}
+	defer os.RemoveAll(dir)
+	w, _ := newExportWall(t, dir)
+	s := newTestServer(t, w)
+	s.SetAccess(AccessPIN, "1234")
+
+	if rec := serve(s, httptest.NewRequest(http.MethodGet, "/api/v1/export.zip?code=1234", nil)); rec.Code != http.StatusNotFound {
+		t.Errorf("Guest export not disabled: %d", rec.Code)
+	}
+	s.SetGuestExport(true)
+	if rec := serve(s, httptest.NewRequest(http.MethodGet, "/api/v1/export.zip?code=wrong", nil)); rec.Code != http.StatusForbidden {
+		t.Errorf("Wrong code accepted: %d", rec.Code)
+	}
+	if rec := serve(s, httptest.NewRequest(http.MethodGet, "/api/v1/export.zip?code=1234&manifest=xml", nil)); rec.Code != http.StatusBadRequest {
+		t.Errorf("Invalid manifest accepted: %d", rec.Code)
+	}
+	rec := serve(s, httptest.NewRequest(http.MethodGet, "/api/v1/export.zip?code=1234&status=", nil))
+	if rec.Code != http.StatusOK || rec.Header().Get("Content-Type") != "application/zip" {
+		t.Fatalf("Wrong response %d: %s", rec.Code, rec.Header().Get("Content-Type"))
+	}
+	files, names := readZip(t, rec.Body.Bytes())
+	var manifest []exportEntry
+	json.Unmarshal([]byte(files["manifest.json"]), &manifest)
+	if len(names) != 3 || len(manifest) != 2 || manifest[0].Uploader != "" {
+		t.Errorf("Wrong guest export %v %+v", names, manifest)
+	}
+
+	s.SetAdmins([]Admin{{Name: "mod", Role: RoleModerator}}, time.Hour)
+	cookie, _ := newTestSession(s, "mod")
+	req := httptest.NewRequest(http.MethodGet, "/api/admin/export.zip?status=", nil)
+	req.AddCookie(cookie)
+	rec = serve(s, req)
+	files, names = readZip(t, rec.Body.Bytes())
+	manifest = nil
+	json.Unmarshal([]byte(files["manifest.json"]), &manifest)
+	if len(names) != 4 || len(manifest) != 3 || manifest[0].Uploader != "token1" {
+		t.Errorf("Wrong admin export %v %+v", names, manifest)
+	}
+}
@@ -0,0 +1,108 @@
+package web
+
+import (
+	"github.com/blang/photowall/wall"
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// immutableCacheControl is sent for stored files, they never change once written by the Store
+const immutableCacheControl = "public, max-age=31536000, immutable"
+
+// precompressed lists file suffixes of precompressed variants by content encoding, in order of preference
+var precompressed = []struct {
+	encoding string
+	suffix   string
+}{
+	{"br", ".br"},
+	{"gzip", ".gz"},
+}
+
+// photoByFile returns the published photo stored as file name
+func (s *Server) photoByFile(name string) wall.Photo {
+	for _, p := range s.wall.Photos().WithStatus(wall.StatusPublished) {
+		if filepath.Base(p.Name()) == name {
+			return p
+		}
+	}
+	return nil
+}
+
+// handleImage serves stored photos with strong etags derived from their checksum,
+// conditional and range requests are handled by http.ServeContent
+func (s *Server) handleImage(c *gin.Context) {
+	p := s.photoByFile(c.Param("name"))
+	if p == nil {
+		c.Status(http.StatusNotFound)
+		return
+	}
+	serveImmutable(c, p.Name(), p.Checksum(), p.MIMEType())
+}
+
+// serveImmutable serves the file or a precompressed variant of it (name.br, name.gz)
+// accepted by the client. An empty checksum disables the etag.
+func serveImmutable(c *gin.Context, name, checksum, mimeType string) {
+	file, encoding, err := openVariant(name, c.GetHeader("Accept-Encoding"))
+	if err != nil {
+		c.Status(http.StatusNotFound)
+		return
+	}
+	defer file.Close()
+	stat, err := file.Stat()
+	if err != nil || stat.IsDir() {
+		c.Status(http.StatusNotFound)
+		return
+	}
+
+	h := c.Writer.Header()
+	h.Set("Cache-Control", immutableCacheControl)
+	if mimeType != "" {
+		h.Set("Content-Type", mimeType)
+	}
+	if encoding != "" {
+		h.Set("Content-Encoding", encoding)
+		h.Add("Vary", "Accept-Encoding")
+	}
+	if checksum != "" {
+		etag := checksum
+		if encoding != "" {
+			etag += "-" + encoding
+		}
+		h.Set("ETag", `"`+etag+`"`)
+	}
+	http.ServeContent(c.Writer, c.Request, filepath.Base(name), stat.ModTime(), file)
+}
+
+// openVariant opens the best precompressed variant of name accepted by the client, or name itself
+func openVariant(name, acceptEncoding string) (*os.File, string, error) {
+	for _, v := range precompressed {
+		if !acceptsEncoding(acceptEncoding, v.encoding) {
+			continue
+		}
+		if f, err := os.Open(name + v.suffix); err == nil {
+			return f, v.encoding, nil
+		}
+	}
+	f, err := os.Open(name)
+	return f, "", err
+}
+
+// acceptsEncoding checks the Accept-Encoding header for the encoding, ignoring q=0 entries
+func acceptsEncoding(header, encoding string) bool {
+	for _, part := range strings.Split(header, ",") {
+		fields := strings.Split(part, ";")
+		if strings.TrimSpace(fields[0]) != encoding {
+			continue
+		}
+		for _, param := range fields[1:] {
+			if v := strings.TrimSpace(param); v == "q=0" || v == "q=0.0" || v == "q=0.00" || v == "q=0.000" {
+				return false
+			}
+		}
+		return true
+	}
+	return false
+}
@@ -0,0 +1,77 @@
+package web
+
+import (
+	"context"
+	"github.com/blang/photowall/wall"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestHandleImage(t *testing.T) {
+	dir, err := ioutil.TempDir("", "imgs")
+	if err != nil {
+		t.Fatalf("Could not create tmp dir: %s", err)
+	}
+	defer os.RemoveAll(dir)
+	name := filepath.Join(dir, "photo.jpg")
+	ioutil.WriteFile(name, []byte("0123456789"), 0644)
+	ioutil.WriteFile(name+".gz", []byte("gzipped"), 0644)
+	w := newTestWall()
+	s := newTestServer(t, w)
+	w.AddPhoto(context.Background(), wall.NewPhotoWithInfo(name, 10, 10, "jpg", time.Now(), wall.PhotoInfo{Checksum: "abc123", MIMEType: "image/jpeg"}))
+
+	rec := serve(s, httptest.NewRequest(http.MethodGet, "/imgs/photo.jpg", nil))
+	if rec.Code != http.StatusOK || rec.Body.String() != "0123456789" {
+		t.Fatalf("Wrong response %d: %q", rec.Code, rec.Body.String())
+	}
+	if etag := rec.Header().Get("ETag"); etag != `"abc123"` {
+		t.Errorf("Wrong ETag %s", etag)
+	}
+	if cc := rec.Header().Get("Cache-Control"); cc != immutableCacheControl {
+		t.Errorf("Wrong Cache-Control %s", cc)
+	}
+	if ct := rec.Header().Get("Content-Type"); ct != "image/jpeg" {
+		t.Errorf("Wrong Content-Type %s", ct)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/imgs/photo.jpg", nil)
+	req.Header.Set("If-None-Match", `"abc123"`)
+	if rec = serve(s, req); rec.Code != http.StatusNotModified {
+		t.Errorf("Expected 304, got %d", rec.Code)
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "/imgs/photo.jpg", nil)
+	req.Header.Set("Range", "bytes=2-5")
+	rec = serve(s, req)
+	if rec.Code != http.StatusPartialContent || rec.Body.String() != "2345" {
+		t.Errorf("Wrong range response %d: %q", rec.Code, rec.Body.String())
+	}
+	if cr := rec.Header().Get("Content-Range"); cr != "bytes 2-5/10" {
+		t.Errorf("Wrong Content-Range %s", cr)
+	}
+	req.Header.Set("If-Range", `"other"`)
+	if rec = serve(s, req); rec.Code != http.StatusOK {
+		t.Errorf("Range of a changed file served: %d", rec.Code)
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "/imgs/photo.jpg", nil)
+	req.Header.Set("Accept-Encoding", "br;q=0, gzip")
+	rec = serve(s, req)
+	if rec.Body.String() != "gzipped" || rec.Header().Get("Content-Encoding") != "gzip" || rec.Header().Get("ETag") != `"abc123-gzip"` {
+		t.Errorf("Precompressed variant not served: %q %v", rec.Body.String(), rec.Header())
+	}
+
+	if rec = serve(s, httptest.NewRequest(http.MethodGet, "/imgs/other.jpg", nil)); rec.Code != http.StatusNotFound {
+		t.Errorf("Unknown file served: %d", rec.Code)
+	}
+	p := w.Photos()[0]
+	w.SetStatus(p.ID(), wall.StatusPending)
+	if rec = serve(s, httptest.NewRequest(http.MethodGet, "/imgs/photo.jpg", nil)); rec.Code != http.StatusNotFound {
+		t.Errorf("Pending photo served: %d", rec.Code)
+	}
+}
@@ -0,0 +1,66 @@
+package web
+
+import (
+	"encoding/json"
+	"github.com/blang/photowall/wall"
+	"github.com/gin-gonic/gin"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+)
+
+// handleAdminImport imports the photos of a ZIP archive uploaded as form file archive,
+// or of a directory on the server given by the form field dir, through the processors.
+// Progress is streamed as one JSON object per line, a result per file followed by the summary.
+func (s *Server) handleAdminImport(c *gin.Context) {
+	source := c.Request.FormValue("dir")
+	if source == "" {
+		file, _, err := c.Request.FormFile("archive")
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "archive or dir required"})
+			return
+		}
+		defer file.Close()
+		tmp, err := ioutil.TempFile("", "import*.zip")
+		if err != nil {
+			s.requestLogger(c).Error("Could not create file", "error", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "could not store archive"})
+			return
+		}
+		defer os.Remove(tmp.Name())
+		_, err = io.Copy(tmp, file)
+		if cerr := tmp.Close(); err == nil {
+			err = cerr
+		}
+		if err != nil {
+			s.requestLogger(c).Error("Could not store archive", "error", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "could not store archive"})
+			return
+		}
+		source = tmp.Name()
+	} else if info, err := os.Stat(source); err != nil || !info.IsDir() {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "dir is not a directory on the server"})
+		return
+	}
+
+	logger := s.requestLogger(c)
+	logger.Info("Import started", "source", source, "admin", adminName(c))
+	c.Header("Content-Type", "application/x-ndjson")
+	c.Status(http.StatusOK)
+	enc := json.NewEncoder(c.Writer)
+	summary, err := wall.ImportFiles(c.Request.Context(), s.wall, source, s.getMaxSize(), func(r wall.ImportResult) {
+		if r.Error != "" {
+			logger.Warn("Import failed", "file", r.File, "error", r.Error)
+		}
+		enc.Encode(r)
+		c.Writer.Flush()
+	})
+	if err != nil {
+		logger.Error("Import aborted", "error", err)
+		enc.Encode(gin.H{"error": err.Error(), "summary": summary})
+		return
+	}
+	logger.Info("Import finished", "total", summary.Total, "added", summary.Added, "duplicates", summary.Duplicates, "failed", summary.Failed)
+	enc.Encode(gin.H{"summary": summary})
+}
@@ -0,0 +1,100 @@
+package web
+
+import (
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"github.com/blang/photowall/wall"
+	"image"
+	"image/jpeg"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// newImportRequest uploads a ZIP archive of the files to the import endpoint
+func newImportRequest(files map[string][]byte) *http.Request {
+	var archive bytes.Buffer
+	z := zip.NewWriter(&archive)
+	for name, b := range files {
+		f, _ := z.Create(name)
+		f.Write(b)
+	}
+	z.Close()
+	var body bytes.Buffer
+	form := multipart.NewWriter(&body)
+	part, _ := form.CreateFormFile("archive", "photos.zip")
+	part.Write(archive.Bytes())
+	form.Close()
+	req := httptest.NewRequest(http.MethodPost, "/api/admin/import", &body)
+	req.Header.Set("Content-Type", form.FormDataContentType())
+	return req
+}
+
+func encodeTestJPEG(width, height int) []byte {
+	var b bytes.Buffer
+	jpeg.Encode(&b, image.NewRGBA(image.Rect(0, 0, width, height)), nil)
+	return b.Bytes()
+}
+
+func TestHandleImport(t *testing.T) {
+	w := newTestWall()
+	s := newTestServer(t, w)
+	w.SetProcessors([]wall.Processor{wall.NewStore(s.storageDir)})
+	s.SetAdmins([]Admin{{Name: "olga", Role: RoleOwner}, {Name: "mia", Role: RoleModerator}}, time.Hour)
+	small, large := encodeTestJPEG(100, 100), encodeTestJPEG(400, 400)
+	s.SetMaxSize(int64(len(small)))
+	files := map[string][]byte{"a.jpg": small, "b.jpg": small, "large.jpg": large, "notes.txt": []byte("no image")}
+
+	if rec := serve(s, newImportRequest(files)); rec.Code != http.StatusUnauthorized {
+		t.Errorf("Import without session: %d", rec.Code)
+	}
+	cookie, csrf := newTestSession(s, "mia")
+	req := newImportRequest(files)
+	req.AddCookie(cookie)
+	req.Header.Set(csrfHeader, csrf)
+	if rec := serve(s, req); rec.Code != http.StatusForbidden {
+		t.Errorf("Import by moderator: %d", rec.Code)
+	}
+
+	cookie, csrf = newTestSession(s, "olga")
+	req = newImportRequest(files)
+	req.AddCookie(cookie)
+	req.Header.Set(csrfHeader, csrf)
+	rec := serve(s, req)
+	if rec.Code != http.StatusOK || rec.Header().Get("Content-Type") != "application/x-ndjson" {
+		t.Fatalf("Could not import %d %q: %s", rec.Code, rec.Header().Get("Content-Type"), rec.Body.String())
+	}
+	var lines []map[string]interface{}
+	scanner := bufio.NewScanner(rec.Body)
+	for scanner.Scan() {
+		var line map[string]interface{}
+		if err := json.Unmarshal(scanner.Bytes(), &line); err != nil {
+			t.Fatalf("Invalid progress line %q: %s", scanner.Text(), err)
+		}
+		lines = append(lines, line)
+	}
+	if len(lines) != 4 {
+		t.Fatalf("Expected 3 results and the summary, got %v", lines)
+	}
+	results := make(map[string]map[string]interface{})
+	for i, line := range lines[:3] {
+		if line["done"] != float64(i+1) || line["total"] != float64(3) {
+			t.Errorf("Wrong progress %v", line)
+		}
+		results[line["file"].(string)] = line
+	}
+	if results["a.jpg"]["photo"] == nil || results["b.jpg"]["duplicate"] != true || results["large.jpg"]["error"] == nil {
+		t.Errorf("Wrong results %v", results)
+	}
+	summary, _ := lines[3]["summary"].(map[string]interface{})
+	if summary["total"] != float64(3) || summary["added"] != float64(1) || summary["duplicates"] != float64(1) || summary["failed"] != float64(1) {
+		t.Errorf("Wrong summary %v", lines[3])
+	}
+	if len(w.Photos()) != 1 {
+		t.Errorf("Wrong photos on the wall: %d", len(w.Photos()))
+	}
+}
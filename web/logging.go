@@ -0,0 +1,77 @@
+package web
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"github.com/blang/photowall/wall"
+	"github.com/gin-gonic/gin"
+	"log/slog"
+	"time"
+)
+
+const (
+	requestIDHeader = "X-Request-ID"
+	requestIDKey    = "request_id"
+	maxRequestID    = 64
+)
+
+func newRequestID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// validRequestID reports whether an id sent by the client is safe to log:
+// up to 64 letters, digits, '-', '_' and '.'
+func validRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestID {
+		return false
+	}
+	for _, r := range id {
+		if !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '-' || r == '_' || r == '.') {
+			return false
+		}
+	}
+	return true
+}
+
+// requestID takes the X-Request-ID of the client, e.g. set by a reverse proxy,
+// or generates one if it's missing or invalid. The id is stored in the gin and
+// request context and returned to the client as X-Request-ID header.
+func requestID() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id := c.GetHeader(requestIDHeader)
+		if !validRequestID(id) {
+			id = newRequestID()
+		}
+		c.Set(requestIDKey, id)
+		c.Request = c.Request.WithContext(wall.WithRequestID(c.Request.Context(), id))
+		c.Header(requestIDHeader, id)
+		c.Next()
+	}
+}
+
+// accessLog replaces gin's default logger with structured records
+func (s *Server) accessLog() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+		c.Next()
+		level := slog.LevelInfo
+		if c.Writer.Status() >= 500 {
+			level = slog.LevelError
+		}
+		s.requestLogger(c).Log(c.Request.Context(), level, "Request",
+			"method", c.Request.Method,
+			"path", c.Request.URL.Path,
+			"status", c.Writer.Status(),
+			"duration", time.Since(start),
+			"client", c.ClientIP(),
+			"bytes", c.Writer.Size(),
+		)
+	}
+}
+
+// requestLogger returns the server logger annotated with the request id
+func (s *Server) requestLogger(c *gin.Context) *slog.Logger {
+	return s.logger.With(requestIDKey, c.GetString(requestIDKey))
+}
@@ -0,0 +1,63 @@
+package web
+
+import (
+	"bytes"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestRequestID(t *testing.T) {
+	s := newTestServer(t, newTestWall())
+	var logs bytes.Buffer
+	s.SetLogger(slog.New(slog.NewTextHandler(&logs, nil)))
+	request := func(id string) string {
+		req := httptest.NewRequest(http.MethodGet, "/api/wall.json", nil)
+		if id != "" {
+			req.Header.Set(requestIDHeader, id)
+		}
+		logs.Reset()
+		rec := serve(s, req)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("Wrong response %d", rec.Code)
+		}
+		return rec.Header().Get(requestIDHeader)
+	}
+
+	generated := request("")
+	if len(generated) != 16 || !validRequestID(generated) {
+		t.Errorf("Wrong generated id %q", generated)
+	}
+	if other := request(""); other == generated {
+		t.Errorf("Same id for two requests %q", other)
+	}
+
+	if id := request("proxy-4f2a.1_b"); id != "proxy-4f2a.1_b" {
+		t.Errorf("Valid id replaced by %q", id)
+	}
+	if !strings.Contains(logs.String(), "request_id=proxy-4f2a.1_b") {
+		t.Errorf("Id not logged: %s", logs.String())
+	}
+
+	for _, invalid := range []string{
+		strings.Repeat("a", maxRequestID+1),
+		"with space",
+		"line\nbreak",
+		`quote"`,
+		"ümlaut",
+		"id=forged level=ERROR",
+	} {
+		id := request(invalid)
+		if id == invalid || len(id) != 16 {
+			t.Errorf("Invalid id %q not replaced: %q", invalid, id)
+		}
+		if strings.Contains(logs.String(), invalid) {
+			t.Errorf("Invalid id %q logged", invalid)
+		}
+	}
+	if id := request(strings.Repeat("a", maxRequestID)); id != strings.Repeat("a", maxRequestID) {
+		t.Errorf("Id of maximum length replaced by %q", id)
+	}
+}
@@ -0,0 +1,129 @@
+package web
+
+import (
+	"github.com/blang/photowall/wall"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// viewerTimeout defines how long a wall display counts as connected after its last poll
+const viewerTimeout = 30 * time.Second
+
+// maxViewers caps the tracked wall displays, further ones aren't counted until others expire
+const maxViewers = 10000
+
+// viewers tracks wall displays by their regular polls of the wall api, keyed by remote address
+type viewers struct {
+	lastSeen map[string]time.Time
+	pruned   time.Time
+	mutex    sync.Mutex
+}
+
+func (v *viewers) seen(client string) {
+	v.mutex.Lock()
+	defer v.mutex.Unlock()
+	now := time.Now()
+	if now.Sub(v.pruned) > viewerTimeout {
+		v.prune(now)
+	}
+	if _, ok := v.lastSeen[client]; ok || len(v.lastSeen) < maxViewers {
+		v.lastSeen[client] = now
+	}
+}
+
+// prune forgets expired viewers, the caller holds the mutex
+func (v *viewers) prune(now time.Time) {
+	for client, t := range v.lastSeen {
+		if now.Sub(t) > viewerTimeout {
+			delete(v.lastSeen, client)
+		}
+	}
+	v.pruned = now
+}
+
+// count returns the amount of connected viewers and forgets expired ones
+func (v *viewers) count() int {
+	v.mutex.Lock()
+	defer v.mutex.Unlock()
+	v.prune(time.Now())
+	return len(v.lastSeen)
+}
+
+// metrics collects server and pipeline metrics exposed in prometheus format
+type metrics struct {
+	registry      *prometheus.Registry
+	procDuration  *prometheus.HistogramVec
+	uploads       *prometheus.CounterVec
+	uploadedBytes prometheus.Counter
+	viewers       *viewers
+}
+
+func newMetrics(w wall.Photowall) *metrics {
+	m := &metrics{
+		registry: prometheus.NewRegistry(),
+		procDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "photowall_processor_duration_seconds",
+			Help:    "Duration of processor runs by processor and outcome.",
+			Buckets: []float64{.01, .05, .1, .25, .5, 1, 2.5, 5, 10, 30},
+		}, []string{"processor", "outcome"}),
+		uploads: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "photowall_uploads_total",
+			Help: "Uploads by result, rejected uploads by reason.",
+		}, []string{"result"}),
+		uploadedBytes: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "photowall_upload_bytes_total",
+			Help: "Bytes received by accepted and rejected uploads.",
+		}),
+		viewers: &viewers{lastSeen: make(map[string]time.Time)},
+	}
+	m.registry.MustRegister(
+		m.procDuration,
+		m.uploads,
+		m.uploadedBytes,
+		prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Name: "photowall_photos",
+			Help: "Photos on the wall.",
+		}, func() float64 {
+			return float64(len(w.Photos()))
+		}),
+		prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Name: "photowall_viewers",
+			Help: "Wall displays polled within the last 30 seconds.",
+		}, func() float64 {
+			return float64(m.viewers.count())
+		}),
+		prometheus.NewGoCollector(),
+		prometheus.NewProcessCollector(prometheus.ProcessCollectorOpts{}),
+	)
+	w.OnProcess(m.observeProcess)
+	return m
+}
+
+func (m *metrics) observeProcess(proc wall.Processor, d time.Duration, err error) {
+	outcome := "ok"
+	if err != nil {
+		outcome = "error"
+	}
+	m.procDuration.WithLabelValues(wall.ProcessorName(proc), outcome).Observe(d.Seconds())
+}
+
+// uploadAccepted counts an upload added to the wall
+func (m *metrics) uploadAccepted(size int64) {
+	m.uploads.WithLabelValues("accepted").Inc()
+	m.uploadedBytes.Add(float64(size))
+}
+
+// uploadRejected counts a failed upload by reason
+func (m *metrics) uploadRejected(reason string, size int64) {
+	m.uploads.WithLabelValues(reason).Inc()
+	if size > 0 {
+		m.uploadedBytes.Add(float64(size))
+	}
+}
+
+func (m *metrics) handler() http.Handler {
+	return promhttp.HandlerFor(m.registry, promhttp.HandlerOpts{})
+}
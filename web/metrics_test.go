@@ -0,0 +1,82 @@
+package web
+
+import (
+	"errors"
+	"fmt"
+	"github.com/blang/photowall/wall"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMetrics(t *testing.T) {
+	w := newTestWall()
+	fail := false
+	w.SetProcessors([]wall.Processor{wall.ProcessorFunc(func(p wall.Photo) (wall.Photo, error) {
+		if fail {
+			return nil, errors.New("failed")
+		}
+		return p, nil
+	})})
+	s := newTestServer(t, w)
+
+	if _, ok := uploaded(t, w, serve(s, newUploadRequest(nil))); !ok {
+		t.Fatal("Upload rejected")
+	}
+	fail = true
+	if _, ok := uploaded(t, w, serve(s, newUploadRequest(nil))); ok {
+		t.Fatal("Failed upload accepted")
+	}
+
+	// Polls of the same address count once, whatever the user agent
+	for i, addr := range []string{"192.0.2.1:1234", "192.0.2.1:5678", "198.51.100.1:1234"} {
+		req := httptest.NewRequest("GET", "/api/wall.json", nil)
+		req.RemoteAddr = addr
+		req.Header.Set("User-Agent", fmt.Sprintf("wall %d", i))
+		if rec := serve(s, req); rec.Code != http.StatusOK {
+			t.Fatalf("Wrong wall response %d", rec.Code)
+		}
+	}
+
+	rec := serve(s, httptest.NewRequest("GET", "/metrics", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Wrong metrics response %d", rec.Code)
+	}
+	for _, line := range []string{
+		`photowall_uploads_total{result="accepted"} 1`,
+		`photowall_uploads_total{result="processing_failed"} 1`,
+		`photowall_processor_duration_seconds_count{outcome="ok",processor="processorfunc"} 1`,
+		`photowall_processor_duration_seconds_count{outcome="error",processor="processorfunc"} 1`,
+		"photowall_photos 1",
+		"photowall_viewers 2",
+	} {
+		if !strings.Contains(rec.Body.String(), line+"\n") {
+			t.Errorf("Missing metric %q", line)
+		}
+	}
+}
+
+func TestViewersBounded(t *testing.T) {
+	v := &viewers{lastSeen: make(map[string]time.Time)}
+	expired := time.Now().Add(-2 * viewerTimeout)
+	for i := 0; i < maxViewers; i++ {
+		v.lastSeen[fmt.Sprintf("expired %d", i)] = expired
+	}
+	v.seen("new")
+	if len(v.lastSeen) != 1 {
+		t.Errorf("Expired viewers kept without scrape: %d", len(v.lastSeen))
+	}
+
+	for i := 0; i < 2*maxViewers; i++ {
+		v.seen(fmt.Sprint(i))
+	}
+	if n := v.count(); n != maxViewers {
+		t.Errorf("Wrong number of viewers %d", n)
+	}
+	v.seen("another")
+	if _, ok := v.lastSeen["another"]; ok {
+		t.Errorf("Viewer beyond the cap tracked")
+	}
+}
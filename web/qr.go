@@ -0,0 +1,111 @@
+package web
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"github.com/skip2/go-qrcode"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+const (
+	defaultQRSize = 512
+	maxQRSize     = 2048
+)
+
+// SetPublicURL sets the base url guests use to reach the photowall, e.g. https://wall.example.com.
+// If empty, the host of each request is used.
+func (s *Server) SetPublicURL(url string) {
+	s.mutexSettings.Lock()
+	s.publicURL = url
+	s.mutexSettings.Unlock()
+}
+
+// baseURL returns the url of the upload page
+func (s *Server) baseURL(c *gin.Context) string {
+	s.mutexSettings.RLock()
+	base := s.publicURL
+	s.mutexSettings.RUnlock()
+	if base == "" {
+		scheme := "http"
+		if c.Request.TLS != nil {
+			scheme = "https"
+		}
+		base = scheme + "://" + c.Request.Host
+	}
+	return strings.TrimSuffix(base, "/") + "/"
+}
+
+// uploadURL returns the link of the upload page for the QR code. The access code is
+// included for the local machine, admins and clients already having a valid code only,
+// so it can't be read from the QR code by everybody reaching the photowall.
+func (s *Server) uploadURL(c *gin.Context) (string, error) {
+	url := s.baseURL(c)
+	if _, ok := s.checkCode(c.Query("code")); !ok && !isLocal(c) {
+		if _, ok = s.admin(c); !ok {
+			return url, nil
+		}
+	}
+	code, err := s.inviteCode()
+	if err != nil || code == "" {
+		return url, err
+	}
+	return url + "?code=" + code, nil
+}
+
+// handleQR renders a QR code of the upload url as PNG (/api/v1/qr.png, size in pixels by the size parameter)
+// or SVG (/api/v1/qr.svg)
+func (s *Server) handleQR(c *gin.Context) {
+	url, err := s.uploadURL(c)
+	if err != nil {
+		s.requestLogger(c).Error("Could not get invite code", "error", err)
+		http.Error(c.Writer, "could not get invite code", http.StatusInternalServerError)
+		return
+	}
+	qr, err := qrcode.New(url, qrcode.Medium)
+	if err != nil {
+		http.Error(c.Writer, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	hash := sha1.Sum([]byte(url))
+	if s.notModified(c, hex.EncodeToString(hash[:8])) {
+		return
+	}
+
+	if strings.HasSuffix(c.Request.URL.Path, ".svg") {
+		c.Data(http.StatusOK, "image/svg+xml", qrSVG(qr.Bitmap()))
+		return
+	}
+	size := defaultQRSize
+	if v := c.Query("size"); v != "" {
+		if size, err = strconv.Atoi(v); err != nil || size < 1 || size > maxQRSize {
+			http.Error(c.Writer, fmt.Sprintf("size must be between 1 and %d", maxQRSize), http.StatusBadRequest)
+			return
+		}
+	}
+	png, err := qr.PNG(size)
+	if err != nil {
+		http.Error(c.Writer, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	c.Data(http.StatusOK, "image/png", png)
+}
+
+// qrSVG draws the modules of a QR code as scalable SVG, one unit per module
+func qrSVG(bitmap [][]bool) []byte {
+	var b strings.Builder
+	fmt.Fprintf(&b, `<svg xmlns="http://www.w3.org/2000/svg" viewBox="0 0 %d %d" shape-rendering="crispEdges">`, len(bitmap), len(bitmap))
+	b.WriteString(`<rect width="100%" height="100%" fill="#fff"/><path fill="#000" d="`)
+	for y, row := range bitmap {
+		for x, dark := range row {
+			if dark {
+				fmt.Fprintf(&b, "M%d %dh1v1h-1z", x, y)
+			}
+		}
+	}
+	b.WriteString(`"/></svg>`)
+	return []byte(b.String())
+}
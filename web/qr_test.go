@@ -0,0 +1,103 @@
+package web
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/hex"
+	"image/png"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestHandleQR(t *testing.T) {
+	s := newTestServer(t, newTestWall())
+
+	rec := serve(s, httptest.NewRequest(http.MethodGet, "/api/v1/qr.png?size=256", nil))
+	if rec.Code != http.StatusOK || rec.Header().Get("Content-Type") != "image/png" {
+		t.Fatalf("Wrong response %d: %s", rec.Code, rec.Header().Get("Content-Type"))
+	}
+	cfg, err := png.DecodeConfig(bytes.NewReader(rec.Body.Bytes()))
+	if err != nil {
+		t.Fatalf("Could not decode png: %s", err)
+	}
+	if cfg.Width != 256 || cfg.Height != 256 {
+		t.Errorf("Wrong size %dx%d", cfg.Width, cfg.Height)
+	}
+	req := httptest.NewRequest(http.MethodGet, "/api/v1/qr.png?size=256", nil)
+	req.Header.Set("If-None-Match", rec.Header().Get("ETag"))
+	if rec = serve(s, req); rec.Code != http.StatusNotModified {
+		t.Errorf("Expected 304, got %d", rec.Code)
+	}
+
+	rec = serve(s, httptest.NewRequest(http.MethodGet, "/api/v1/qr.png", nil))
+	if cfg, err = png.DecodeConfig(bytes.NewReader(rec.Body.Bytes())); err != nil || cfg.Width != defaultQRSize {
+		t.Errorf("Wrong default size %d: %v", cfg.Width, err)
+	}
+	for _, size := range []string{"0", "-1", "abc", "4096"} {
+		if rec = serve(s, httptest.NewRequest(http.MethodGet, "/api/v1/qr.png?size="+size, nil)); rec.Code != http.StatusBadRequest {
+			t.Errorf("Size %s: expected 400, got %d", size, rec.Code)
+		}
+	}
+
+	rec = serve(s, httptest.NewRequest(http.MethodGet, "/api/v1/qr.svg", nil))
+	if rec.Code != http.StatusOK || rec.Header().Get("Content-Type") != "image/svg+xml" {
+		t.Fatalf("Wrong svg response %d: %s", rec.Code, rec.Header().Get("Content-Type"))
+	}
+	if svg := rec.Body.String(); !strings.HasPrefix(svg, "<svg") || !strings.Contains(svg, `viewBox="0 0 `) || !strings.HasSuffix(svg, "</svg>") {
+		t.Errorf("Wrong svg %s", svg)
+	}
+}
+
+func TestQRCodeIncluded(t *testing.T) {
+	s := newTestServer(t, newTestWall())
+	s.SetAccess(AccessPIN, "1234")
+	s.SetAdmins([]Admin{{Name: "anna", Role: RoleDisplay}}, time.Hour)
+	cookie, _ := newTestSession(s, "anna")
+
+	// qrURL returns the url encoded by the QR code, the ETag is derived from it
+	qrURL := func(req *http.Request) string {
+		rec := serve(s, req)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("Wrong response %d", rec.Code)
+		}
+		for _, url := range []string{"http://example.com/", "http://example.com/?code=1234"} {
+			hash := sha1.Sum([]byte(url))
+			if strings.HasPrefix(rec.Header().Get("ETag"), `"`+hex.EncodeToString(hash[:8])+"-") {
+				return url
+			}
+		}
+		t.Fatalf("Unexpected ETag %s", rec.Header().Get("ETag"))
+		return ""
+	}
+
+	if url := qrURL(httptest.NewRequest(http.MethodGet, "/api/v1/qr.svg", nil)); url != "http://example.com/" {
+		t.Errorf("Code included for a remote client: %s", url)
+	}
+	if url := qrURL(httptest.NewRequest(http.MethodGet, "/api/v1/qr.svg?code=wrong", nil)); url != "http://example.com/" {
+		t.Errorf("Code included for a wrong code: %s", url)
+	}
+	if url := qrURL(httptest.NewRequest(http.MethodGet, "/api/v1/qr.svg?code=1234", nil)); url != "http://example.com/?code=1234" {
+		t.Errorf("Code missing for a valid code: %s", url)
+	}
+	req := httptest.NewRequest(http.MethodGet, "/api/v1/qr.svg", nil)
+	req.AddCookie(cookie)
+	if url := qrURL(req); url != "http://example.com/?code=1234" {
+		t.Errorf("Code missing for an admin: %s", url)
+	}
+	req = httptest.NewRequest(http.MethodGet, "/api/v1/qr.svg", nil)
+	req.RemoteAddr = "127.0.0.1:1234"
+	if url := qrURL(req); url != "http://example.com/?code=1234" {
+		t.Errorf("Code missing for the local machine: %s", url)
+	}
+
+	s.SetPublicURL("https://wall.example.com")
+	s.SetAccess(AccessOpen, "")
+	rec := serve(s, httptest.NewRequest(http.MethodGet, "/api/v1/qr.svg", nil))
+	hash := sha1.Sum([]byte("https://wall.example.com/"))
+	if !strings.HasPrefix(rec.Header().Get("ETag"), `"`+hex.EncodeToString(hash[:8])+"-") {
+		t.Errorf("Public url not used: %s", rec.Header().Get("ETag"))
+	}
+}
@@ -0,0 +1,228 @@
+package web
+
+import (
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// deviceCookie identifies the browser of a guest for rate limiting
+const deviceCookie = "photowall_device"
+
+// maxBuckets limits the remembered clients, refilled buckets are dropped beyond it
+const maxBuckets = 10000
+
+// maxQuotaGuests limits the guests counted for the quota, further guests are rejected
+const maxQuotaGuests = 10000
+
+// RateLimitKey defines how guests are told apart
+type RateLimitKey string
+
+const (
+	// RateLimitIP limits by client address, guests behind the same NAT share their limit
+	RateLimitIP RateLimitKey = "ip"
+	// RateLimitDevice limits by a cookie, which scripts may drop to evade the limit
+	RateLimitDevice RateLimitKey = "device"
+	// RateLimitBoth applies the limits to the address and the cookie separately
+	RateLimitBoth RateLimitKey = "both"
+)
+
+// ParseRateLimitKey parses "ip", "device" or "both"
+func ParseRateLimitKey(s string) (RateLimitKey, error) {
+	switch k := RateLimitKey(strings.ToLower(s)); k {
+	case RateLimitIP, RateLimitDevice, RateLimitBoth:
+		return k, nil
+	}
+	return RateLimitIP, fmt.Errorf("unknown rate limit key: %s", s)
+}
+
+// RateLimit configures the upload limits per guest, zero values disable a limit
+type RateLimit struct {
+	Rate  int          // Uploads per minute in the long run
+	Burst int          // Uploads allowed at once before Rate applies
+	Quota int          // Total uploads per guest since start
+	Key   RateLimitKey // How guests are told apart
+}
+
+// bucket holds the uploads a guest may currently do
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// limiter is a token bucket rate limiter counting the uploads per guest,
+// guests without uploads aren't counted
+type limiter struct {
+	limit   RateLimit
+	buckets map[string]*bucket
+	uploads map[string]int
+	mutex   sync.Mutex
+}
+
+func newLimiter() *limiter {
+	return &limiter{
+		limit:   RateLimit{Key: RateLimitIP},
+		buckets: make(map[string]*bucket),
+		uploads: make(map[string]int),
+	}
+}
+
+func (l *limiter) set(limit RateLimit) {
+	l.mutex.Lock()
+	l.limit = limit
+	l.mutex.Unlock()
+}
+
+func (l *limiter) keys(c *gin.Context) []string {
+	l.mutex.Lock()
+	key := l.limit.Key
+	l.mutex.Unlock()
+	var keys []string
+	if key != RateLimitDevice {
+		keys = append(keys, "ip:"+c.RemoteIP())
+	}
+	if key != RateLimitIP {
+		keys = append(keys, "device:"+device(c))
+	}
+	return keys
+}
+
+// device returns the device id of the guest, a new one is set as cookie
+func device(c *gin.Context) string {
+	if id, err := c.Cookie(deviceCookie); err == nil && id != "" {
+		return id
+	}
+	id := randomString(12)
+	c.SetCookie(deviceCookie, id, 365*24*60*60, "/", "", c.Request.TLS != nil, true)
+	return id
+}
+
+// allow takes an upload from the buckets of all keys and reserves it in the quota,
+// a failed upload has to be released. If the quota is used up or a bucket is empty,
+// ok is false and retry tells when the bucket has refilled, zero for an exceeded quota.
+func (l *limiter) allow(keys []string, now time.Time) (retry time.Duration, ok bool) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	if l.limit.Quota > 0 {
+		guests := len(l.uploads)
+		for _, key := range keys {
+			n, found := l.uploads[key]
+			if n >= l.limit.Quota {
+				return 0, false
+			}
+			if !found {
+				guests++
+			}
+		}
+		if guests > maxQuotaGuests {
+			return 0, false
+		}
+	}
+	if l.limit.Rate <= 0 {
+		l.reserve(keys)
+		return 0, true
+	}
+	perSecond := float64(l.limit.Rate) / 60
+	burst := float64(l.limit.Burst)
+	if burst < 1 {
+		burst = 1
+	}
+	if len(l.buckets) > maxBuckets {
+		l.prune(now, perSecond, burst)
+	}
+	var buckets []*bucket
+	for _, key := range keys {
+		b, found := l.buckets[key]
+		if !found {
+			b = &bucket{tokens: burst, last: now}
+			l.buckets[key] = b
+		}
+		b.tokens = math.Min(burst, b.tokens+now.Sub(b.last).Seconds()*perSecond)
+		b.last = now
+		if b.tokens < 1 {
+			wait := time.Duration((1 - b.tokens) / perSecond * float64(time.Second))
+			if wait > retry {
+				retry = wait
+			}
+		}
+		buckets = append(buckets, b)
+	}
+	if retry > 0 {
+		return retry, false
+	}
+	for _, b := range buckets {
+		b.tokens--
+	}
+	l.reserve(keys)
+	return 0, true
+}
+
+// reserve counts an upload against the quota of the guest, the caller holds the lock
+func (l *limiter) reserve(keys []string) {
+	if l.limit.Quota <= 0 {
+		return
+	}
+	for _, key := range keys {
+		l.uploads[key]++
+	}
+}
+
+// prune drops buckets which are full again, the caller holds the lock
+func (l *limiter) prune(now time.Time, perSecond, burst float64) {
+	for key, b := range l.buckets {
+		if b.tokens+now.Sub(b.last).Seconds()*perSecond >= burst {
+			delete(l.buckets, key)
+		}
+	}
+}
+
+// release returns the upload reserved by allow to the quota of the guest
+func (l *limiter) release(keys []string) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	for _, key := range keys {
+		if n, found := l.uploads[key]; found {
+			if n <= 1 {
+				delete(l.uploads, key)
+			} else {
+				l.uploads[key] = n - 1
+			}
+		}
+	}
+}
+
+// SetRateLimit sets the upload limits per guest, uploads of logged in admins are not limited
+func (s *Server) SetRateLimit(limit RateLimit) {
+	s.limiter.set(limit)
+}
+
+// limitUpload rejects the upload with 429 if the guest exceeded a limit and
+// returns the keys of the guest to release the upload if it fails.
+// Only admins logged in by a session are exempt, not the local machine.
+func (s *Server) limitUpload(c *gin.Context) ([]string, bool) {
+	if sess, ok := s.admin(c); ok && sess.id != "" {
+		return nil, true
+	}
+	keys := s.limiter.keys(c)
+	retry, ok := s.limiter.allow(keys, time.Now())
+	if ok {
+		return keys, true
+	}
+	if retry == 0 {
+		s.requestLogger(c).Warn("Upload quota exceeded", "client", keys)
+		s.metrics.uploadRejected("quota_exceeded", 0)
+		http.Error(c.Writer, "upload quota exceeded", http.StatusTooManyRequests)
+		return nil, false
+	}
+	seconds := int(math.Ceil(retry.Seconds()))
+	s.requestLogger(c).Warn("Upload rate limited", "client", keys, "retry_after", seconds)
+	s.metrics.uploadRejected("rate_limited", 0)
+	c.Header("Retry-After", strconv.Itoa(seconds))
+	http.Error(c.Writer, fmt.Sprintf("too many uploads, retry in %d seconds", seconds), http.StatusTooManyRequests)
+	return nil, false
+}
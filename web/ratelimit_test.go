@@ -0,0 +1,119 @@
+package web
+
+import (
+	"net/http"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestUploadRateLimit(t *testing.T) {
+	w := newTestWall()
+	s := newTestServer(t, w)
+	s.SetRateLimit(RateLimit{Rate: 1, Burst: 2, Key: RateLimitIP})
+	for i := 0; i < 2; i++ {
+		if _, ok := uploaded(t, w, serve(s, newUploadRequest(nil))); !ok {
+			t.Fatalf("Upload %d within burst rejected", i)
+		}
+	}
+	rec := serve(s, newUploadRequest(nil))
+	if rec.Code != http.StatusTooManyRequests {
+		t.Fatalf("Expected 429, got %d", rec.Code)
+	}
+	if retry, err := strconv.Atoi(rec.Header().Get("Retry-After")); err != nil || retry < 1 || retry > 60 {
+		t.Errorf("Wrong Retry-After %q", rec.Header().Get("Retry-After"))
+	}
+
+	req := newUploadRequest(nil)
+	req.RemoteAddr = "198.51.100.1:1234"
+	if _, ok := uploaded(t, w, serve(s, req)); !ok {
+		t.Errorf("Upload of another guest rejected")
+	}
+
+	// The local machine is limited like guests, logged in admins are not
+	for i := 0; i < 3; i++ {
+		req = newUploadRequest(nil)
+		req.RemoteAddr = "127.0.0.1:1234"
+		rec = serve(s, req)
+		if i < 2 {
+			uploaded(t, w, rec)
+		}
+	}
+	if rec.Code != http.StatusTooManyRequests {
+		t.Errorf("Local upload not limited: %d", rec.Code)
+	}
+	s.SetAdmins([]Admin{{Name: "anna", Role: RoleDisplay}}, time.Hour)
+	cookie, _ := newTestSession(s, "anna")
+	req = newUploadRequest(nil)
+	req.AddCookie(cookie)
+	if _, ok := uploaded(t, w, serve(s, req)); !ok {
+		t.Errorf("Upload of admin limited")
+	}
+}
+
+func TestUploadQuota(t *testing.T) {
+	w := newTestWall()
+	s := newTestServer(t, w)
+	s.SetRateLimit(RateLimit{Quota: 1, Key: RateLimitDevice})
+	rec := serve(s, newUploadRequest(nil))
+	if _, ok := uploaded(t, w, rec); !ok {
+		t.Fatalf("First upload rejected")
+	}
+	cookies := rec.Result().Cookies()
+	if len(cookies) != 1 || cookies[0].Name != deviceCookie {
+		t.Fatalf("Device cookie not set: %v", cookies)
+	}
+	req := newUploadRequest(nil)
+	req.AddCookie(cookies[0])
+	if rec = serve(s, req); rec.Code != http.StatusTooManyRequests || rec.Header().Get("Retry-After") != "" {
+		t.Errorf("Expected 429 without Retry-After, got %d %q", rec.Code, rec.Header().Get("Retry-After"))
+	}
+	if _, ok := uploaded(t, w, serve(s, newUploadRequest(nil))); !ok {
+		t.Errorf("Upload of a new device rejected")
+	}
+}
+
+func TestLimiterRefill(t *testing.T) {
+	l := newLimiter()
+	l.set(RateLimit{Rate: 60, Burst: 1})
+	now := time.Now()
+	if _, ok := l.allow([]string{"a"}, now); !ok {
+		t.Fatalf("First upload rejected")
+	}
+	retry, ok := l.allow([]string{"a"}, now.Add(500*time.Millisecond))
+	if ok || retry != 500*time.Millisecond {
+		t.Errorf("Wrong retry %s", retry)
+	}
+	if _, ok = l.allow([]string{"a"}, now.Add(time.Second)); !ok {
+		t.Errorf("Upload after refill rejected")
+	}
+}
+
+func TestLimiterQuotaReserved(t *testing.T) {
+	l := newLimiter()
+	l.set(RateLimit{Quota: 1})
+	now := time.Now()
+	if _, ok := l.allow([]string{"a"}, now); !ok {
+		t.Fatalf("First upload rejected")
+	}
+	if _, ok := l.allow([]string{"a"}, now); ok {
+		t.Errorf("Concurrent upload beyond the quota allowed")
+	}
+	l.release([]string{"a"})
+	if len(l.uploads) != 0 {
+		t.Errorf("Released guest still counted: %v", l.uploads)
+	}
+	if _, ok := l.allow([]string{"a"}, now); !ok {
+		t.Errorf("Upload after a failed one rejected")
+	}
+
+	for i := len(l.uploads); i < maxQuotaGuests; i++ {
+		l.uploads[strconv.Itoa(i)] = 0
+	}
+	if _, ok := l.allow([]string{"b"}, now); ok {
+		t.Errorf("Guest beyond the limit counted")
+	}
+	if _, ok := l.allow([]string{"a"}, now); ok {
+		t.Errorf("Quota of a counted guest ignored")
+	}
+}
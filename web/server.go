@@ -1,14 +1,21 @@
 package web
 
 import (
+	"context"
 	"github.com/blang/photowall/wall"
 	"github.com/gin-gonic/gin"
+	"golang.org/x/crypto/acme/autocert"
 	"io"
+	"io/fs"
 	"io/ioutil"
-	"log"
+	"log/slog"
+	"net"
 	"net/http"
+	"os"
 	"path/filepath"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -18,7 +25,33 @@ type Server struct {
 	wall            wall.Photowall
 	maxSize         int64
 	validExtensions map[string]struct{}
+	publicURL       string
+	access          AccessMode
+	pin             string
+	tokens          *Tokens
+	limiter         *limiter
+	admins          map[string]Admin
+	localAdmin      bool   // Without admins, requests of the local machine get an owner session
+	localCSRF       string // CSRF token of the local owner session, new on every start
+	sessionTTL      time.Duration
+	sessions        *sessions
+	deleteWindow    time.Duration
+	deleteSecret    []byte // Signs the delete tokens of guests, new on every start
+	originals       *wall.Originals
+	store           *wall.Store
+	guestExport     bool
 	storageDir      string
+	metrics         *metrics
+	changes         *changeLog
+	events          *broadcaster
+	reload          func() error
+	logger          *slog.Logger
+	http            *http.Server
+	redirect        *http.Server      // Plain http server redirecting to https, see ListenAndRedirect
+	challenges      *autocert.Manager // Answers ACME challenges if certificates are requested by ACME
+	draining        atomic.Bool       // Set on Shutdown to reject new uploads
+	repairing       atomic.Bool       // Set while the store is repaired, see handleAdminRepair
+	mutexSettings   sync.RWMutex      // Guards maxSize, validExtensions, publicURL, access, pin, admins, localAdmin, sessionTTL, deleteWindow and guestExport, which are changed on reloads
 }
 
 func buildValidExtensions(extensions string) map[string]struct{} {
@@ -34,112 +67,248 @@ func buildValidExtensions(extensions string) map[string]struct{} {
 	return extMap
 }
 
-func (s Server) validExtension(name string) (string, bool) {
+func (s *Server) validExtension(name string) (string, bool) {
 	ext := filepath.Ext(name)
 	if ext == "" {
 		return "", false
 	}
 	ext = strings.ToLower(strings.TrimSpace(ext))
 	ext = strings.Replace(ext, ".", "", -1)
-	if _, ok := s.validExtensions[ext]; ok {
+	s.mutexSettings.RLock()
+	_, ok := s.validExtensions[ext]
+	s.mutexSettings.RUnlock()
+	if ok {
 		return ext, true
 	}
 	return "", false
 
 }
 
-// NewServer creates a new Server instance
-func NewServer(wall wall.Photowall, staticDir string, storageDir string, maxSize int64, validExtensions string) *Server {
+// SetLogger sets the structured logger, defaults to slog.Default()
+func (s *Server) SetLogger(l *slog.Logger) {
+	s.logger = l
+}
+
+// SetMaxSize sets the maximum size of uploads in bytes
+func (s *Server) SetMaxSize(maxSize int64) {
+	s.mutexSettings.Lock()
+	s.maxSize = maxSize
+	s.mutexSettings.Unlock()
+}
+
+func (s *Server) getMaxSize() int64 {
+	s.mutexSettings.RLock()
+	defer s.mutexSettings.RUnlock()
+	return s.maxSize
+}
+
+// SetValidExtensions sets the allowed file extensions of uploads, separated by commas
+func (s *Server) SetValidExtensions(validExtensions string) {
+	exts := buildValidExtensions(validExtensions)
+	s.mutexSettings.Lock()
+	s.validExtensions = exts
+	s.mutexSettings.Unlock()
+}
+
+// SetDisplay pushes the display settings to all connected walls
+func (s *Server) SetDisplay(d DisplaySettings) {
+	if err := s.events.publish("settings", d); err != nil {
+		s.logger.Error("Could not publish display settings", "error", err)
+	}
+}
+
+// SetReloader sets the function reloading the configuration, triggered by POST /api/admin/reload
+func (s *Server) SetReloader(reload func() error) {
+	s.reload = reload
+}
+
+// NewServer creates a new Server instance, the frontend is served from static
+func NewServer(wall wall.Photowall, static fs.FS, storageDir string, maxSize int64, validExtensions string) *Server {
 	s := &Server{}
 	s.wall = wall
 	s.maxSize = maxSize
 	s.storageDir = storageDir
 	s.validExtensions = buildValidExtensions(validExtensions)
+	s.metrics = newMetrics(wall)
+	s.changes = newChangeLog(wall)
+	s.events = newBroadcaster()
+	s.access = AccessOpen
+	s.tokens = &Tokens{}
+	s.limiter = newLimiter()
+	s.sessions = newSessions()
+	s.sessionTTL = 12 * time.Hour
+	s.localCSRF = randomString(24)
+	s.deleteSecret = []byte(randomString(32))
+	s.logger = slog.Default()
 
-	router := gin.Default()
+	router := gin.New()
+	router.Use(requestID(), s.accessLog(), gin.Recovery())
 
-	router.Static("/imgs", storageDir)
-	router.Static("/assets", filepath.Join(staticDir, "/assets"))
-	router.StaticFile("/wall", filepath.Join(staticDir, "/wall.html"))
-	router.StaticFile("/admin", filepath.Join(staticDir, "/admin.html"))
-	router.StaticFile("/success", filepath.Join(staticDir, "/success.html"))
-	router.StaticFile("/error", filepath.Join(staticDir, "/error.html"))
-	router.StaticFile("/", filepath.Join(staticDir, "/upload.html"))
+	router.GET("/imgs/:name", s.handleImage)
+	router.HEAD("/imgs/:name", s.handleImage)
+	staticFS := http.FS(static)
+	assets, _ := fs.Sub(static, "assets") // fails for invalid names only
+	router.StaticFS("/assets", http.FS(assets))
+	router.StaticFileFS("/wall", "wall.html", staticFS)
+	router.StaticFileFS("/admin", "admin.html", staticFS)
+	router.StaticFileFS("/success", "success.html", staticFS)
+	router.StaticFileFS("/error", "error.html", staticFS)
+	router.StaticFileFS("/", "upload.html", staticFS)
 	router.POST("/api/upload", s.handleUpload)
 	router.GET("/api/wall.json", s.handleAPIWall)
+	router.GET("/api/photos/:id", s.handleAPIPhoto)
+	router.GET("/api/v1/photos", s.handleAPIPhotos)
+	router.GET("/api/v1/photos/:id", s.handleAPIPhoto)
+	router.GET("/api/v1/events", s.handleEvents)
+	router.GET("/api/v1/qr.png", s.handleQR)
+	router.GET("/api/v1/qr.svg", s.handleQR)
+	router.GET("/api/v1/access", s.handleAccess)
+	router.DELETE("/api/v1/photos/:id", s.handleGuestDelete)
+	router.GET("/api/v1/export.zip", s.handleExport)
+	router.POST("/api/admin/login", s.handleLogin)
+	router.POST("/api/admin/logout", s.handleLogout)
+	router.GET("/api/admin/session", s.handleSession)
+	router.POST("/api/admin/reload", s.requireRole(RoleOwner), s.handleReload)
+	router.GET("/api/admin/tokens", s.requireRole(RoleOwner), s.handleAdminTokens)
+	router.POST("/api/admin/tokens", s.requireRole(RoleOwner), s.handleAdminCreateToken)
+	router.DELETE("/api/admin/tokens/:id", s.requireRole(RoleOwner), s.handleAdminRevokeToken)
+	router.GET("/api/admin/photos", s.requireRole(RoleModerator), s.handleAdminPhotos)
+	router.GET("/api/admin/photos/:id/image", s.requireRole(RoleModerator), s.handleAdminImage)
+	router.POST("/api/admin/photos/:id/approve", s.requireRole(RoleModerator), s.handleAdminApprove)
+	router.POST("/api/admin/photos/:id/hide", s.requireRole(RoleModerator), s.handleAdminHide)
+	router.DELETE("/api/admin/photos/:id", s.requireRole(RoleModerator), s.handleAdminDeletePhoto)
+	router.GET("/api/admin/export.zip", s.requireRole(RoleModerator), s.handleAdminExport)
+	router.POST("/api/admin/import", s.requireRole(RoleOwner), s.handleAdminImport)
+	router.GET("/api/admin/verify", s.requireRole(RoleOwner), s.handleAdminVerify)
+	router.POST("/api/admin/verify/repair", s.requireRole(RoleOwner), s.handleAdminRepair)
+	router.POST("/api/admin/display/:command", s.requireRole(RoleDisplay), s.handleDisplayCommand)
+	router.GET("/metrics", gin.WrapH(s.metrics.handler()))
 	s.Engine = router
+	s.http = &http.Server{Handler: router.Handler()}
+	s.redirect = &http.Server{}
 	return s
 }
 
-type exportPhoto struct {
-	Name      string `json:"name"`
-	Width     int    `json:"width"`
-	Height    int    `json:"height"`
-	CreatedAt string `json:"created_at"`
-}
-
-func exportPhotos(ps wall.Photos) []exportPhoto {
-	var export []exportPhoto
-	wall.SortPhotos(ps)
-	for _, p := range ps {
-		export = append(export, exportPhoto{
-			Name:      filepath.Base(p.Name()),
-			Width:     p.Bounds().Size().X,
-			Height:    p.Bounds().Size().Y,
-			CreatedAt: p.CreatedAt().String(),
-		})
-	}
-	return export
+// ListenAndServe serves the photowall on addr until Shutdown is called,
+// which returns http.ErrServerClosed. It serves https if EnableTLS was called.
+func (s *Server) ListenAndServe(addr string) error {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	if s.http.TLSConfig != nil {
+		s.logger.Info("Listening", "addr", ln.Addr().String(), "tls", true)
+		return s.http.ServeTLS(ln, "", "")
+	}
+	s.logger.Info("Listening", "addr", ln.Addr().String())
+	return s.http.Serve(ln)
 }
 
-func (s Server) handleAPIWall(c *gin.Context) {
-	c.JSON(http.StatusOK, exportPhotos(s.wall.Photos()))
+// Shutdown stops accepting connections and uploads, ends the event streams
+// and waits for running requests like uploads to finish. If ctx expires first,
+// the remaining connections are closed and the ctx error is returned.
+func (s *Server) Shutdown(ctx context.Context) error {
+	s.draining.Store(true)
+	s.events.close()
+	s.redirect.Shutdown(ctx)
+	err := s.http.Shutdown(ctx)
+	if err != nil {
+		s.http.Close()
+	}
+	return err
 }
 
-func (s Server) handleUpload(c *gin.Context) {
-	if c.Request.ContentLength > s.maxSize {
+func (s *Server) handleUpload(c *gin.Context) {
+	logger := s.requestLogger(c)
+	if s.draining.Load() {
+		s.metrics.uploadRejected("shutting_down", 0)
+		c.Header("Retry-After", "30")
+		http.Error(c.Writer, "server is shutting down", http.StatusServiceUnavailable)
+		return
+	}
+	client, ok := s.limitUpload(c)
+	if !ok {
+		return
+	}
+	accepted := false
+	defer func() {
+		if !accepted {
+			s.limiter.release(client)
+		}
+	}()
+	maxSize := s.getMaxSize()
+	if c.Request.ContentLength > maxSize {
+		logger.Warn("Upload too large", "size", c.Request.ContentLength)
+		s.metrics.uploadRejected("too_large", 0)
 		http.Error(c.Writer, "request too large", http.StatusExpectationFailed)
 		return
 	}
-	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, s.maxSize)
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, maxSize)
 	err := c.Request.ParseMultipartForm(1024)
 	if err != nil {
-		log.Printf("Could not get file from form: %s\n", err)
+		logger.Warn("Could not get file from form", "error", err)
+		s.metrics.uploadRejected("invalid_form", 0)
 		http.Redirect(c.Writer, c.Request, "/error", http.StatusFound)
 		return
 	}
 	file, handler, err := c.Request.FormFile("pic")
 	if err != nil {
-		log.Printf("Could not get file from form: %s\n", err)
+		logger.Warn("Could not get file from form", "error", err)
+		s.metrics.uploadRejected("invalid_form", 0)
 		http.Redirect(c.Writer, c.Request, "/error", http.StatusFound)
 		return
 	}
 	defer file.Close()
+	uploader, ok := s.checkCode(c.Request.FormValue("code"))
+	if !ok {
+		logger.Warn("Invalid access code", "filename", handler.Filename)
+		s.metrics.uploadRejected("invalid_code", handler.Size)
+		http.Redirect(c.Writer, c.Request, "/error", http.StatusFound)
+		return
+	}
 	ext, ok := s.validExtension(handler.Filename)
 	if !ok {
-		log.Printf("Invalid file extension: %s", handler.Filename)
+		logger.Warn("Invalid file extension", "filename", handler.Filename)
+		s.metrics.uploadRejected("invalid_extension", handler.Size)
 		http.Redirect(c.Writer, c.Request, "/error", http.StatusFound)
 		return
 	}
 	f, err := ioutil.TempFile("", ext)
 	if err != nil {
-		log.Printf("Could not create file: %s\n", err)
+		logger.Error("Could not create file", "error", err)
+		s.metrics.uploadRejected("io_error", handler.Size)
 		http.Redirect(c.Writer, c.Request, "/error", http.StatusFound)
 		return
 	}
 	defer f.Close()
 	_, err = io.Copy(f, file)
 	if err != nil {
-		log.Printf("File error: %s\n", err)
+		logger.Error("File error", "error", err)
+		os.Remove(f.Name())
+		s.metrics.uploadRejected("io_error", handler.Size)
 		http.Redirect(c.Writer, c.Request, "/error", http.StatusFound)
 		return
 	}
 
-	err = s.wall.AddPhotoFromFile(f.Name(), time.Now())
+	info := wall.PhotoInfo{
+		Caption:  sanitizeText(c.Request.FormValue("caption"), maxCaptionLength),
+		Author:   sanitizeText(c.Request.FormValue("author"), maxAuthorLength),
+		Uploader: uploader,
+		Filename: safeFilename(filepath.Base(handler.Filename)),
+	}
+	photo := wall.NewPhotoWithInfo(f.Name(), 0, 0, "", time.Now(), info)
+	err = s.wall.AddPhoto(c.Request.Context(), photo)
 	if err != nil {
+		logger.Warn("Could not add photo", "filename", handler.Filename, "error", err)
+		// Failed uploads are not consumed by the pipeline, quarantined ones are already gone
+		os.Remove(f.Name())
+		s.metrics.uploadRejected("processing_failed", handler.Size)
 		http.Redirect(c.Writer, c.Request, "/error", http.StatusFound)
 		return
 	}
 
-	http.Redirect(c.Writer, c.Request, "/success", http.StatusFound)
+	logger.Info("Upload accepted", "filename", handler.Filename, "size", handler.Size, "uploader", uploader, "photo", photo.ID())
+	s.metrics.uploadAccepted(handler.Size)
+	accepted = true
+	http.Redirect(c.Writer, c.Request, s.successURL(photo.ID()), http.StatusFound)
 }
@@ -0,0 +1,182 @@
+package web
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"github.com/blang/photowall/wall"
+	"github.com/gin-gonic/gin"
+	"io"
+	"io/ioutil"
+	"log/slog"
+	"mime/multipart"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"testing/fstest"
+	"time"
+)
+
+// newTestServer creates a server with a minimal frontend, storing into a new tmp dir
+func newTestServer(t *testing.T, w wall.Photowall) *Server {
+	gin.SetMode(gin.TestMode)
+	dir, err := ioutil.TempDir("", "web")
+	if err != nil {
+		t.Fatalf("Could not create tmp dir: %s", err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	static := fstest.MapFS{}
+	for _, name := range []string{"upload.html", "wall.html", "admin.html", "success.html", "error.html", "assets/app.js"} {
+		static[name] = &fstest.MapFile{Data: []byte(name)}
+	}
+	s := NewServer(w, static, dir, 10*1024*1024, "jpg,png")
+	s.SetLogger(slog.New(slog.NewTextHandler(io.Discard, nil)))
+	return s
+}
+
+// serve runs the request against the server, httptest requests come from a remote client
+func serve(s *Server, req *http.Request) *httptest.ResponseRecorder {
+	rec := httptest.NewRecorder()
+	s.ServeHTTP(rec, req)
+	return rec
+}
+
+// newTestWall creates a wall without processors, photos are added as they are
+func newTestWall() *wall.Wall {
+	w := wall.Create()
+	w.SetProcessors(nil)
+	w.SetLogger(slog.New(slog.NewTextHandler(io.Discard, nil)))
+	return w
+}
+
+// addTestPhotos adds n published photos created one second apart, without files
+func addTestPhotos(t *testing.T, w wall.Photowall, n int, start time.Time) []wall.Photo {
+	var photos []wall.Photo
+	for i := 0; i < n; i++ {
+		p := wall.NewPhotoWithInfo(fmt.Sprintf("/nonexistent/%04d.jpg", i), 100, 100, "jpg", start.Add(time.Duration(i)*time.Second), wall.PhotoInfo{})
+		if err := w.AddPhoto(context.Background(), p); err != nil {
+			t.Fatalf("Could not add photo: %s", err)
+		}
+		photos = append(photos, p)
+	}
+	return photos
+}
+
+// newTestSession starts a session of the admin name, who must be set by SetAdmins.
+// It returns the session cookie and the CSRF token.
+func newTestSession(s *Server, name string) (*http.Cookie, string) {
+	sess := s.sessions.create(name, time.Hour)
+	return &http.Cookie{Name: sessionCookie, Value: sess.id}, sess.csrf
+}
+
+// newUploadRequest creates an upload of a small jpg file with the given form fields
+func newUploadRequest(fields map[string]string) *http.Request {
+	var body bytes.Buffer
+	form := multipart.NewWriter(&body)
+	for k, v := range fields {
+		form.WriteField(k, v)
+	}
+	file, _ := form.CreateFormFile("pic", "photo.jpg")
+	file.Write([]byte("not really a jpg"))
+	form.Close()
+	req := httptest.NewRequest(http.MethodPost, "/api/upload", &body)
+	req.Header.Set("Content-Type", form.FormDataContentType())
+	return req
+}
+
+// uploaded reports whether the upload was accepted, the uploaded file is removed again
+func uploaded(t *testing.T, w wall.Photowall, rec *httptest.ResponseRecorder) (wall.Photo, bool) {
+	if rec.Code != http.StatusFound {
+		t.Fatalf("Wrong upload response %d: %s", rec.Code, rec.Body.String())
+	}
+	if !strings.HasPrefix(rec.Header().Get("Location"), "/success") {
+		return nil, false
+	}
+	photos := w.Photos()
+	p := photos[len(photos)-1]
+	t.Cleanup(func() { os.Remove(p.Name()) })
+	return p, true
+}
+
+func TestShutdown(t *testing.T) {
+	w := newTestWall()
+	started, release := make(chan struct{}), make(chan struct{})
+	w.SetProcessors([]wall.Processor{wall.ProcessorFunc(func(p wall.Photo) (wall.Photo, error) {
+		close(started)
+		<-release
+		return p, nil
+	})})
+	s := newTestServer(t, w)
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Could not listen: %s", err)
+	}
+	addr := ln.Addr().String()
+	ln.Close()
+	served := make(chan error, 1)
+	go func() { served <- s.ListenAndServe(addr) }()
+	var events *http.Response
+	for i := 0; i < 50; i++ {
+		if events, err = http.Get("http://" + addr + "/api/v1/events"); err == nil {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if err != nil {
+		t.Fatalf("Could not connect: %s", err)
+	}
+	defer events.Body.Close()
+
+	client := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }}
+	upload := newUploadRequest(nil)
+	req, _ := http.NewRequest(http.MethodPost, "http://"+addr+"/api/upload", upload.Body)
+	req.Header.Set("Content-Type", upload.Header.Get("Content-Type"))
+	uploadDone := make(chan *http.Response, 1)
+	go func() {
+		resp, err := client.Do(req)
+		if err != nil {
+			t.Errorf("Upload failed: %s", err)
+		}
+		uploadDone <- resp
+	}()
+	<-started
+
+	shutdown := make(chan error, 1)
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		shutdown <- s.Shutdown(ctx)
+	}()
+	for !s.draining.Load() {
+		time.Sleep(time.Millisecond)
+	}
+	rec := serve(s, newUploadRequest(nil))
+	if rec.Code != http.StatusServiceUnavailable || rec.Header().Get("Retry-After") == "" {
+		t.Errorf("Expected 503 with Retry-After, got %d %q", rec.Code, rec.Header().Get("Retry-After"))
+	}
+	if _, err = ioutil.ReadAll(events.Body); err != nil {
+		t.Errorf("Event stream not ended: %s", err)
+	}
+	select {
+	case err = <-shutdown:
+		t.Fatalf("Shutdown returned while an upload is processed: %v", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	close(release)
+	if resp := <-uploadDone; resp == nil || resp.StatusCode != http.StatusFound || !strings.HasPrefix(resp.Header.Get("Location"), "/success") {
+		t.Errorf("Running upload not finished: %v", resp)
+	}
+	for _, p := range w.Photos() {
+		os.Remove(p.Name())
+	}
+	if err = <-shutdown; err != nil {
+		t.Errorf("Could not shut down: %s", err)
+	}
+	if err = <-served; err != http.ErrServerClosed {
+		t.Errorf("Wrong serve result: %v", err)
+	}
+}
@@ -0,0 +1,32 @@
+package web
+
+import (
+	"errors"
+	"io/fs"
+)
+
+type overlayFS struct {
+	upper fs.FS
+	lower fs.FS
+}
+
+// Overlay returns a filesystem serving files of upper, falling back to lower
+// for missing ones. It's used to customize parts of the embedded frontend.
+// Names leaving the root like "../x" are rejected for both.
+func Overlay(upper, lower fs.FS) fs.FS {
+	return overlayFS{upper: upper, lower: lower}
+}
+
+func (o overlayFS) Open(name string) (fs.File, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrInvalid}
+	}
+	f, err := o.upper.Open(name)
+	if err == nil {
+		return f, nil
+	}
+	if !errors.Is(err, fs.ErrNotExist) {
+		return nil, err
+	}
+	return o.lower.Open(name)
+}
@@ -0,0 +1,64 @@
+package web
+
+import (
+	"errors"
+	"io/fs"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"testing/fstest"
+)
+
+func TestOverlay(t *testing.T) {
+	dir, err := ioutil.TempDir("", "static")
+	if err != nil {
+		t.Fatalf("Could not create tmp dir: %s", err)
+	}
+	defer os.RemoveAll(dir)
+	upper := filepath.Join(dir, "static")
+	os.MkdirAll(filepath.Join(upper, "assets"), 0755)
+	ioutil.WriteFile(filepath.Join(upper, "upload.html"), []byte("custom upload"), 0644)
+	ioutil.WriteFile(filepath.Join(upper, "assets", "logo.png"), []byte("custom logo"), 0644)
+	ioutil.WriteFile(filepath.Join(dir, "secret.txt"), []byte("secret"), 0644)
+	embedded := fstest.MapFS{
+		"upload.html":   {Data: []byte("embedded upload")},
+		"wall.html":     {Data: []byte("embedded wall")},
+		"assets/app.js": {Data: []byte("embedded app")},
+		"secret.txt":    {Data: []byte("embedded secret")},
+	}
+	o := Overlay(os.DirFS(upper), embedded)
+
+	for name, want := range map[string]string{
+		"upload.html":     "custom upload",
+		"assets/logo.png": "custom logo",
+		"wall.html":       "embedded wall",
+		"assets/app.js":   "embedded app",
+	} {
+		if b, err := fs.ReadFile(o, name); err != nil || string(b) != want {
+			t.Errorf("%s: expected %q, got %q %v", name, want, b, err)
+		}
+	}
+	if _, err = o.Open("missing.html"); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("Missing file found: %v", err)
+	}
+	for _, name := range []string{"../secret.txt", "assets/../../secret.txt", "/secret.txt", "assets/../upload.html"} {
+		if b, err := fs.ReadFile(o, name); !errors.Is(err, fs.ErrInvalid) {
+			t.Errorf("%s: traversal not rejected: %q %v", name, b, err)
+		}
+	}
+
+	// Served paths are cleaned before opening, they can't leave the root either
+	server := http.FileServer(http.FS(o))
+	for _, target := range []string{"/../secret.txt", "/assets/../../secret.txt"} {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.URL.Path = target
+		rec := httptest.NewRecorder()
+		server.ServeHTTP(rec, req)
+		if rec.Body.String() == "secret" {
+			t.Errorf("%s: file outside the overlay served", target)
+		}
+	}
+}
@@ -0,0 +1,59 @@
+package web
+
+import (
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+const (
+	maxCaptionLength = 140
+	maxAuthorLength  = 40
+	maxFileLength    = 100
+)
+
+// sanitizeText cleans free text entered by guests: invalid utf-8, control and
+// bidi control characters are dropped, whitespace is collapsed and the text is
+// cut to max runes. HTML is escaped by the frontend when rendering.
+func sanitizeText(s string, max int) string {
+	s = strings.ToValidUTF8(s, "")
+	var b strings.Builder
+	space := false
+	n := 0
+	for _, r := range strings.TrimSpace(s) {
+		if n >= max {
+			break
+		}
+		if unicode.IsSpace(r) {
+			space = true
+			continue
+		}
+		if unicode.IsControl(r) || unicode.Is(unicode.Bidi_Control, r) || r == utf8.RuneError {
+			continue
+		}
+		if space {
+			// A space is only kept followed by a rune, not at the cut
+			if n+2 > max {
+				break
+			}
+			b.WriteRune(' ')
+			n++
+			space = false
+		}
+		b.WriteRune(r)
+		n++
+	}
+	return b.String()
+}
+
+// safeFilename turns guest text like a caption into a file name valid on common file systems
+func safeFilename(s string) string {
+	s = sanitizeText(s, maxFileLength)
+	s = strings.Map(func(r rune) rune {
+		if strings.ContainsRune(`/\:*?"<>|`, r) {
+			return '_'
+		}
+		return r
+	}, s)
+	return strings.Trim(s, " .")
+}
@@ -0,0 +1,53 @@
+package web
+
+import (
+	"testing"
+	"unicode/utf8"
+)
+
+func TestSanitizeText(t *testing.T) {
+	for _, tc := range []struct {
+		in   string
+		max  int
+		want string
+	}{
+		{"Happy birthday!", 140, "Happy birthday!"},
+		{"  spaced \t\n out  ", 140, "spaced out"},
+		{"bell\a and\x00 nul\x7f", 140, "bell and nul"},
+		{"evil\u202egpj.exe", 140, "evilgpj.exe"},
+		{"\u2066isolated\u2069 \u200fmark", 140, "isolated mark"},
+		{"in\xffvalid\xc3", 140, "invalid"},
+		{"abcdef", 3, "abc"},
+		{"ab cd", 3, "ab"},
+		{"ab  cd", 4, "ab c"},
+		{"äöü😀x", 4, "äöü😀"},
+		{"", 10, ""},
+	} {
+		got := sanitizeText(tc.in, tc.max)
+		if got != tc.want {
+			t.Errorf("sanitizeText(%q, %d): expected %q, got %q", tc.in, tc.max, tc.want, got)
+		}
+		if !utf8.ValidString(got) || utf8.RuneCountInString(got) > tc.max {
+			t.Errorf("sanitizeText(%q, %d): invalid result %q", tc.in, tc.max, got)
+		}
+	}
+}
+
+func TestSafeFilename(t *testing.T) {
+	for _, tc := range []struct {
+		in, want string
+	}{
+		{"photo.jpg", "photo.jpg"},
+		{"party/photo.jpg", "party_photo.jpg"},
+		{`C:\Users\photo.jpg`, "C__Users_photo.jpg"},
+		{"../../etc/passwd", "_.._etc_passwd"},
+		{"..", ""},
+		{" . ", ""},
+		{"", ""},
+		{`what?*"<>|`, "what______"},
+	} {
+		if got := safeFilename(tc.in); got != tc.want {
+			t.Errorf("safeFilename(%q): expected %q, got %q", tc.in, tc.want, got)
+		}
+	}
+}
@@ -0,0 +1,276 @@
+package web
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"golang.org/x/crypto/acme"
+	"golang.org/x/crypto/acme/autocert"
+	"io"
+	"math/big"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// selfSignedValidity defines how long a generated certificate is valid, it's renewed on start after expiry
+const selfSignedValidity = 365 * 24 * time.Hour
+
+// TLSOptions configure where the server gets its certificate from,
+// exactly one of CertFile/KeyFile, SelfSigned or ACMEDomains must be set
+type TLSOptions struct {
+	CertFile string
+	KeyFile  string
+
+	// SelfSigned generates a certificate for all local addresses on first start, stored in CacheDir
+	SelfSigned bool
+
+	ACMEDomains   []string // Domains to request certificates for, e.g. by Let's Encrypt
+	ACMEEmail     string   // Contact address for the ACME account, optional
+	ACMEDirectory string   // Directory URL of the ACME server, defaults to Let's Encrypt
+	ACMERootCA    string   // PEM file with the CA of the ACME server, for test servers like Pebble
+
+	CacheDir string // Directory for generated certificates and the ACME account
+}
+
+// Enabled reports whether any certificate source is configured
+func (o TLSOptions) Enabled() bool {
+	return o.CertFile != "" || o.SelfSigned || len(o.ACMEDomains) > 0
+}
+
+// EnableTLS makes ListenAndServe serve https with the certificate configured by o
+func (s *Server) EnableTLS(o TLSOptions) error {
+	switch {
+	case o.CertFile != "":
+		cert, err := tls.LoadX509KeyPair(o.CertFile, o.KeyFile)
+		if err != nil {
+			return err
+		}
+		s.http.TLSConfig = &tls.Config{Certificates: []tls.Certificate{cert}}
+	case o.SelfSigned:
+		cert, err := loadSelfSigned(o.CacheDir)
+		if err != nil {
+			return err
+		}
+		s.http.TLSConfig = &tls.Config{Certificates: []tls.Certificate{cert}}
+	case len(o.ACMEDomains) > 0:
+		m, err := acmeManager(o)
+		if err != nil {
+			return err
+		}
+		s.http.TLSConfig = m.TLSConfig()
+		s.challenges = m
+	default:
+		return errors.New("no certificate configured")
+	}
+	s.http.TLSConfig.MinVersion = tls.VersionTLS12
+	return nil
+}
+
+func acmeManager(o TLSOptions) (*autocert.Manager, error) {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if o.ACMERootCA != "" {
+		pem, err := os.ReadFile(o.ACMERootCA)
+		if err != nil {
+			return nil, err
+		}
+		roots := x509.NewCertPool()
+		if !roots.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in %s", o.ACMERootCA)
+		}
+		transport.TLSClientConfig = &tls.Config{RootCAs: roots}
+	}
+	client := &acme.Client{
+		DirectoryURL: o.ACMEDirectory,
+		HTTPClient: &http.Client{Transport: &orderLocations{
+			next:   transport,
+			orders: make(map[string]string),
+		}},
+	}
+	return &autocert.Manager{
+		Prompt:     autocert.AcceptTOS,
+		Cache:      autocert.DirCache(filepath.Join(o.CacheDir, "acme")),
+		HostPolicy: autocert.HostWhitelist(o.ACMEDomains...),
+		Email:      o.ACMEEmail,
+		Client:     client,
+	}, nil
+}
+
+// orderLocations adds the order URL as Location header to finalize responses missing it.
+// The acme client polls the order by this header, but it's optional and servers
+// finalizing asynchronously like Pebble leave it out. Orders are remembered by
+// their finalize URL when they are created and forgotten once finalized; at
+// most maxOrderLocations are kept for orders that are never finalized.
+type orderLocations struct {
+	next   http.RoundTripper
+	orders map[string]string // finalize URL to order URL
+	queue  []string          // finalize URLs, oldest first
+	mutex  sync.Mutex
+}
+
+const maxOrderLocations = 64
+
+func (t *orderLocations) RoundTrip(req *http.Request) (*http.Response, error) {
+	res, err := t.next.RoundTrip(req)
+	if err != nil || req.Method != http.MethodPost {
+		return res, err
+	}
+	location := res.Header.Get("Location")
+	if location == "" {
+		t.mutex.Lock()
+		order, ok := t.orders[req.URL.String()]
+		if ok && res.StatusCode == http.StatusOK {
+			delete(t.orders, req.URL.String())
+		}
+		t.mutex.Unlock()
+		if ok {
+			res.Header.Set("Location", order)
+		}
+		return res, nil
+	}
+
+	body, err := io.ReadAll(res.Body)
+	res.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	res.Body = io.NopCloser(bytes.NewReader(body))
+	var order struct {
+		Finalize string `json:"finalize"`
+	}
+	if json.Unmarshal(body, &order) == nil && order.Finalize != "" {
+		t.remember(order.Finalize, location)
+	}
+	return res, nil
+}
+
+func (t *orderLocations) remember(finalize, order string) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	if _, ok := t.orders[finalize]; !ok {
+		t.queue = append(t.queue, finalize)
+	}
+	t.orders[finalize] = order
+	for len(t.orders) > maxOrderLocations {
+		delete(t.orders, t.queue[0])
+		t.queue = t.queue[1:]
+	}
+	if len(t.queue) > 2*maxOrderLocations {
+		// Drop finalized orders from the queue.
+		queue := t.queue[:0]
+		for _, f := range t.queue {
+			if _, ok := t.orders[f]; ok {
+				queue = append(queue, f)
+			}
+		}
+		t.queue = queue
+	}
+}
+
+// ListenAndRedirect serves plain http on addr, redirecting all requests to
+// the https server on httpsAddr. ACME http-01 challenges are answered as well.
+// It returns http.ErrServerClosed after Shutdown.
+func (s *Server) ListenAndRedirect(addr, httpsAddr string) error {
+	_, port, err := net.SplitHostPort(httpsAddr)
+	if err != nil {
+		return err
+	}
+	var handler http.Handler = redirectHandler(port)
+	if s.challenges != nil {
+		handler = s.challenges.HTTPHandler(handler)
+	}
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	s.logger.Info("Redirecting to https", "addr", ln.Addr().String())
+	s.redirect.Handler = handler
+	return s.redirect.Serve(ln)
+}
+
+// redirectHandler redirects to the same host and path on the https port
+func redirectHandler(port string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host, _, err := net.SplitHostPort(r.Host)
+		if err != nil {
+			host = r.Host
+		}
+		if port != "443" {
+			host = net.JoinHostPort(host, port)
+		}
+		http.Redirect(w, r, "https://"+host+r.URL.RequestURI(), http.StatusMovedPermanently)
+	})
+}
+
+// loadSelfSigned loads the self-signed certificate from dir and generates
+// a new one if it's missing or expired
+func loadSelfSigned(dir string) (tls.Certificate, error) {
+	certFile := filepath.Join(dir, "selfsigned.crt")
+	keyFile := filepath.Join(dir, "selfsigned.key")
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err == nil && time.Now().Before(cert.Leaf.NotAfter) {
+		return cert, nil
+	}
+	if err = os.MkdirAll(dir, 0700); err != nil {
+		return tls.Certificate{}, err
+	}
+	if err = generateSelfSigned(certFile, keyFile); err != nil {
+		return tls.Certificate{}, err
+	}
+	return tls.LoadX509KeyPair(certFile, keyFile)
+}
+
+// generateSelfSigned writes a certificate valid for the hostname,
+// localhost and all addresses of the local interfaces
+func generateSelfSigned(certFile, keyFile string) error {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return err
+	}
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return err
+	}
+	template := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{Organization: []string{"Photowall"}, CommonName: "photowall"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(selfSignedValidity),
+		KeyUsage:              x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		DNSNames:              []string{"localhost"},
+	}
+	if hostname, err := os.Hostname(); err == nil {
+		template.DNSNames = append(template.DNSNames, hostname)
+	}
+	addrs, _ := net.InterfaceAddrs()
+	for _, addr := range addrs {
+		if ipnet, ok := addr.(*net.IPNet); ok {
+			template.IPAddresses = append(template.IPAddresses, ipnet.IP)
+		}
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return err
+	}
+	keyDER, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return err
+	}
+	if err = os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDER}), 0600); err != nil {
+		return err
+	}
+	return os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0644)
+}
@@ -0,0 +1,272 @@
+package web
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+// acmeStandIn is a minimal ACME server finalizing orders asynchronously like Pebble:
+// the finalize response has no Location header and the order has to be polled.
+// Authorizations are valid from the start and signatures aren't checked.
+type acmeStandIn struct {
+	*httptest.Server
+	caKey  *ecdsa.PrivateKey
+	ca     *x509.Certificate
+	mutex  sync.Mutex
+	orders int
+	certs  map[int][]byte // order number to issued certificate
+}
+
+func newACMEStandIn(t *testing.T) *acmeStandIn {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Could not generate CA key: %s", err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "stand-in CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("Could not create CA: %s", err)
+	}
+	ca, _ := x509.ParseCertificate(der)
+	a := &acmeStandIn{caKey: key, ca: ca, certs: make(map[int][]byte)}
+	a.Server = httptest.NewTLSServer(a)
+	t.Cleanup(a.Close)
+	return a
+}
+
+func (a *acmeStandIn) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Replay-Nonce", fmt.Sprint(time.Now().UnixNano()))
+	w.Header().Set("Content-Type", "application/json")
+	var n int
+	switch {
+	case r.URL.Path == "/dir":
+		json.NewEncoder(w).Encode(map[string]string{
+			"newNonce":   a.URL + "/nonce",
+			"newAccount": a.URL + "/account",
+			"newOrder":   a.URL + "/order",
+		})
+	case r.URL.Path == "/nonce":
+		w.WriteHeader(http.StatusOK)
+	case r.URL.Path == "/account":
+		w.Header().Set("Location", a.URL+"/account/1")
+		w.WriteHeader(http.StatusCreated)
+		fmt.Fprint(w, `{"status":"valid"}`)
+	case r.URL.Path == "/order":
+		a.mutex.Lock()
+		a.orders++
+		n = a.orders
+		a.mutex.Unlock()
+		w.Header().Set("Location", fmt.Sprintf("%s/order/%d", a.URL, n))
+		w.WriteHeader(http.StatusCreated)
+		fmt.Fprintf(w, `{"status":"ready","finalize":"%s/finalize/%d"}`, a.URL, n)
+	case scan(r.URL.Path, "/finalize/%d", &n):
+		var jws struct{ Payload string }
+		var req struct{ CSR string }
+		json.NewDecoder(r.Body).Decode(&jws)
+		payload, _ := base64.RawURLEncoding.DecodeString(jws.Payload)
+		json.Unmarshal(payload, &req)
+		der, _ := base64.RawURLEncoding.DecodeString(req.CSR)
+		cert, err := a.issue(der)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		a.mutex.Lock()
+		a.certs[n] = cert
+		a.mutex.Unlock()
+		fmt.Fprint(w, `{"status":"processing"}`)
+	case scan(r.URL.Path, "/order/%d", &n):
+		a.mutex.Lock()
+		_, issued := a.certs[n]
+		a.mutex.Unlock()
+		if !issued {
+			fmt.Fprint(w, `{"status":"ready"}`)
+			return
+		}
+		fmt.Fprintf(w, `{"status":"valid","certificate":"%s/cert/%d"}`, a.URL, n)
+	case scan(r.URL.Path, "/cert/%d", &n):
+		a.mutex.Lock()
+		cert := a.certs[n]
+		a.mutex.Unlock()
+		w.Header().Set("Content-Type", "application/pem-certificate-chain")
+		pem.Encode(w, &pem.Block{Type: "CERTIFICATE", Bytes: cert})
+		pem.Encode(w, &pem.Block{Type: "CERTIFICATE", Bytes: a.ca.Raw})
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func scan(path, format string, n *int) bool {
+	_, err := fmt.Sscanf(path, format, n)
+	return err == nil
+}
+
+func (a *acmeStandIn) issue(der []byte) ([]byte, error) {
+	csr, err := x509.ParseCertificateRequest(der)
+	if err != nil {
+		return nil, err
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      csr.Subject,
+		DNSNames:     csr.DNSNames,
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	return x509.CreateCertificate(rand.Reader, tmpl, a.ca, csr.PublicKey, a.caKey)
+}
+
+func TestACMEManager(t *testing.T) {
+	server := newACMEStandIn(t)
+	dir, err := ioutil.TempDir("", "acme")
+	if err != nil {
+		t.Fatalf("Could not create tmp dir: %s", err)
+	}
+	defer os.RemoveAll(dir)
+	root := filepath.Join(dir, "root.pem")
+	ioutil.WriteFile(root, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw}), 0644)
+
+	m, err := acmeManager(TLSOptions{
+		ACMEDomains:   []string{"photos.example.com"},
+		ACMEDirectory: server.URL + "/dir",
+		ACMERootCA:    root,
+		CacheDir:      dir,
+	})
+	if err != nil {
+		t.Fatalf("Could not create manager: %s", err)
+	}
+	cert, err := m.GetCertificate(&tls.ClientHelloInfo{ServerName: "photos.example.com"})
+	if err != nil {
+		t.Fatalf("Could not get certificate: %s", err)
+	}
+	if err := cert.Leaf.VerifyHostname("photos.example.com"); err != nil {
+		t.Errorf("Wrong certificate: %s", err)
+	}
+	if _, err := m.GetCertificate(&tls.ClientHelloInfo{ServerName: "other.example.com"}); err == nil {
+		t.Errorf("Certificate for a domain not configured")
+	}
+	if orders := m.Client.HTTPClient.Transport.(*orderLocations).orders; len(orders) != 0 {
+		t.Errorf("Finalized orders still remembered: %v", orders)
+	}
+
+	if _, err := acmeManager(TLSOptions{ACMERootCA: filepath.Join(dir, "missing.pem")}); err == nil {
+		t.Errorf("Missing root CA accepted")
+	}
+}
+
+func TestOrderLocationsBounded(t *testing.T) {
+	o := &orderLocations{orders: make(map[string]string)}
+	for i := 0; i < 3*maxOrderLocations; i++ {
+		o.remember(fmt.Sprintf("finalize/%d", i), fmt.Sprintf("order/%d", i))
+	}
+	if len(o.orders) != maxOrderLocations || len(o.queue) > 2*maxOrderLocations {
+		t.Errorf("Wrong number of remembered orders %d, queued %d", len(o.orders), len(o.queue))
+	}
+	last := fmt.Sprintf("finalize/%d", 3*maxOrderLocations-1)
+	if _, ok := o.orders[last]; !ok {
+		t.Errorf("Newest order forgotten")
+	}
+	if _, ok := o.orders["finalize/0"]; ok {
+		t.Errorf("Oldest order kept")
+	}
+}
+
+func TestSelfSigned(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "tls")
+	if err != nil {
+		t.Fatalf("Could not create tmp dir: %s", err)
+	}
+	defer os.RemoveAll(tmp)
+	dir := filepath.Join(tmp, "tls")
+	cert, err := loadSelfSigned(dir)
+	if err != nil {
+		t.Fatalf("Could not generate certificate: %s", err)
+	}
+	for _, host := range []string{"localhost", "127.0.0.1"} {
+		if err = cert.Leaf.VerifyHostname(host); err != nil {
+			t.Errorf("Certificate not valid for %s: %s", host, err)
+		}
+	}
+	if time.Until(cert.Leaf.NotAfter) < selfSignedValidity-time.Hour {
+		t.Errorf("Wrong validity until %s", cert.Leaf.NotAfter)
+	}
+	if info, err := os.Stat(filepath.Join(dir, "selfsigned.key")); err != nil {
+		t.Errorf("Key not written: %s", err)
+	} else if info.Mode().Perm() != 0600 {
+		t.Errorf("Key not private: %v", info.Mode())
+	}
+
+	again, err := loadSelfSigned(dir)
+	if err != nil || again.Leaf.SerialNumber.Cmp(cert.Leaf.SerialNumber) != 0 {
+		t.Errorf("Certificate not reused: %v", err)
+	}
+
+	// Expired certificates are replaced
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		NotBefore:    time.Now().Add(-2 * time.Hour),
+		NotAfter:     time.Now().Add(-time.Hour),
+		DNSNames:     []string{"localhost"},
+	}
+	der, _ := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	keyDER, _ := x509.MarshalPKCS8PrivateKey(key)
+	ioutil.WriteFile(filepath.Join(dir, "selfsigned.crt"), pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0644)
+	ioutil.WriteFile(filepath.Join(dir, "selfsigned.key"), pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDER}), 0600)
+	renewed, err := loadSelfSigned(dir)
+	if err != nil || !time.Now().Before(renewed.Leaf.NotAfter) {
+		t.Errorf("Expired certificate not replaced: %v", err)
+	}
+
+	ioutil.WriteFile(filepath.Join(dir, "selfsigned.crt"), []byte("broken"), 0644)
+	if _, err = loadSelfSigned(dir); err != nil {
+		t.Errorf("Broken certificate not replaced: %s", err)
+	}
+}
+
+func TestRedirectHandler(t *testing.T) {
+	for _, tc := range []struct {
+		port, host, target, location string
+	}{
+		{"443", "photos.example.com", "/", "https://photos.example.com/"},
+		{"443", "photos.example.com:80", "/upload.html?code=abc", "https://photos.example.com/upload.html?code=abc"},
+		{"8443", "photos.example.com:8080", "/api/v1/photos?limit=5&order=desc", "https://photos.example.com:8443/api/v1/photos?limit=5&order=desc"},
+		{"8443", "192.168.1.10", "/wall.html", "https://192.168.1.10:8443/wall.html"},
+		{"8443", "[fe80::1]:8080", "/a%2Fb", "https://[fe80::1]:8443/a%2Fb"},
+	} {
+		req := httptest.NewRequest(http.MethodGet, tc.target, nil)
+		req.Host = tc.host
+		rec := httptest.NewRecorder()
+		redirectHandler(tc.port).ServeHTTP(rec, req)
+		if rec.Code != http.StatusMovedPermanently || rec.Header().Get("Location") != tc.location {
+			t.Errorf("%s%s: expected redirect to %s, got %d %s", tc.host, tc.target, tc.location, rec.Code, rec.Header().Get("Location"))
+		}
+	}
+}
@@ -0,0 +1,62 @@
+package web
+
+import (
+	"github.com/blang/photowall/wall"
+	"github.com/gin-gonic/gin"
+	"net/http"
+)
+
+// SetStore sets the store checked by the verify endpoints
+func (s *Server) SetStore(store *wall.Store) {
+	s.store = store
+}
+
+// handleAdminVerify checks the store against the metadata and returns the problems found
+func (s *Server) handleAdminVerify(c *gin.Context) {
+	if s.store == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "no store"})
+		return
+	}
+	problems, err := s.store.Verify(s.originals)
+	if err != nil {
+		s.requestLogger(c).Error("Verify failed", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "verify failed"})
+		return
+	}
+	s.requestLogger(c).Info("Store verified", "problems", len(problems), "admin", adminName(c))
+	c.JSON(http.StatusOK, gin.H{"problems": nonNil(problems)})
+}
+
+// handleAdminRepair checks the store like handleAdminVerify and repairs the problems
+// through the processors. The problems are returned with the repairs.
+func (s *Server) handleAdminRepair(c *gin.Context) {
+	if s.store == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "no store"})
+		return
+	}
+	if !s.repairing.CompareAndSwap(false, true) {
+		c.JSON(http.StatusConflict, gin.H{"error": "repair running"})
+		return
+	}
+	defer s.repairing.Store(false)
+	logger := s.requestLogger(c)
+	problems, err := s.store.Verify(s.originals)
+	if err != nil {
+		logger.Error("Verify failed", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "verify failed"})
+		return
+	}
+	problems = s.store.Repair(c.Request.Context(), s.wall, s.originals, problems)
+	for _, p := range problems {
+		logger.Warn("Store repaired", "kind", p.Kind, "file", p.File, "id", p.ID, "repair", p.Repair)
+	}
+	logger.Info("Store repair finished", "problems", len(problems), "admin", adminName(c))
+	c.JSON(http.StatusOK, gin.H{"problems": nonNil(problems)})
+}
+
+func nonNil(problems []wall.Problem) []wall.Problem {
+	if problems == nil {
+		return []wall.Problem{}
+	}
+	return problems
+}
@@ -0,0 +1,71 @@
+package web
+
+import (
+	"encoding/json"
+	"github.com/blang/photowall/wall"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestHandleVerify(t *testing.T) {
+	s := newTestServer(t, newTestWall())
+	s.SetAdmins([]Admin{{Name: "olga", Role: RoleOwner}, {Name: "mia", Role: RoleModerator}}, time.Hour)
+	owner, csrf := newTestSession(s, "olga")
+	moderator, _ := newTestSession(s, "mia")
+	request := func(method, target string, cookie *http.Cookie) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, target, nil)
+		if cookie != nil {
+			req.AddCookie(cookie)
+			req.Header.Set(csrfHeader, csrf)
+		}
+		return serve(s, req)
+	}
+
+	if rec := request(http.MethodGet, "/api/admin/verify", owner); rec.Code != http.StatusNotFound {
+		t.Errorf("Verify without store: %d", rec.Code)
+	}
+	s.SetStore(wall.NewStore(s.storageDir))
+	stray := filepath.Join(s.storageDir, "stray.jpg")
+	if err := ioutil.WriteFile(stray, []byte("no jpeg"), 0644); err != nil {
+		t.Fatalf("Could not create file: %s", err)
+	}
+
+	if rec := request(http.MethodGet, "/api/admin/verify", nil); rec.Code != http.StatusUnauthorized {
+		t.Errorf("Verify without session: %d", rec.Code)
+	}
+	if rec := request(http.MethodGet, "/api/admin/verify", moderator); rec.Code != http.StatusForbidden {
+		t.Errorf("Verify by moderator: %d", rec.Code)
+	}
+	rec := request(http.MethodGet, "/api/admin/verify", owner)
+	var report struct{ Problems []wall.Problem }
+	if err := json.Unmarshal(rec.Body.Bytes(), &report); rec.Code != http.StatusOK || err != nil {
+		t.Fatalf("Could not verify %d: %s", rec.Code, rec.Body.String())
+	}
+	if len(report.Problems) != 1 || report.Problems[0].Kind != wall.ProblemUnreferenced || report.Problems[0].File != stray {
+		t.Errorf("Wrong problems %+v", report.Problems)
+	}
+
+	if rec = request(http.MethodPost, "/api/admin/verify/repair", moderator); rec.Code != http.StatusForbidden {
+		t.Errorf("Repair by moderator: %d", rec.Code)
+	}
+	s.repairing.Store(true)
+	if rec = request(http.MethodPost, "/api/admin/verify/repair", owner); rec.Code != http.StatusConflict {
+		t.Errorf("Concurrent repair: %d", rec.Code)
+	}
+	s.repairing.Store(false)
+	rec = request(http.MethodPost, "/api/admin/verify/repair", owner)
+	report.Problems = nil
+	if err := json.Unmarshal(rec.Body.Bytes(), &report); rec.Code != http.StatusOK || err != nil {
+		t.Fatalf("Could not repair %d: %s", rec.Code, rec.Body.String())
+	}
+	if len(report.Problems) != 1 || report.Problems[0].File != stray || report.Problems[0].Repair == "" {
+		t.Errorf("Wrong repairs %+v", report.Problems)
+	}
+	if s.repairing.Load() {
+		t.Errorf("Repair still marked running")
+	}
+}